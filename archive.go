@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// isArchive reports whether the input argument names a diagram archive rather than a directory
+func isArchive(input string) bool {
+	lower := strings.ToLower(input)
+	return strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// extractArchive unpacks the .svg and renderable source entries of a .zip or .tar.gz archive into
+// a new temporary directory under tempParent ("" for the system default), which the
+// caller must remove. Entries are flattened to their base names, so two with the same
+// base name are an error; entries larger than maxSize bytes are rejected.
+func extractArchive(archive, tempParent string, maxSize int64) (string, error) {
+	dir, err := makeTempDir(tempParent, "svg-stacker-archive-*")
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		err = extractZip(archive, dir, maxSize)
+	} else {
+		err = extractTarGz(archive, dir, maxSize)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("extracting %s: %w", archive, err)
+	}
+	return dir, nil
+}
+
+func extractZip(archive, dir string, maxSize int64) error {
+	r, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	written := make(map[string]string)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !isDiagramSource(f.Name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeArchiveEntry(dir, f.Name, rc, maxSize, written)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTarGz(archive, dir string, maxSize int64) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	written := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || !isDiagramSource(header.Name) {
+			continue
+		}
+		if err := writeArchiveEntry(dir, header.Name, tr, maxSize, written); err != nil {
+			return err
+		}
+	}
+}
+
+// isDiagramSource reports whether an archive entry is a file svg-stacker reads.
+// Dotfiles other than the ignore file, and anything under a dot or __MACOSX
+// directory, are not, which leaves out the "._" resource forks macOS adds to zips.
+func isDiagramSource(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." && part != ignoreFileName || part == "__MACOSX" {
+			return false
+		}
+	}
+	return path.Base(name) == ignoreFileName || strings.EqualFold(path.Ext(name), ".svg") || isRenderedSource(name)
+}
+
+// writeArchiveEntry copies an entry into dir under its base name, which also keeps
+// entries such as "../x.svg" from escaping dir. written maps the base names used
+// so far to their entries, so one can't silently replace another.
+func writeArchiveEntry(dir, name string, r io.Reader, maxSize int64, written map[string]string) error {
+	base := path.Base(name)
+	if first, ok := written[base]; ok {
+		return fmt.Errorf("%s and %s would both be extracted as %s", first, name, base)
+	}
+	written[base] = name
+
+	out, err := os.Create(filepath.Join(dir, base))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	n, err := io.Copy(out, io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return err
+	}
+	if n > maxSize {
+		return fmt.Errorf("%s is larger than the %d byte limit (raise it with --max-file-size)", name, maxSize)
+	}
+	return out.Close()
+}
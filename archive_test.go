@@ -0,0 +1,169 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const archiveTestSVG = `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g></g></svg>`
+
+var archiveTestEntries = map[string]string{
+	"diagrams/context.svg": archiveTestSVG,
+	"../container.svg":     archiveTestSVG,
+	"diagrams/README.txt":  "not a diagram",
+}
+
+func writeTestZip(t *testing.T, name string, entries map[string]string) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for entry, content := range entries {
+		w, err := zw.Create(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestTarGz(t *testing.T, name string, entries map[string]string) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "diagrams/", Typeflag: tar.TypeDir, Mode: 0755})
+	for entry, content := range entries {
+		tw.WriteHeader(&tar.Header{Name: entry, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateStackedSVGFromArchive(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		write func(*testing.T, string, map[string]string)
+	}{
+		{"zip", "diagrams.zip", writeTestZip},
+		{"tar.gz", "diagrams.tar.gz", writeTestTarGz},
+		{"tgz", "DIAGRAMS.TGZ", writeTestTarGz},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, tt.file)
+			tt.write(t, archive, archiveTestEntries)
+
+			output := filepath.Join(dir, "out.svg")
+			if err := NewSVGStacker(archive, output, "").CreateStackedSVG(); err != nil {
+				t.Fatalf("CreateStackedSVG failed: %v", err)
+			}
+			data, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{`id="diagram-context"`, `id="diagram-container"`} {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("expected %s in output", want)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "container.svg")); !os.IsNotExist(err) {
+				t.Errorf("entry escaped the extraction directory")
+			}
+		})
+	}
+}
+
+func TestExtractArchiveMaxFileSize(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "diagrams.zip")
+	writeTestZip(t, archive, map[string]string{"context.svg": archiveTestSVG})
+
+	if _, err := extractArchive(archive, "", 10); err == nil || !strings.Contains(err.Error(), "context.svg") {
+		t.Errorf("expected an error naming the oversized entry, got %v", err)
+	}
+
+	dir, err := extractArchive(archive, "", int64(len(archiveTestSVG)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := os.Stat(filepath.Join(dir, "context.svg")); err != nil {
+		t.Errorf("expected context.svg to be extracted: %v", err)
+	}
+}
+
+func TestArchiveIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "diagrams.zip")
+	writeTestZip(t, archive, map[string]string{
+		"diagrams/context.svg":        archiveTestSVG,
+		"diagrams/container.svg":      archiveTestSVG,
+		"diagrams/.svg-stackerignore": "container.svg\n",
+	})
+
+	output := filepath.Join(dir, "out.svg")
+	if err := NewSVGStacker(archive, output, "").CreateStackedSVG(); err != nil {
+		t.Fatalf("CreateStackedSVG failed: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `id="diagram-context"`) {
+		t.Errorf("expected the context diagram in the output")
+	}
+	if strings.Contains(string(data), `id="diagram-container"`) {
+		t.Errorf("expected container.svg to be ignored by the archive's %s", ignoreFileName)
+	}
+}
+
+func TestExtractArchiveSkipsHiddenAndRejectsDuplicates(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "diagrams.zip")
+	writeTestZip(t, archive, map[string]string{
+		"diagrams/01-context.svg":            archiveTestSVG,
+		"__MACOSX/diagrams/._01-context.svg": "\x00\x05\x16\x07 resource fork",
+		"diagrams/.draft.svg":                "not XML",
+	})
+	dir, err := extractArchive(archive, "", 1<<20)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "01-context.svg" {
+		t.Errorf("expected only 01-context.svg to be extracted, got %v", entries)
+	}
+
+	writeTestTarGz(t, archive+".tgz", map[string]string{
+		"old/context.svg": archiveTestSVG,
+		"new/context.svg": archiveTestSVG,
+	})
+	if _, err := extractArchive(archive+".tgz", "", 1<<20); err == nil || !strings.Contains(err.Error(), "both be extracted as context.svg") {
+		t.Errorf("expected entries with the same base name to be rejected, got %v", err)
+	}
+}
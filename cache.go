@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// renderCache keeps the SVG rendered from each source file under dir, keyed by a
+// hash of the file's content, so later runs re-render only the files that changed.
+// Files a source pulls in with !include are not part of the key.
+type renderCache struct {
+	dir  string
+	salt string // distinguishes renderers whose output differs for the same source
+}
+
+// key returns the cache file name for a source file's current content
+func (c *renderCache) key(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(content)
+	h.Write([]byte{0})
+	h.Write([]byte(c.salt))
+	return hex.EncodeToString(h.Sum(nil)) + ".svg", nil
+}
+
+// renderedName is the name a renderer gives the SVG for a source file
+func renderedName(file string) string {
+	return strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) + ".svg"
+}
+
+// restore copies the cached SVG of each unchanged file into outDir and returns
+// the files that still need rendering
+func (c *renderCache) restore(files []string, outDir string) ([]string, error) {
+	var changed []string
+	for _, file := range files {
+		key, err := c.key(file)
+		if err != nil {
+			return nil, err
+		}
+		svg, err := os.ReadFile(filepath.Join(c.dir, key))
+		if os.IsNotExist(err) {
+			changed = append(changed, file)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		logger.Debugf("%s is unchanged, using its cached SVG\n", filepath.Base(file))
+		if err := os.WriteFile(filepath.Join(outDir, renderedName(file)), svg, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return changed, nil
+}
+
+// store caches the SVGs rendered into outDir for files; files that didn't render
+// are skipped
+func (c *renderCache) store(files []string, outDir string) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+	for _, file := range files {
+		svg, err := os.ReadFile(filepath.Join(outDir, renderedName(file)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		key, err := c.key(file)
+		if err != nil {
+			return err
+		}
+		// Write then rename so a concurrent run never reads a partial file
+		tmp, err := os.CreateTemp(c.dir, "*.tmp")
+		if err != nil {
+			return fmt.Errorf("cache: %w", err)
+		}
+		_, err = tmp.Write(svg)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), filepath.Join(c.dir, key))
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return fmt.Errorf("cache: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderPlantUMLCache(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		io.WriteString(w, `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g/></svg>`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"01-context.puml", "02-container.puml", "03-component.puml"} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte("@startuml\n"+name+"\n@enduml"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	opts := Options{PlantUMLServer: server.URL, CacheDir: filepath.Join(t.TempDir(), "cache")}
+
+	render := func() {
+		t.Helper()
+		requests = 0
+		outDir := t.TempDir()
+		if err := renderPlantUML(context.Background(), files, outDir, opts); err != nil {
+			t.Fatalf("render failed: %v", err)
+		}
+		for _, file := range files {
+			if _, err := os.Stat(filepath.Join(outDir, renderedName(file))); err != nil {
+				t.Errorf("expected %s to be rendered or restored: %v", renderedName(file), err)
+			}
+		}
+	}
+
+	render()
+	if requests != 3 {
+		t.Errorf("first run: expected 3 renders, got %d", requests)
+	}
+	render()
+	if requests != 0 {
+		t.Errorf("unchanged sources: expected no renders, got %d", requests)
+	}
+	if err := os.WriteFile(files[1], []byte("@startuml\nchanged\n@enduml"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	render()
+	if requests != 1 {
+		t.Errorf("one changed source: expected 1 render, got %d", requests)
+	}
+
+	// A different server is a different renderer, so nothing is reused
+	other := httptest.NewServer(server.Config.Handler)
+	defer other.Close()
+	opts.PlantUMLServer = other.URL
+	render()
+	if requests != 3 {
+		t.Errorf("other renderer: expected 3 renders, got %d", requests)
+	}
+
+	if opts, err := parseArgsSlice([]string{dir, "--cache-dir", ".svg-cache"}); err != nil || opts.CacheDir != ".svg-cache" {
+		t.Errorf("--cache-dir: got %q, %v", opts.CacheDir, err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DiffOptions holds the configuration for the 'diff' subcommand
+type DiffOptions struct {
+	OldFile string  // previously generated stacked SVG
+	Stacker Options // how to regenerate it; InputDir is the diagram directory
+}
+
+// parseDiffArgs parses the arguments following 'diff': the old stacked SVG, --dir
+// and any stacking options, which are passed on as for serve
+func parseDiffArgs(args []string) (DiffOptions, error) {
+	var opts DiffOptions
+	var dir string
+	var rest []string
+	var err error
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--dir":
+			if dir, err = flagValue(args, &i); err != nil {
+				return DiffOptions{}, err
+			}
+		case opts.OldFile == "" && !strings.HasPrefix(args[i], "-"):
+			opts.OldFile = args[i]
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	if opts.OldFile == "" {
+		return DiffOptions{}, fmt.Errorf("diff requires the stacked SVG to compare against")
+	}
+	if dir == "" {
+		return DiffOptions{}, fmt.Errorf("diff requires --dir")
+	}
+	if opts.Stacker, err = parseArgsSlice(append([]string{dir}, rest...)); err != nil {
+		return DiffOptions{}, err
+	}
+	if outputFlagged(opts.Stacker) {
+		return DiffOptions{}, fmt.Errorf("--output and --output-dir cannot be used with diff")
+	}
+	opts.Stacker.OutputFile = ""
+	return opts, nil
+}
+
+// LevelDiff lists the levels that differ between two stacked SVGs, by layer key
+type LevelDiff struct {
+	Added, Removed, Changed []string
+}
+
+func (d LevelDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// runDiffCommand regenerates the stacked SVG and writes a summary of how its levels
+// differ from the old file to w. It reports whether there were any differences.
+func runDiffCommand(opts DiffOptions, w io.Writer) (bool, error) {
+	old, err := os.ReadFile(opts.OldFile)
+	if err != nil {
+		return false, err
+	}
+	oldLevels, err := stackedLevels(string(old))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", opts.OldFile, err)
+	}
+
+	generated, err := NewSVGStackerWithOptions(opts.Stacker).Generate()
+	if err != nil {
+		return false, err
+	}
+	newLevels, err := stackedLevels(generated)
+	if err != nil {
+		return false, err
+	}
+
+	diff := diffLevels(oldLevels, newLevels)
+	if diff.empty() {
+		fmt.Fprintf(w, "%s is up to date\n", opts.OldFile)
+		return false, nil
+	}
+	diff.write(w)
+	return true, nil
+}
+
+// write lists the levels that differ, one per line
+func (d LevelDiff) write(w io.Writer) {
+	for _, change := range []struct {
+		label  string
+		levels []string
+	}{{"added", d.Added}, {"removed", d.Removed}, {"changed", d.Changed}} {
+		for _, level := range change.levels {
+			fmt.Fprintf(w, "%-8s %s\n", change.label+":", level)
+		}
+	}
+}
+
+// checkOutput implements --check: instead of writing the output file, it compares
+// it with the generated SVG, ignoring the timestamp as --if-changed does. A stale
+// or missing file is an exitDiffers error, after logging how the levels differ.
+func (s *SVGStacker) checkOutput(generated string) error {
+	existing, err := os.ReadFile(s.outputFile)
+	if os.IsNotExist(err) {
+		return withExitCode(exitDiffers, fmt.Errorf("%s does not exist; generate it without --check", s.outputFile))
+	} else if err != nil {
+		return err
+	}
+	if contentHash(string(existing)) == contentHash(generated) {
+		logger.Infof("%s is up to date\n", s.outputFile)
+		return nil
+	}
+
+	// The generated SVG always parses; an old one that doesn't is just reported stale
+	oldLevels, err := stackedLevels(string(existing))
+	newLevels, _ := stackedLevels(generated)
+	switch diff := diffLevels(oldLevels, newLevels); {
+	case err != nil:
+		logger.Errorf("%s: %v\n", s.outputFile, err)
+	case diff.empty():
+		logger.Errorf("The diagrams are the same, but the title, layout or options changed\n")
+	default:
+		var summary strings.Builder
+		diff.write(&summary)
+		logger.Errorf("%s", summary.String())
+	}
+	return withExitCode(exitDiffers, fmt.Errorf("%s is out of date; regenerate it without --check", s.outputFile))
+}
+
+// diffLevels compares the per-level content of two stacked SVGs
+func diffLevels(oldLevels, newLevels map[string]string) LevelDiff {
+	var diff LevelDiff
+	for key, content := range newLevels {
+		if oldContent, ok := oldLevels[key]; !ok {
+			diff.Added = append(diff.Added, key)
+		} else if oldContent != content {
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range oldLevels {
+		if _, ok := newLevels[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	slices.Sort(diff.Added)
+	slices.Sort(diff.Removed)
+	slices.Sort(diff.Changed)
+	return diff
+}
+
+// betweenTagsRegex matches the whitespace between two tags
+var betweenTagsRegex = regexp.MustCompile(`>\s+<`)
+
+// stackedLevels extracts the diagram content of each layer of a stacked SVG, keyed
+// by level (with the stack prefix when there are several). Whitespace between
+// tags is dropped so indentation changes don't count as differences.
+func stackedLevels(svg string) (map[string]string, error) {
+	levels := make(map[string]string)
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return levels, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "g" {
+			continue
+		}
+		for _, attr := range start.Attr {
+			if key, ok := strings.CutPrefix(attr.Value, "diagram-"); ok && attr.Name.Local == "id" {
+				begin := decoder.InputOffset()
+				if err := decoder.Skip(); err != nil {
+					return nil, err
+				}
+				levels[key] = betweenTagsRegex.ReplaceAllString(strings.TrimSpace(svg[begin:decoder.InputOffset()]), "><")
+			}
+		}
+	}
+}
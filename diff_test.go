@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseDiffArgs(t *testing.T) {
+	opts, err := parseDiffArgs([]string{"old.svg", "--dir", "docs/c4", "--title", "Shop"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.OldFile != "old.svg" || opts.Stacker.InputDir != "docs/c4" || opts.Stacker.Title != "Shop" {
+		t.Errorf("got %+v", opts)
+	}
+
+	for _, args := range [][]string{
+		{"--dir", "docs/c4"},
+		{"old.svg"},
+		{"old.svg", "--dir", "docs/c4", "--output", "x.svg"},
+	} {
+		if _, err := parseDiffArgs(args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRunDiffCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeDiagram := func(name, id string) {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g id="` + id + `"/></svg>`
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeDiagram("context.svg", "a")
+	writeDiagram("container.svg", "b")
+	writeDiagram("component.svg", "c")
+
+	old := filepath.Join(t.TempDir(), "stacked.svg")
+	if err := NewSVGStackerWithOptions(Options{InputDir: dir, OutputFile: old}).CreateStackedSVG(); err != nil {
+		t.Fatal(err)
+	}
+	opts := DiffOptions{OldFile: old, Stacker: Options{InputDir: dir}}
+
+	var out bytes.Buffer
+	if differs, err := runDiffCommand(opts, &out); err != nil || differs {
+		t.Fatalf("expected no differences against a fresh build, got %v, %v: %s", differs, err, out.String())
+	}
+
+	writeDiagram("container.svg", "changed")
+	os.Remove(filepath.Join(dir, "component.svg"))
+	writeDiagram("code.svg", "d")
+
+	out.Reset()
+	differs, err := runDiffCommand(opts, &out)
+	if err != nil || !differs {
+		t.Fatalf("expected differences, got %v, %v", differs, err)
+	}
+	if want := "added:   code\nremoved: component\nchanged: container\n"; out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	if _, err := parseArgsSlice([]string{"dir", "--check"}); err == nil {
+		t.Errorf("expected --check without an output file to be rejected")
+	}
+
+	defer func(l *Logger) { logger = l }(logger)
+	var buf bytes.Buffer
+	logger = &Logger{level: LogNormal, out: &buf}
+
+	dir := t.TempDir()
+	writeDiagram := func(id string) {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g id="` + id + `"/></svg>`
+		if err := os.WriteFile(filepath.Join(dir, "context.svg"), []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(t.TempDir(), "stacked.svg")
+	check := func() error {
+		return NewSVGStackerWithOptions(Options{InputDir: dir, OutputFile: output, Check: true}).CreateStackedSVG()
+	}
+
+	writeDiagram("a")
+	if err := check(); exitCodeFor(err) != exitDiffers {
+		t.Errorf("expected a missing output to fail the check, got %v", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("expected --check not to write the output")
+	}
+
+	if err := NewSVGStackerWithOptions(Options{InputDir: dir, OutputFile: output}).CreateStackedSVG(); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(); err != nil {
+		t.Errorf("expected a fresh build to pass the check, got %v", err)
+	}
+
+	buf.Reset()
+	writeDiagram("b")
+	err := check()
+	if exitCodeFor(err) != exitDiffers {
+		t.Errorf("expected a stale output to fail the check, got %v", err)
+	}
+	if want := "changed: context\n"; buf.String() != want {
+		t.Errorf("got summary %q, want %q", buf.String(), want)
+	}
+}
+
+func TestStackedLevelsIgnoresIndentation(t *testing.T) {
+	a := `<svg><g id="layer-context"><g id="diagram-context"><svg><rect x="1"/>
+        <text>hi</text></svg></g></g><g id="diagram-code"><svg/></g></svg>`
+	b := `<svg><g id="layer-context"><g id="diagram-context">
+  <svg> <rect x="1"/> <text>hi</text> </svg>
+</g></g></svg>`
+
+	levelsA, err := stackedLevels(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	levelsB, err := stackedLevels(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := diffLevels(levelsA, levelsB); !reflect.DeepEqual(got, LevelDiff{Removed: []string{"code"}}) {
+		t.Errorf("got %+v", got)
+	}
+}
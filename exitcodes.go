@@ -0,0 +1,40 @@
+package main
+
+import "errors"
+
+// Exit codes let scripts tell failure modes apart; they are listed in printUsage
+const (
+	exitOK           = 0
+	exitFailure      = 1 // usage errors and anything not covered below
+	exitNoInputs     = 2 // no C4 diagrams (or too few numbered .puml files) in the input directory
+	exitToolMissing  = 3 // an external tool such as plantuml is not installed
+	exitMalformed    = 4 // an input SVG is not well-formed or has no usable <svg> element
+	exitDiffers      = 5 // diff or --check found levels that changed
+	exitLintWarnings = 6 // lint found only warnings
+	exitLintErrors   = 7 // lint found problems that would break or change the output
+)
+
+// exitError attaches an exit code to an error without changing its message
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	return &exitError{code: code, err: err}
+}
+
+// exitCodeFor returns the exit code for an error returned by CreateStackedSVG
+func exitCodeFor(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitFailure
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestExitCodeFor tests that CreateStackedSVG failures map to distinct exit codes
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		path   string // PATH override, so plantuml can't be found
+		expect int
+	}{
+		{"no diagrams", map[string]string{"readme.txt": "hello"}, "", exitNoInputs},
+		{"too few puml files", map[string]string{"01-context.puml": "@startuml\n@enduml\n"}, "", exitNoInputs},
+		{"plantuml missing", map[string]string{
+			"01-context.puml":   "@startuml\n@enduml\n",
+			"02-container.puml": "@startuml\n@enduml\n",
+			"03-component.puml": "@startuml\n@enduml\n",
+		}, t.TempDir(), exitToolMissing},
+		{"invalid XML", map[string]string{"context.svg": `<svg viewBox="0 0 10 10"><g></svg>`}, "", exitMalformed},
+		{"no svg element", map[string]string{"context.svg": `<html></html>`}, "", exitMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.path != "" {
+				t.Setenv("PATH", tt.path)
+			}
+
+			err := NewSVGStacker(dir, filepath.Join(dir, "out.svg"), "").CreateStackedSVG()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := exitCodeFor(err); got != tt.expect {
+				t.Errorf("exitCodeFor(%v): got %d, want %d", err, got, tt.expect)
+			}
+		})
+	}
+
+	if got := exitCodeFor(errors.New("disk full")); got != exitFailure {
+		t.Errorf("uncategorised error: got %d, want %d", got, exitFailure)
+	}
+	if got := exitCodeFor(nil); got != exitOK {
+		t.Errorf("nil error: got %d, want %d", got, exitOK)
+	}
+}
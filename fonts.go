@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fontFormats maps font file extensions to their @font-face format and MIME type
+var fontFormats = map[string]struct{ format, mime string }{
+	".woff2": {"woff2", "font/woff2"},
+	".woff":  {"woff", "font/woff"},
+	".ttf":   {"truetype", "font/ttf"},
+	".otf":   {"opentype", "font/otf"},
+}
+
+// fontFaceCSS builds @font-face rules embedding each font as a base64 data URI.
+// Each spec is a font file path, optionally prefixed with "Family=" to set the
+// family name; otherwise the file name without its extension is used.
+func fontFaceCSS(specs []string) (string, error) {
+	var sb strings.Builder
+	for _, spec := range specs {
+		family, path, found := strings.Cut(spec, "=")
+		if !found {
+			path = spec
+			family = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		format, ok := fontFormats[ext]
+		if !ok {
+			return "", fmt.Errorf("%s: unsupported font type %q (use .woff2, .woff, .ttf or .otf)", path, ext)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading font: %w", err)
+		}
+
+		sb.WriteString(fmt.Sprintf(`
+    @font-face {
+      font-family: %s;
+      src: url("data:%s;base64,%s") format("%s");
+    }
+`, cssString(family), format.mime, base64.StdEncoding.EncodeToString(data), format.format))
+	}
+	return sb.String(), nil
+}
+
+// cssString quotes s as a CSS string; markup characters are escaped so the result
+// is also safe inside the <style> element
+func cssString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\' || r == '<' || r == '>' || r == '&' || r < 0x20:
+			fmt.Fprintf(&b, `\%x `, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFontFaceCSS(t *testing.T) {
+	dir := t.TempDir()
+	woff2 := filepath.Join(dir, "Inter-Regular.woff2")
+	if err := os.WriteFile(woff2, []byte("wOF2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	css, err := fontFaceCSS([]string{woff2, "Brand Sans=" + woff2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		`font-family: "Inter-Regular";`,
+		`font-family: "Brand Sans";`,
+		`src: url("data:font/woff2;base64,d09GMg==") format("woff2");`,
+	} {
+		if !strings.Contains(css, want) {
+			t.Errorf("expected %s in:\n%s", want, css)
+		}
+	}
+
+	if _, err := fontFaceCSS([]string{filepath.Join(dir, "font.svg")}); err == nil {
+		t.Errorf("expected error for unsupported font type")
+	}
+	if _, err := fontFaceCSS([]string{filepath.Join(dir, "missing.ttf")}); err == nil {
+		t.Errorf("expected error for missing font file")
+	}
+}
+
+func TestCSSString(t *testing.T) {
+	if got := cssString(`a"b</style>`); got != `"a\22 b\3c /style\3e "` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestEmbedFontsInOutput(t *testing.T) {
+	dir := t.TempDir()
+	inputDir := filepath.Join(dir, "input")
+	if err := os.Mkdir(inputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	createTestSVGFiles(t, inputDir)
+	font := filepath.Join(dir, "Brand.ttf")
+	if err := os.WriteFile(font, []byte("font"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputFile := filepath.Join(dir, "out.svg")
+	opts, err := parseArgsSlice([]string{inputDir, "--output", outputFile, "--embed-fonts", font, "--embed-fonts", "Other=" + font})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := NewSVGStackerWithOptions(opts).CreateStackedSVG(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(content), "@font-face") != 2 {
+		t.Errorf("expected two @font-face rules in output")
+	}
+	if err := ValidateXML(string(content)); err != nil {
+		t.Errorf("output is not valid XML: %v", err)
+	}
+}
+
+func TestFontOption(t *testing.T) {
+	opts, err := parseArgsSlice([]string{"./examples", "--font", `"Inter", sans-serif`})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stacker := NewSVGStackerWithOptions(opts)
+	stacker.diagrams["context"] = DiagramInfo{content: "<g></g>", viewBox: "0 0 10 10", width: 10, height: 10, aspectRatio: 1}
+
+	output := stacker.buildStackedSVG()
+	if strings.Contains(output, "Arial") {
+		t.Errorf("default font should be replaced everywhere")
+	}
+	// title, one nav button, two toggles and three "not found" placeholders
+	if got := strings.Count(output, `font-family="&#34;Inter&#34;, sans-serif"`); got != 7 {
+		t.Errorf("expected 7 uses of the custom font, got %d", got)
+	}
+	if err := ValidateXML(output); err != nil {
+		t.Errorf("output is not valid XML: %v", err)
+	}
+}
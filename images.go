@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sharedImagePrefix starts the ids of the <symbol>s hoistInlineImages creates
+const sharedImagePrefix = "shared-image-"
+
+// inlineImage identifies a data: URI image that can be drawn from one shared
+// <symbol>: the same payload with the same preserveAspectRatio
+type inlineImage struct {
+	href                string
+	preserveAspectRatio string
+}
+
+// inlineImageOf returns the shareable image drawn by start, if it is an <image>
+// with a data: URI and an explicit width and height (which a <use> can supply)
+func inlineImageOf(start xml.StartElement) (inlineImage, bool) {
+	if start.Name.Local != "image" {
+		return inlineImage{}, false
+	}
+	var image inlineImage
+	var width, height bool
+	for _, attr := range start.Attr {
+		switch name := attr.Name.Local; {
+		case name == "href" || strings.HasSuffix(name, ":href"):
+			image.href = attr.Value
+		case name == "preserveAspectRatio":
+			image.preserveAspectRatio = attr.Value
+		case name == "width":
+			width = true
+		case name == "height":
+			height = true
+		}
+	}
+	return image, width && height && strings.HasPrefix(image.href, "data:")
+}
+
+// hoistInlineImages finds data: URI images that appear more than once across the
+// diagrams of every stack, moves each into a <symbol> in s.imageDefs and replaces
+// its occurrences with a <use> of it. Icon-heavy diagrams otherwise repeat the
+// same base64 payload in every level.
+func (s *SVGStacker) hoistInlineImages() {
+	type diagramRef struct {
+		stack *SVGStacker
+		level string
+	}
+
+	// Count the images across all diagrams, in document order so ids are stable
+	counts := make(map[inlineImage]int)
+	var order []inlineImage
+	var withImages []diagramRef
+	for _, stack := range s.allStacks() {
+		for _, level := range stack.levelOrder() {
+			info := stack.diagrams[level]
+			found := false
+			_, err := transformXML(info.content, info.namespaces, false, func(token xml.Token) []xml.Token {
+				if start, ok := token.(xml.StartElement); ok {
+					if image, ok := inlineImageOf(start); ok {
+						if counts[image] == 0 {
+							order = append(order, image)
+						}
+						counts[image]++
+						found = true
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				logger.Debugf("not sharing images of level %s: %v\n", level, err)
+				continue
+			}
+			if found {
+				withImages = append(withImages, diagramRef{stack, level})
+			}
+		}
+	}
+
+	ids := make(map[inlineImage]string)
+	var defs strings.Builder
+	for _, image := range order {
+		if counts[image] < 2 {
+			continue
+		}
+		id := fmt.Sprintf("%s%d", sharedImagePrefix, len(ids)+1)
+		ids[image] = id
+		par := ""
+		if image.preserveAspectRatio != "" {
+			par = ` preserveAspectRatio="` + xmlEscape(image.preserveAspectRatio) + `"`
+		}
+		// Without a viewBox the symbol's viewport is the <use>'s width and height,
+		// which the percentages fill just as the original <image> filled its own
+		defs.WriteString(fmt.Sprintf("    <symbol id=\"%s\"><image width=\"100%%\" height=\"100%%\"%s xlink:href=\"%s\"/></symbol>\n",
+			id, par, xmlEscape(image.href)))
+	}
+	if len(ids) == 0 {
+		return
+	}
+	logger.Debugf("sharing %d inline images between diagrams\n", len(ids))
+	s.imageDefs = defs.String()
+
+	for _, ref := range withImages {
+		info := ref.stack.diagrams[ref.level]
+		var replaced []bool // for each open element, whether its start became a <use>
+		content, err := transformXML(info.content, info.namespaces, false, func(token xml.Token) []xml.Token {
+			switch t := token.(type) {
+			case xml.StartElement:
+				image, ok := inlineImageOf(t)
+				id, shared := ids[image]
+				replaced = append(replaced, ok && shared)
+				if ok && shared {
+					return []xml.Token{useOf(t, id)}
+				}
+			case xml.EndElement:
+				wasReplaced := replaced[len(replaced)-1]
+				replaced = replaced[:len(replaced)-1]
+				if wasReplaced {
+					t.Name.Local = "use"
+					return []xml.Token{t}
+				}
+			}
+			return []xml.Token{token}
+		})
+		if err != nil {
+			// Checked by the counting pass, so not expected
+			logger.Debugf("not sharing images of level %s: %v\n", ref.level, err)
+			continue
+		}
+		info.content = content
+		ref.stack.diagrams[ref.level] = info
+	}
+}
+
+// useOf turns an <image> start element into a <use> of the symbol id, keeping
+// its position, size and presentation attributes
+func useOf(image xml.StartElement, id string) xml.StartElement {
+	use := xml.StartElement{Name: xml.Name{Local: "use"}}
+	for _, attr := range image.Attr {
+		switch name := attr.Name.Local; {
+		case name == "href" || strings.HasSuffix(name, ":href"), name == "preserveAspectRatio":
+			continue
+		}
+		use.Attr = append(use.Attr, attr)
+	}
+	use.Attr = append(use.Attr, xml.Attr{Name: xml.Name{Local: "xlink:href"}, Value: "#" + id})
+	return use
+}
+
+// imageTypes maps the image file extensions --background-image accepts to their
+// MIME types
+var imageTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".svg":  "image/svg+xml",
+}
+
+// backgroundImage inlines file as a base64 data URI in an <image> filling the
+// canvas at the given opacity. It ignores pointer events, so clicks and hovers
+// reach the diagrams drawn over it.
+func backgroundImage(file string, opacity float64) (string, error) {
+	ext := strings.ToLower(filepath.Ext(file))
+	mime, ok := imageTypes[ext]
+	if !ok {
+		return "", fmt.Errorf("%s: unsupported image type %q (use .png, .jpg, .gif, .webp or .svg)", file, ext)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("--background-image: %w", err)
+	}
+	return fmt.Sprintf(`
+
+  <!-- Background Image -->
+  <image x="0" y="0" width="100%%" height="100%%" preserveAspectRatio="xMidYMid meet"
+         opacity="%s" pointer-events="none" id="background-image"
+         xlink:href="data:%s;base64,%s"/>`, jsNumber(opacity), mime, base64.StdEncoding.EncodeToString(data)), nil
+}
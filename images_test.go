@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHoistInlineImages(t *testing.T) {
+	const logo = "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk"
+	const unique = "data:image/png;base64,AAAA"
+	diagrams := map[string]string{
+		"context.svg": `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="100" height="50" viewBox="0 0 100 50">` +
+			`<image x="5" y="6" width="16" height="16" xlink:href="` + logo + `"/>` +
+			`<image x="30" y="6" width="16" height="16" xlink:href="` + unique + `"/></svg>`,
+		"container.svg": `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50">` +
+			`<g><image class="icon" x="7" y="8" width="32" height="32" href="` + logo + `"><title>Logo</title></image></g></svg>`,
+		"component.svg": `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50">` +
+			`<image width="16" height="16" preserveAspectRatio="none" href="` + logo + `"/></svg>`,
+	}
+	dir := t.TempDir()
+	for name, svg := range diagrams {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output, err := NewSVGStacker(dir, "", "").Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if err := ValidateXML(output); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+
+	// Once in the shared symbol, and once for the image with a different preserveAspectRatio
+	if n := strings.Count(output, logo); n != 2 {
+		t.Errorf("expected the logo payload twice, got %d", n)
+	}
+	for _, want := range []string{
+		`<symbol id="shared-image-1"><image width="100%" height="100%" xlink:href="` + logo + `"/></symbol>`,
+		`<use height="16" width="16" x="5" xlink:href="#shared-image-1" y="6"></use>`,
+		`<use class="icon" height="32" width="32" x="7" xlink:href="#shared-image-1" y="8">`,
+		`<image height="16" href="` + logo + `" preserveAspectRatio="none" width="16"></image>`,
+		`xlink:href="` + unique + `"`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %s in output", want)
+		}
+	}
+}
+
+func TestHoistInlineImagesNoClean(t *testing.T) {
+	const logo = "data:image/png;base64,AAAA"
+	image := `<image width="16" height="16" xmlns:xlink="http://www.w3.org/1999/xlink" xlink:href="` + logo + `"/>`
+	dir := t.TempDir()
+	for _, name := range []string{"context.svg", "container.svg"} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50">` + image + `</svg>`
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output, err := NewSVGStackerWithOptions(Options{InputDir: dir, NoClean: true}).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	// --no-clean embeds the diagrams verbatim, so nothing is hoisted
+	if n := strings.Count(output, image); n != 2 {
+		t.Errorf("expected each image verbatim, got %d", n)
+	}
+	if strings.Contains(output, sharedImagePrefix) {
+		t.Errorf("expected no shared image symbols with --no-clean")
+	}
+}
+
+func TestHoistInlineImagesNoDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><image width="16" height="16" href="data:image/png;base64,AAAA"/></svg>`
+	if err := os.WriteFile(filepath.Join(dir, "context.svg"), []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := NewSVGStacker(dir, "", "").Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if strings.Contains(output, "<defs>") || strings.Contains(output, "<use") {
+		t.Errorf("expected an image used once to be left in place")
+	}
+}
+
+func TestBackgroundImage(t *testing.T) {
+	dir := t.TempDir()
+	createTestSVGFiles(t, dir)
+	logo := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(logo, []byte("PNGDATA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgsSlice([]string{dir, "--background-image", logo, "--background-opacity", "0.25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := NewSVGStackerWithOptions(opts).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if err := ValidateXML(output); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+
+	image := strings.Index(output, `xlink:href="data:image/png;base64,UE5HREFUQQ=="`)
+	if image < 0 {
+		t.Fatalf("expected the inlined background image in output")
+	}
+	if !strings.Contains(output, `opacity="0.25" pointer-events="none"`) {
+		t.Errorf("expected a faint background image that ignores pointer events")
+	}
+	// Behind the header and every layer
+	if chrome := strings.Index(output, `id="nav-chrome"`); chrome < image {
+		t.Errorf("expected the background image before the header")
+	}
+
+	for _, args := range [][]string{
+		{dir, "--background-opacity", "0"},
+		{dir, "--background-opacity", "1.5"},
+		{dir, "--background-opacity", "faint"},
+	} {
+		if _, err := parseArgsSlice(args); err == nil {
+			t.Errorf("%v: expected an error", args[1:])
+		}
+	}
+	if _, err := backgroundImage(filepath.Join(dir, "logo.bmp"), 0.1); err == nil || !strings.Contains(err.Error(), "unsupported image type") {
+		t.Errorf("expected an unsupported type error, got %v", err)
+	}
+}
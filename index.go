@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// IndexOptions holds the configuration for the 'index' subcommand
+type IndexOptions struct {
+	Dir        string   // searched recursively for stacked SVGs
+	OutputFile string   // "" or "-" writes to stdout
+	LogLevel   LogLevel // from --quiet or --verbose; LogNormal if neither
+}
+
+// parseIndexArgs parses the arguments following 'index': the directory to search
+// and an optional --output
+func parseIndexArgs(args []string) (IndexOptions, error) {
+	var opts IndexOptions
+	var err error
+	for i := 0; i < len(args); i++ {
+		if level, ok := logLevelFlag(args[i]); ok {
+			opts.LogLevel = level
+			continue
+		}
+		switch {
+		case args[i] == "--output":
+			if opts.OutputFile, err = flagValue(args, &i); err != nil {
+				return IndexOptions{}, err
+			}
+		case opts.Dir == "" && !strings.HasPrefix(args[i], "-"):
+			opts.Dir = args[i]
+		default:
+			return IndexOptions{}, fmt.Errorf("unknown index argument: %s", args[i])
+		}
+	}
+	if opts.Dir == "" {
+		return IndexOptions{}, fmt.Errorf("index requires the directory to search")
+	}
+	return opts, nil
+}
+
+// indexEntry is a stacked SVG listed in the index
+type indexEntry struct {
+	Path   string   // relative to the index, with forward slashes
+	Title  string   // the header title it was generated with
+	Levels []string // level keys, with the stack prefix when there are several
+}
+
+// runIndexCommand writes an HTML page linking to every stacked SVG under opts.Dir.
+// Links are relative to the directory of opts.OutputFile, or to opts.Dir when
+// writing to stdout.
+func runIndexCommand(opts IndexOptions) error {
+	base := opts.Dir
+	toStdout := opts.OutputFile == "" || opts.OutputFile == "-"
+	if !toStdout {
+		base = filepath.Dir(opts.OutputFile)
+	}
+	entries, err := findStackedSVGs(opts.Dir, base)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return withExitCode(exitNoInputs, fmt.Errorf("no stacked SVGs found in %s", opts.Dir))
+	}
+
+	if toStdout {
+		return writeIndexHTML(os.Stdout, entries)
+	}
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(opts.OutputFile)
+	if err != nil {
+		return err
+	}
+	if err := writeIndexHTML(f, entries); err != nil {
+		f.Close()
+		return err
+	}
+	logger.Infof("Indexed %d stacked SVGs in %s\n", len(entries), opts.OutputFile)
+	return f.Close()
+}
+
+// findStackedSVGs walks dir for SVGs svg-stacker generated, skipping hidden
+// directories and node_modules, and returns them sorted by path relative to base
+func findStackedSVGs(dir, base string) ([]indexEntry, error) {
+	var entries []indexEntry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.EqualFold(filepath.Ext(path), ".svg") {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		title, ok := stackedTitle(string(content))
+		if !ok {
+			return nil
+		}
+		levels, err := stackedLevels(string(content))
+		if err != nil {
+			logger.Warnf("Warning: skipping %s: %v\n", path, err)
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			rel = path
+		}
+		entry := indexEntry{Path: filepath.ToSlash(rel), Title: title}
+		for key := range levels {
+			entry.Levels = append(entry.Levels, key)
+		}
+		slices.SortFunc(entry.Levels, compareLevelKeys)
+		entries = append(entries, entry)
+		return nil
+	})
+	slices.SortFunc(entries, func(a, b indexEntry) int { return strings.Compare(a.Path, b.Path) })
+	return entries, err
+}
+
+// compareLevelKeys orders level keys by stack prefix, then in C4 order
+func compareLevelKeys(a, b string) int {
+	rank := func(key string) (string, int) {
+		for i, level := range c4Levels {
+			if prefix, ok := strings.CutSuffix(key, level); ok {
+				return prefix, i
+			}
+		}
+		return key, len(c4Levels)
+	}
+	prefixA, rankA := rank(a)
+	prefixB, rankB := rank(b)
+	if prefixA != prefixB {
+		return strings.Compare(prefixA, prefixB)
+	}
+	return rankA - rankB
+}
+
+// stackedTitle returns the header title of a stacked SVG, reporting false for
+// SVGs that svg-stacker didn't generate
+func stackedTitle(svg string) (string, bool) {
+	decoder := xml.NewDecoder(strings.NewReader(svg))
+	generated := false
+	readText := func() string {
+		var text strings.Builder
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return text.String()
+			}
+			switch t := token.(type) {
+			case xml.CharData:
+				text.Write(t)
+			case xml.EndElement:
+				return strings.TrimSpace(text.String())
+			}
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", false
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch {
+		case start.Name.Local == "generator":
+			generated = readText() == "stacked-c4-svg"
+		case start.Name.Local == "text" && hasAttrValue(start, "id", "header-title"):
+			if !generated {
+				return "", false
+			}
+			return readText(), true
+		}
+	}
+}
+
+func hasAttrValue(start xml.StartElement, name, value string) bool {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name && attr.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// writeIndexHTML writes the index page listing entries
+func writeIndexHTML(w io.Writer, entries []indexEntry) error {
+	var sb strings.Builder
+	sb.WriteString(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Architecture Diagrams</title>
+  <style>
+    body { font-family: Arial, sans-serif; margin: 2em; color: #2c3e50; }
+    li { margin: 0.5em 0; }
+    .levels { color: #7f8c8d; }
+  </style>
+</head>
+<body>
+  <h1>Architecture Diagrams</h1>
+  <ul>
+`)
+	for _, entry := range entries {
+		href := (&url.URL{Path: entry.Path}).String()
+		levels := "1 level"
+		if len(entry.Levels) != 1 {
+			levels = fmt.Sprintf("%d levels", len(entry.Levels))
+		}
+		sb.WriteString(fmt.Sprintf("    <li><a href=\"%s\">%s</a> <span class=\"levels\">%s: %s</span></li>\n",
+			html.EscapeString(href), html.EscapeString(entry.Title), levels, html.EscapeString(strings.Join(entry.Levels, ", "))))
+	}
+	sb.WriteString(`  </ul>
+</body>
+</html>
+`)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseIndexArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		dir       string
+		output    string
+		expectErr bool
+	}{
+		{"stdout", []string{"docs"}, "docs", "", false},
+		{"output", []string{"docs", "--output", "docs/index.html"}, "docs", "docs/index.html", false},
+		{"output first", []string{"--output", "index.html", "docs"}, "docs", "index.html", false},
+		{"missing dir", []string{"--output", "index.html"}, "", "", true},
+		{"unknown flag", []string{"docs", "--bogus"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseIndexArgs(tt.args)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("error: got %v, expectErr %v", err, tt.expectErr)
+			}
+			if err == nil && (opts.Dir != tt.dir || opts.OutputFile != tt.output) {
+				t.Errorf("got %+v", opts)
+			}
+		})
+	}
+}
+
+func TestRunIndexCommand(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g></g></svg>`
+	sources := t.TempDir()
+	for _, name := range []string{"context.svg", "container.svg"} {
+		if err := os.WriteFile(filepath.Join(sources, name), []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	docs := t.TempDir()
+	generate := func(output, title string, opts Options) {
+		opts.InputDir, opts.OutputFile, opts.Title = sources, filepath.Join(docs, output), title
+		if err := NewSVGStackerWithOptions(opts).CreateStackedSVG(); err != nil {
+			t.Fatalf("CreateStackedSVG failed: %v", err)
+		}
+	}
+	generate("billing/stack.svg", "Billing & Payments", Options{})
+	generate("shipping stack.svg", "Shipping", Options{Only: []string{"context"}, Conformant: true})
+	generate(".cache/old.svg", "Hidden", Options{})
+	if err := os.WriteFile(filepath.Join(docs, "logo.svg"), []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(docs, "site", "index.html")
+	if err := runIndexCommand(IndexOptions{Dir: docs, OutputFile: output}); err != nil {
+		t.Fatalf("index failed: %v", err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	for _, want := range []string{
+		`<a href="../billing/stack.svg">Billing &amp; Payments</a> <span class="levels">2 levels: context, container</span>`,
+		`<a href="../shipping%20stack.svg">Shipping</a> <span class="levels">1 level: context</span>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %s in index:\n%s", want, page)
+		}
+	}
+	for _, unwanted := range []string{"logo.svg", "Hidden"} {
+		if strings.Contains(page, unwanted) {
+			t.Errorf("expected %s to be left out of the index", unwanted)
+		}
+	}
+	if strings.Index(page, "billing/stack.svg") > strings.Index(page, "shipping%20stack.svg") {
+		t.Errorf("expected entries sorted by path")
+	}
+
+	if err := runIndexCommand(IndexOptions{Dir: sources}); exitCodeFor(err) != exitNoInputs {
+		t.Errorf("expected a directory without stacked SVGs to exit with %d, got %v", exitNoInputs, err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// linkStyle is how a relationship's line is drawn, which C4 diagrams use to tell
+// kinds of relationship apart
+type linkStyle struct {
+	stroke string // lowercased colour, "" if unset
+	dash   string // comma-separated stroke-dasharray, "" for a solid line
+}
+
+// describe names the style for a legend without a --link-legend-file
+func (l linkStyle) describe() string {
+	desc := "Solid"
+	if l.dash != "" {
+		desc = "Dashed (" + l.dash + ")"
+	}
+	if l.stroke != "" {
+		desc += " " + l.stroke
+	}
+	return desc
+}
+
+// linkStyles returns the distinct styles of the lines in a diagram's class="link"
+// groups, in document order. Arrowheads are drawn in the same colour, so only
+// the <path>s are looked at.
+func linkStyles(content string, namespaces map[string]string) []linkStyle {
+	var styles []linkStyle
+	var inLink []bool // for each open element, whether it is inside a link group
+	_, err := transformXML(content, namespaces, false, func(token xml.Token) []xml.Token {
+		switch t := token.(type) {
+		case xml.StartElement:
+			parent := len(inLink) > 0 && inLink[len(inLink)-1]
+			inLink = append(inLink, parent || (t.Name.Local == "g" && hasClass(t, "link")))
+			if parent && t.Name.Local == "path" {
+				if style := linkStyleOf(t); !slices.Contains(styles, style) {
+					styles = append(styles, style)
+				}
+			}
+		case xml.EndElement:
+			inLink = inLink[:len(inLink)-1]
+		}
+		return nil
+	})
+	if err != nil {
+		// Malformed content is reported when the diagram is cleaned
+		return nil
+	}
+	return styles
+}
+
+// linkStyleOf reads the stroke and dash pattern of a path from its attributes
+// and, taking precedence as in CSS, its style attribute
+func linkStyleOf(path xml.StartElement) linkStyle {
+	values := make(map[string]string)
+	var style string
+	for _, attr := range path.Attr {
+		switch attr.Name.Local {
+		case "stroke", "stroke-dasharray":
+			values[attr.Name.Local] = attr.Value
+		case "style":
+			style = attr.Value
+		}
+	}
+	for _, decl := range strings.Split(style, ";") {
+		name, value, ok := strings.Cut(decl, ":")
+		if name = strings.TrimSpace(name); ok && (name == "stroke" || name == "stroke-dasharray") {
+			values[name] = value
+		}
+	}
+	return linkStyle{
+		stroke: strings.ToLower(strings.TrimSpace(values["stroke"])),
+		dash:   normalizeDash(values["stroke-dasharray"]),
+	}
+}
+
+// normalizeDash writes a stroke-dasharray with comma separators, and "none" or
+// an empty one as ""
+func normalizeDash(dash string) string {
+	fields := strings.FieldsFunc(dash, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) == 1 && fields[0] == "none" {
+		return ""
+	}
+	return strings.Join(fields, ",")
+}
+
+// linkMeaning is an entry of a --link-legend-file: the relationship a line style
+// stands for. Stroke and Dash select the styles it applies to; an empty one
+// matches any.
+type linkMeaning struct {
+	Stroke  string `json:"stroke"`  // colour, e.g. "#438DD5"
+	Dash    string `json:"dash"`    // "solid", "dashed" or a stroke-dasharray such as "7,7"
+	Meaning string `json:"meaning"` // what the legend says the style means
+}
+
+func (m linkMeaning) matches(style linkStyle) bool {
+	if m.Stroke != "" && !strings.EqualFold(m.Stroke, style.stroke) {
+		return false
+	}
+	switch m.Dash {
+	case "":
+		return true
+	case "solid":
+		return style.dash == ""
+	case "dashed":
+		return style.dash != ""
+	default:
+		return normalizeDash(m.Dash) == style.dash
+	}
+}
+
+// readLinkLegendFile reads a JSON array of linkMeanings
+func readLinkLegendFile(file string) ([]linkMeaning, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("--link-legend-file: %w", err)
+	}
+	var meanings []linkMeaning
+	if err := json.Unmarshal(data, &meanings); err != nil {
+		return nil, fmt.Errorf("--link-legend-file: %s: %w", file, err)
+	}
+	for i, m := range meanings {
+		if strings.TrimSpace(m.Meaning) == "" {
+			return nil, fmt.Errorf("--link-legend-file: %s: entry %d has no meaning", file, i+1)
+		}
+	}
+	return meanings, nil
+}
+
+// linkLegendEntry is a line of the relationships section of the legend
+type linkLegendEntry struct {
+	style linkStyle
+	label string
+}
+
+// linkLegendEntries lists the link styles of every diagram in level order, each
+// labelled with the first matching --link-legend-file meaning. With a file,
+// styles it doesn't mention are left out; without one, each style is described.
+// Styles sharing a meaning are listed once, under the first of them.
+func (s *SVGStacker) linkLegendEntries(levels []string) []linkLegendEntry {
+	var entries []linkLegendEntry
+	seen := make(map[string]bool)
+	for _, stack := range s.allStacks() {
+		for _, level := range levels {
+			for _, style := range stack.diagrams[level].linkStyles {
+				label := style.describe()
+				if s.linkMeanings != nil {
+					label = ""
+					for _, m := range s.linkMeanings {
+						if m.matches(style) {
+							label = m.Meaning
+							break
+						}
+					}
+				}
+				if label == "" || seen[label] {
+					continue
+				}
+				seen[label] = true
+				entries = append(entries, linkLegendEntry{style, label})
+			}
+		}
+	}
+	return entries
+}
+
+// defaultLinkStroke draws a sample of a style without a stroke colour of its own,
+// as PlantUML's default relationship colour
+const defaultLinkStroke = "#666666"
+
+// writeLinkLegend appends the relationships section to the legend panel's
+// entries, starting at y, and returns the height it takes up
+func (s *SVGStacker) writeLinkLegend(sb *strings.Builder, levels []string, font string, y int) int {
+	entries := s.linkLegendEntries(levels)
+	if len(entries) == 0 {
+		return 0
+	}
+
+	sb.WriteString(fmt.Sprintf("    <text x=\"16\" y=\"%d\" font-family=\"%s\" font-size=\"14\" fill=\"#7f8c8d\">Relationships</text>\n", y+12, font))
+	for i, entry := range entries {
+		baseline := y + 36 + i*24
+		stroke, dash := entry.style.stroke, ""
+		if stroke == "" {
+			stroke = defaultLinkStroke
+		}
+		if entry.style.dash != "" {
+			dash = ` stroke-dasharray="` + xmlEscape(entry.style.dash) + `"`
+		}
+		sb.WriteString(fmt.Sprintf(`    <line x1="16" y1="%d" x2="60" y2="%d" stroke="%s" stroke-width="2"%s/>
+    <text x="72" y="%d" font-family="%s" font-size="12" fill="#555">%s</text>
+`, baseline-4, baseline-4, xmlEscape(stroke), dash, baseline, font, xmlEscape(entry.label)))
+	}
+	return 26 + len(entries)*24
+}
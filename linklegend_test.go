@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const linkLegendSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 400 300" width="400" height="300">
+  <g class="entity"><rect width="10" height="10" stroke="#000000"/></g>
+  <g class="link">
+    <path d="M0,0 L10,10" fill="none" stroke="#666666" stroke-dasharray="7 7"/>
+    <polygon points="0,0 5,5" fill="#666666" stroke="#666666"/>
+  </g>
+  <g class="link"><path d="M0,0 L20,20" fill="none" style="stroke:#438DD5;stroke-width:1.0;"/></g>
+  <g class="link"><path d="M0,0 L30,30" fill="none" stroke="#666666" stroke-dasharray="7,7"/></g>
+</svg>`
+
+func TestLinkStyles(t *testing.T) {
+	styles := linkStyles(linkLegendSVG, nil)
+	want := []linkStyle{{stroke: "#666666", dash: "7,7"}, {stroke: "#438dd5"}}
+	if len(styles) != len(want) {
+		t.Fatalf("expected %v, got %v", want, styles)
+	}
+	for i := range want {
+		if styles[i] != want[i] {
+			t.Errorf("style %d: expected %v, got %v", i, want[i], styles[i])
+		}
+	}
+
+	for _, tc := range []struct {
+		meaning linkMeaning
+		style   linkStyle
+		matches bool
+	}{
+		{linkMeaning{Dash: "dashed"}, linkStyle{dash: "7,7"}, true},
+		{linkMeaning{Dash: "dashed"}, linkStyle{}, false},
+		{linkMeaning{Dash: "solid"}, linkStyle{stroke: "#438dd5"}, true},
+		{linkMeaning{Dash: "7 7"}, linkStyle{dash: "7,7"}, true},
+		{linkMeaning{Stroke: "#438DD5"}, linkStyle{stroke: "#438dd5"}, true},
+		{linkMeaning{Stroke: "#438DD5", Dash: "dashed"}, linkStyle{stroke: "#438dd5"}, false},
+	} {
+		if got := tc.meaning.matches(tc.style); got != tc.matches {
+			t.Errorf("%+v matching %+v: expected %v", tc.meaning, tc.style, tc.matches)
+		}
+	}
+}
+
+func TestLinkLegend(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"01-context.svg", "02-container.svg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(linkLegendSVG), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output, err := NewSVGStackerWithOptions(Options{InputDir: dir, Legend: true, LinkLegend: true}).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for _, want := range []string{
+		">Relationships</text>",
+		`stroke="#666666" stroke-width="2" stroke-dasharray="7,7"/>`,
+		">Dashed (7,7) #666666</text>",
+		">Solid #438dd5</text>",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %s in the legend", want)
+		}
+	}
+
+	// With a file, only the styles it names are listed
+	file := filepath.Join(t.TempDir(), "links.json")
+	if err := os.WriteFile(file, []byte(`[{"dash": "dashed", "meaning": "Async & queued"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, err = NewSVGStackerWithOptions(Options{InputDir: dir, Legend: true, LinkLegend: true, LinkLegendFile: file}).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(output, ">Async &amp; queued</text>") {
+		t.Errorf("expected the meaning from the file in the legend")
+	}
+	if strings.Contains(output, "#438dd5</text>") {
+		t.Errorf("expected the unnamed solid style to be left out")
+	}
+
+	if err := os.WriteFile(file, []byte(`[{"dash": "dashed"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readLinkLegendFile(file); err == nil || !strings.Contains(err.Error(), "entry 1 has no meaning") {
+		t.Errorf("expected an entry without a meaning to be rejected, got %v", err)
+	}
+}
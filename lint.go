@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// LintOptions holds the configuration for the 'lint' subcommand
+type LintOptions struct {
+	Stacker Options // InputDir is the directory to check; selection flags such as --include apply
+}
+
+// parseLintArgs parses the arguments following 'lint': the directory to check and
+// any stacking options that change which files are used or how they are read, in
+// any order
+func parseLintArgs(args []string) (LintOptions, error) {
+	if len(args) == 0 {
+		return LintOptions{}, fmt.Errorf("lint requires the directory to check")
+	}
+	stacker, err := parseArgsSlice(args)
+	if err != nil {
+		return LintOptions{}, err
+	}
+	if len(stacker.MoreInputDirs) > 0 {
+		return LintOptions{}, fmt.Errorf("lint checks one directory at a time")
+	}
+	return LintOptions{Stacker: stacker}, nil
+}
+
+// lintIssue is a problem lint found with a file, or with the directory as a whole
+type lintIssue struct {
+	file    string // base name, or the directory for problems with the whole set
+	isError bool   // generating would fail or leave something out; otherwise a warning
+	message string
+}
+
+func (i lintIssue) String() string {
+	severity := "warning"
+	if i.isError {
+		severity = "error"
+	}
+	return fmt.Sprintf("%s: %s: %s", i.file, severity, i.message)
+}
+
+// runLintCommand checks the input directory and writes every issue found to w,
+// returning the exit code: exitOK, or exitLintWarnings or exitLintErrors for the
+// most severe issue
+func runLintCommand(opts LintOptions, w io.Writer) (int, error) {
+	issues, err := lintDir(opts.Stacker)
+	if err != nil {
+		return exitFailure, err
+	}
+
+	code := exitOK
+	var errors, warnings int
+	for _, issue := range issues {
+		fmt.Fprintln(w, issue)
+		if issue.isError {
+			errors++
+			code = exitLintErrors
+		} else {
+			warnings++
+			if code == exitOK {
+				code = exitLintWarnings
+			}
+		}
+	}
+	if len(issues) == 0 {
+		fmt.Fprintf(w, "%s: no issues found\n", opts.Stacker.InputDir)
+	} else {
+		fmt.Fprintf(w, "%s, %s\n", plural(errors, "error"), plural(warnings, "warning"))
+	}
+	return code, nil
+}
+
+// plural formats a count of noun, e.g. "1 error" or "2 errors"
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// lintDir runs the checks loadInput's fail-fast path would, but carries on past
+// each problem so all of them are reported: every file maps to one level of its
+// own, every SVG parses and has a usable size, and the required levels are present.
+// .puml sources are only checked by name and numbering, since rendering them
+// needs plantuml.
+func lintDir(opts Options) ([]lintIssue, error) {
+	s := NewSVGStackerWithOptions(opts)
+	dir := opts.InputDir
+	if isArchive(dir) {
+		archiveDir, err := extractArchive(dir, opts.TempDir, s.maxFileSize())
+		if err != nil {
+			return nil, err
+		}
+		defer s.removeTemp(archiveDir, "extracted files")
+		dir = archiveDir
+	}
+	if stat, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !stat.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	var issues []lintIssue
+	report := func(file string, isError bool, format string, args ...any) {
+		issues = append(issues, lintIssue{file: file, isError: isError, message: fmt.Sprintf(format, args...)})
+	}
+
+	var err error
+	if s.ignored, err = readIgnoreFile(dir); err != nil {
+		report(ignoreFileName, true, "%v", err)
+	}
+
+	// Each level must come from exactly one file
+	levelFiles := make(map[string]string)
+	levelOf := func(file string) string {
+		name := filepath.Base(file)
+		level := s.extractLevel(name)
+		if level == "unknown" {
+			report(name, false, "no C4 level in the file name, so it is skipped")
+			return ""
+		}
+		if first, ok := levelFiles[level]; ok {
+			report(name, true, "is the %s level, as is %s; only one of them is used", level, first)
+		} else {
+			levelFiles[level] = name
+		}
+		return level
+	}
+
+	sources, err := lintSourceFiles(s, dir)
+	if err != nil {
+		return nil, err
+	}
+	svgs, err := filepath.Glob(filepath.Join(dir, "*.svg"))
+	if err != nil {
+		return nil, err
+	}
+	var selected []string
+	for _, file := range svgs {
+		if s.selected(file) {
+			selected = append(selected, file)
+		}
+	}
+
+	if len(sources) > 0 {
+		for _, file := range sources {
+			levelOf(file)
+		}
+		issues = append(issues, lintPumlNumbering(sources, opts.InputDir)...)
+		// The rendered sources replace the directory as input
+		for _, file := range selected {
+			report(filepath.Base(file), false, "ignored, as the directory has diagram sources to render")
+		}
+	} else {
+		for _, file := range selected {
+			issues = append(issues, s.lintSVG(file, levelOf(file))...)
+		}
+	}
+
+	if len(levelFiles) == 0 {
+		report(opts.InputDir, true, "no C4 diagrams found")
+		return issues, nil
+	}
+	for _, expected := range expectedPumlFiles {
+		level := strings.TrimSuffix(strings.TrimPrefix(expected.name, expected.prefix), ".puml")
+		if _, ok := levelFiles[level]; !ok && expected.required {
+			report(opts.InputDir, true, "no %s diagram", level)
+		}
+	}
+	return issues, nil
+}
+
+// lintSourceFiles returns the selected files of every registered source format
+func lintSourceFiles(s *SVGStacker, dir string) ([]string, error) {
+	var files []string
+	for _, ext := range sourceExts() {
+		matches, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range matches {
+			if s.selected(file) {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// lintPumlNumbering applies the rules of checkGeneratedPuml to the .puml files
+// among sources, which renderPlantUML relies on: one non-empty file for each of
+// the numbers in expectedPumlFiles, 04 being optional. Files without a number
+// are not rendered at all.
+func lintPumlNumbering(sources []string, dir string) []lintIssue {
+	var puml []string
+	for _, file := range sources {
+		if strings.EqualFold(filepath.Ext(file), ".puml") {
+			puml = append(puml, file)
+		}
+	}
+	if len(puml) == 0 {
+		return nil
+	}
+
+	var issues []lintIssue
+	numbered := numberedPumlFiles(puml)
+	for _, file := range puml {
+		if !slices.Contains(numbered, file) {
+			issues = append(issues, lintIssue{file: filepath.Base(file), message: "not numbered 01- to 04-, so it is not rendered"})
+		}
+	}
+	for _, expected := range expectedPumlFiles {
+		var first string
+		for _, file := range numbered {
+			name := filepath.Base(file)
+			if !strings.HasPrefix(name, expected.prefix) {
+				continue
+			}
+			if info, err := os.Stat(file); err == nil && info.Size() == 0 {
+				issues = append(issues, lintIssue{file: name, isError: true, message: "is empty"})
+			}
+			if first != "" {
+				issues = append(issues, lintIssue{file: name, isError: true, message: fmt.Sprintf("is numbered %s, as is %s; each number must be used once", strings.TrimSuffix(expected.prefix, "-"), first)})
+			} else {
+				first = name
+			}
+		}
+		if first == "" && expected.required {
+			issues = append(issues, lintIssue{file: dir, isError: true, message: fmt.Sprintf("no %s*.puml file; 01- to 03- are required", expected.prefix)})
+		}
+	}
+	return issues
+}
+
+// lintSVG reports what would stop file from being stacked, and what would make
+// it come out at the wrong size. Like loadDiagrams, it only checks that a file
+// without a level ("") is well-formed.
+func (s *SVGStacker) lintSVG(file, level string) []lintIssue {
+	name := filepath.Base(file)
+	var issues []lintIssue
+	report := func(isError bool, format string, args ...any) []lintIssue {
+		return append(issues, lintIssue{file: name, isError: isError, message: fmt.Sprintf(format, args...)})
+	}
+
+	content, _, err := readSVGFile(file, s.maxFileSize())
+	if err != nil {
+		return report(true, "%v", strings.TrimPrefix(err.Error(), file+": "))
+	}
+	if err := ValidateXML(string(content)); err != nil {
+		return report(true, "not well-formed XML: %v", err)
+	}
+	if level == "" {
+		return nil
+	}
+	info, err := s.parseSVG(string(content), level)
+	if err != nil {
+		return report(true, "%v", err)
+	}
+
+	tag := svgStartRegex.FindString(string(content))
+	viewBox, hasViewBox := attrValue(tag, "viewBox")
+	if hasViewBox {
+		if _, err := parseViewBox(viewBox); err != nil {
+			issues = report(false, "%v; the width and height are used instead", err)
+			hasViewBox = false
+		}
+	}
+	_, hasWidth := svgLength(tag, "width")
+	_, hasHeight := svgLength(tag, "height")
+	if !hasViewBox && (!hasWidth || !hasHeight) {
+		issues = report(false, "no usable viewBox, width and height; sized as %gx%g", info.width, info.height)
+	}
+	if minRatio, maxRatio := s.aspectWarnRange(); info.aspectRatio < minRatio || info.aspectRatio > maxRatio {
+		issues = report(false, "aspect ratio of %.2f is outside %g to %g", info.aspectRatio, minRatio, maxRatio)
+	}
+	return issues
+}
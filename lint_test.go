@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLintDir(t *testing.T) {
+	valid := `<svg xmlns="http://www.w3.org/2000/svg" width="400" height="300" viewBox="0 0 400 300"><g/></svg>`
+	files := map[string]string{
+		"01-context.svg":       valid,
+		"02-container.svg":     `<svg xmlns="http://www.w3.org/2000/svg" width="400" height="300"><g>`,
+		"02-container-old.svg": valid,
+		"04-code.svg":          `<svg xmlns="http://www.w3.org/2000/svg" width="100%" viewBox="0 0 nope"><g/></svg>`,
+		"logo.svg":             valid,
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts, err := parseLintArgs([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	code, err := runLintCommand(opts, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != exitLintErrors {
+		t.Errorf("expected exit code %d, got %d", exitLintErrors, code)
+	}
+
+	// Every problem is reported, not just the first
+	for _, want := range []string{
+		"02-container.svg: error: is the container level, as is 02-container-old.svg; only one of them is used\n",
+		"02-container.svg: error: not well-formed XML:",
+		`04-code.svg: warning: viewBox "0 0 nope": expected 4 values, got 3; the width and height are used instead` + "\n",
+		"04-code.svg: warning: no usable viewBox, width and height; sized as 400x300\n",
+		"logo.svg: warning: no C4 level in the file name, so it is skipped\n",
+		dir + ": error: no component diagram\n",
+		"3 errors, 3 warnings\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "01-context.svg") {
+		t.Errorf("expected no issues with 01-context.svg:\n%s", out.String())
+	}
+}
+
+func TestLintExitCodes(t *testing.T) {
+	valid := `<svg xmlns="http://www.w3.org/2000/svg" width="400" height="300" viewBox="0 0 400 300"><g/></svg>`
+	tests := []struct {
+		name  string
+		files []string
+		code  int
+	}{
+		{"clean", []string{"01-context.svg", "02-container.svg", "03-component.svg"}, exitOK},
+		{"warnings only", []string{"01-context.svg", "02-container.svg", "03-component.svg", "notes.svg"}, exitLintWarnings},
+		{"nothing to stack", []string{"notes.svg"}, exitLintErrors},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(valid), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var out bytes.Buffer
+			code, err := runLintCommand(LintOptions{Stacker: Options{InputDir: dir}}, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.code {
+				t.Errorf("expected exit code %d, got %d:\n%s", tt.code, code, out.String())
+			}
+		})
+	}
+}
+
+func TestLintPlantUMLSources(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"01-context.puml", "02-container.puml", "03-component.puml", "03-component-v2.puml", "01-context.svg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("@startuml\n@enduml\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	code, err := runLintCommand(LintOptions{Stacker: Options{InputDir: dir}}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != exitLintErrors {
+		t.Errorf("expected exit code %d, got %d", exitLintErrors, code)
+	}
+	for _, want := range []string{
+		"03-component.puml: error: is the component level, as is 03-component-v2.puml",
+		"01-context.svg: warning: ignored, as the directory has diagram sources to render",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestLintPumlNumbering(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"01-context.puml":   "@startuml\n@enduml\n",
+		"03-component.puml": "",
+		"03-alt.puml":       "@startuml\n@enduml\n",
+		"container.puml":    "@startuml\n@enduml\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	code, err := runLintCommand(LintOptions{Stacker: Options{InputDir: dir}}, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != exitLintErrors {
+		t.Errorf("expected exit code %d, got %d", exitLintErrors, code)
+	}
+	for _, want := range []string{
+		"container.puml: warning: not numbered 01- to 04-, so it is not rendered",
+		dir + ": error: no 02-*.puml file; 01- to 03- are required",
+		"03-component.puml: error: is empty",
+		"03-component.puml: error: is numbered 03, as is 03-alt.puml; each number must be used once",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestParseLintArgs(t *testing.T) {
+	opts, err := parseLintArgs([]string{"--include", "01-*.svg", "docs"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.Stacker.InputDir != "docs" || len(opts.Stacker.Include) != 1 || opts.Stacker.Include[0] != "01-*.svg" {
+		t.Errorf("expected docs with --include 01-*.svg, got %q, %q", opts.Stacker.InputDir, opts.Stacker.Include)
+	}
+
+	for _, args := range [][]string{{}, {"--include", "01-*.svg"}, {"docs", "more"}} {
+		if _, err := parseLintArgs(args); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LLMCLI describes an assistant CLI that receives the prompt on stdin.
+type LLMCLI interface {
+	// Name is the human-readable name used in messages
+	Name() string
+	// Command returns the binary to look up in PATH and its arguments
+	Command() (binary string, args []string)
+	// InstallHint tells the user how to get the CLI when it is missing
+	InstallHint() string
+}
+
+type claudeCLI struct{}
+
+func (claudeCLI) Name() string { return "Claude Code" }
+
+// Command uses --print with streaming output for real-time feedback.
+// This avoids the raw mode TTY issue of the interactive session.
+func (claudeCLI) Command() (string, []string) {
+	return "claude", []string{"--print",
+		"--input-format", "text",
+		"--output-format", "stream-json",
+		"--include-partial-messages",
+		"--verbose"}
+}
+
+func (claudeCLI) InstallHint() string {
+	return "Install Claude Code from: https://claude.ai/code"
+}
+
+type codexCLI struct{}
+
+func (codexCLI) Name() string { return "Codex CLI" }
+
+// Command runs codex non-interactively; "-" reads the prompt from stdin.
+func (codexCLI) Command() (string, []string) {
+	return "codex", []string{"exec", "--full-auto", "-"}
+}
+
+func (codexCLI) InstallHint() string {
+	return "Install Codex CLI from: https://github.com/openai/codex"
+}
+
+// customCLI runs an arbitrary user-supplied command line
+type customCLI struct {
+	argv []string
+}
+
+func (c customCLI) Name() string { return c.argv[0] }
+
+func (c customCLI) Command() (string, []string) { return c.argv[0], c.argv[1:] }
+
+func (c customCLI) InstallHint() string {
+	return fmt.Sprintf("Check that %s is installed and on your PATH", c.argv[0])
+}
+
+// llmCLIs are the assistants selectable with --llm
+var llmCLIs = map[string]LLMCLI{
+	"claude": claudeCLI{},
+	"codex":  codexCLI{},
+}
+
+const defaultLLM = "claude"
+
+// selectLLM resolves --llm and --llm-cmd into a CLI; --llm-cmd takes precedence
+func selectLLM(name, command string) (LLMCLI, error) {
+	if command != "" {
+		argv := strings.Fields(command)
+		if len(argv) == 0 {
+			return nil, fmt.Errorf("--llm-cmd must not be empty")
+		}
+		return customCLI{argv: argv}, nil
+	}
+
+	if name == "" {
+		name = defaultLLM
+	}
+	cli, ok := llmCLIs[name]
+	if !ok {
+		var names []string
+		for n := range llmCLIs {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown --llm %q (supported: %s)", name, strings.Join(names, ", "))
+	}
+	return cli, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectLLM(t *testing.T) {
+	tests := []struct {
+		name         string
+		llm          string
+		cmd          string
+		expectBinary string
+		expectArgs   []string
+		expectErr    bool
+	}{
+		{name: "default is claude", expectBinary: "claude", expectArgs: []string{"--print", "--input-format", "text", "--output-format", "stream-json", "--include-partial-messages", "--verbose"}},
+		{name: "codex", llm: "codex", expectBinary: "codex", expectArgs: []string{"exec", "--full-auto", "-"}},
+		{name: "custom command", cmd: "my-llm --model big", expectBinary: "my-llm", expectArgs: []string{"--model", "big"}},
+		{name: "custom command wins over llm", llm: "codex", cmd: "other", expectBinary: "other", expectArgs: []string{}},
+		{name: "unknown llm", llm: "nope", expectErr: true},
+		{name: "blank custom command", cmd: "   ", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, err := selectLLM(tt.llm, tt.cmd)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("expectErr %v, got %v", tt.expectErr, err)
+			}
+			if tt.expectErr {
+				return
+			}
+
+			binary, args := cli.Command()
+			if binary != tt.expectBinary {
+				t.Errorf("binary: got %q, want %q", binary, tt.expectBinary)
+			}
+			if strings.Join(args, " ") != strings.Join(tt.expectArgs, " ") {
+				t.Errorf("args: got %v, want %v", args, tt.expectArgs)
+			}
+			if cli.InstallHint() == "" {
+				t.Errorf("expected an install hint")
+			}
+		})
+	}
+}
+
+func TestParsePromptArgsLLM(t *testing.T) {
+	opts, err := parsePromptArgs([]string{"--llm", "codex"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.LLM.Name() != "Codex CLI" {
+		t.Errorf("got %q, want Codex CLI", opts.LLM.Name())
+	}
+
+	if _, err := parsePromptArgs([]string{"--llm", "unknown"}); err == nil {
+		t.Errorf("expected error for unknown --llm")
+	}
+}
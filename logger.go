@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// LogLevel controls how much is written to stderr
+type LogLevel int
+
+const (
+	LogQuiet   LogLevel = iota - 1 // only warnings and errors
+	LogNormal                      // plus informational progress messages; the zero value
+	LogVerbose                     // plus per-file discovery and parsing details
+)
+
+// Logger writes diagnostics to stderr. Warnings and errors are always shown;
+// informational messages are suppressed by --quiet and debug messages only
+// appear with --verbose.
+type Logger struct {
+	level LogLevel
+	out   io.Writer
+}
+
+var logger = &Logger{level: LogNormal, out: os.Stderr}
+
+func (l *Logger) SetLevel(level LogLevel) {
+	l.level = level
+}
+
+// Infof prints an informational message unless running quietly
+func (l *Logger) Infof(format string, args ...any) {
+	if l.level >= LogNormal {
+		fmt.Fprintf(l.out, format, args...)
+	}
+}
+
+// Debugf prints a diagnostic message only when running verbosely
+func (l *Logger) Debugf(format string, args ...any) {
+	if l.level >= LogVerbose {
+		fmt.Fprintf(l.out, format, args...)
+	}
+}
+
+// Warnf prints a warning regardless of level
+func (l *Logger) Warnf(format string, args ...any) {
+	fmt.Fprintf(l.out, format, args...)
+}
+
+// Errorf prints an error regardless of level
+func (l *Logger) Errorf(format string, args ...any) {
+	fmt.Fprintf(l.out, format, args...)
+}
+
+// parseGlobalFlags removes the flags that apply to every command (--quiet,
+// --verbose) from the front of args, before the command or directory, and returns
+// the requested log level alongside the remaining args. Those given later are
+// parsed by each command with logLevelFlag, so that another flag's value (as in
+// --title -q) is never taken for one.
+func parseGlobalFlags(args []string) (LogLevel, []string) {
+	level := LogNormal
+	for len(args) > 0 {
+		flagLevel, ok := logLevelFlag(args[0])
+		if !ok {
+			break
+		}
+		level, args = flagLevel, args[1:]
+	}
+	return level, args
+}
+
+// logLevelFlag returns the log level arg selects, if it is --quiet or --verbose
+func logLevelFlag(arg string) (LogLevel, bool) {
+	switch arg {
+	case "-q", "--quiet":
+		return LogQuiet, true
+	case "--verbose":
+		return LogVerbose, true
+	}
+	return LogNormal, false
+}
+
+// setLogLevel applies a level parsed with a command's flags, leaving the one
+// parseGlobalFlags set if neither flag was given there
+func setLogLevel(level LogLevel) {
+	if level != LogNormal {
+		logger.SetLevel(level)
+	}
+}
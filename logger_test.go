@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseGlobalFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		expectLevel LogLevel
+		expectRest  []string
+	}{
+		{name: "no global flags", args: []string{"./examples", "--output", "out.svg"}, expectLevel: LogNormal, expectRest: []string{"./examples", "--output", "out.svg"}},
+		{name: "quiet before directory", args: []string{"--quiet", "./examples"}, expectLevel: LogQuiet, expectRest: []string{"./examples"}},
+		{name: "short quiet before subcommand", args: []string{"-q", "--verbose", "prompt"}, expectLevel: LogVerbose, expectRest: []string{"prompt"}},
+		{name: "later flags left to the command", args: []string{"./examples", "--verbose"}, expectLevel: LogNormal, expectRest: []string{"./examples", "--verbose"}},
+		{name: "flag value", args: []string{"./examples", "--title", "-q"}, expectLevel: LogNormal, expectRest: []string{"./examples", "--title", "-q"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, rest := parseGlobalFlags(tt.args)
+			if level != tt.expectLevel {
+				t.Errorf("level: got %v, want %v", level, tt.expectLevel)
+			}
+			if strings.Join(rest, " ") != strings.Join(tt.expectRest, " ") {
+				t.Errorf("rest: got %v, want %v", rest, tt.expectRest)
+			}
+		})
+	}
+}
+
+func TestLogLevelFlagsInCommands(t *testing.T) {
+	if opts, err := parseArgsSlice([]string{"./examples", "--verbose"}); err != nil || opts.LogLevel != LogVerbose {
+		t.Errorf("--verbose: got %v, %v", opts.LogLevel, err)
+	}
+	opts, err := parseArgsSlice([]string{"./examples", "--title", "-q", "--placeholder-text", "--verbose"})
+	if err != nil || opts.Title != "-q" || opts.PlaceholderText != "--verbose" || opts.LogLevel != LogNormal {
+		t.Errorf("expected flag values to be kept, got %q, %q, %v, %v", opts.Title, opts.PlaceholderText, opts.LogLevel, err)
+	}
+	if promptOpts, err := parsePromptArgs([]string{"-q", "--print-only"}); err != nil || promptOpts.LogLevel != LogQuiet || !promptOpts.PrintOnly {
+		t.Errorf("prompt -q: got %+v, %v", promptOpts, err)
+	}
+	if indexOpts, err := parseIndexArgs([]string{"docs", "--quiet"}); err != nil || indexOpts.LogLevel != LogQuiet {
+		t.Errorf("index --quiet: got %+v, %v", indexOpts, err)
+	}
+}
+
+func TestLoggerQuiet(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Logger{level: LogQuiet, out: &buf}
+
+	l.Infof("progress\n")
+	l.Warnf("warning\n")
+	l.Errorf("error\n")
+
+	if got := buf.String(); got != "warning\nerror\n" {
+		t.Errorf("got %q, want only the warning and error", got)
+	}
+
+	buf.Reset()
+	l.SetLevel(LogNormal)
+	l.Infof("progress\n")
+	if buf.String() != "progress\n" {
+		t.Errorf("expected info output at normal level, got %q", buf.String())
+	}
+}
+
+func TestLoggerVerbose(t *testing.T) {
+	var buf bytes.Buffer
+	l := &Logger{level: LogNormal, out: &buf}
+
+	l.Debugf("detail\n")
+	if buf.Len() != 0 {
+		t.Errorf("expected no debug output at normal level, got %q", buf.String())
+	}
+
+	l.SetLevel(LogVerbose)
+	l.Debugf("detail\n")
+	l.Infof("progress\n")
+	if got := buf.String(); got != "detail\nprogress\n" {
+		t.Errorf("got %q, want debug and info output", got)
+	}
+}
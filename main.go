@@ -2,19 +2,28 @@ package main
 
 import (
 	"bytes"
-	_ "embed"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 //go:embed navigation.js
@@ -23,33 +32,235 @@ var navigationJS string
 //go:embed C4-DIAGRAM-SPEC.md
 var c4DiagramSpec string
 
+// builtinSpecs are the alternatives to c4DiagramSpec that --spec selects by name
+//
+//go:embed specs/*.md
+var builtinSpecs embed.FS
+
 type SVGStacker struct {
 	diagrams   map[string]DiagramInfo
 	inputDir   string
 	outputFile string
 	title      string
 	tempDir    string
+	fontFaces  string   // @font-face rules for --embed-fonts
+	script     string   // navigation script: the embedded navigation.js, or --js
+	extraCSS   string   // contents of the --css files
+	imageDefs  string   // <symbol>s of the inline images shared between diagrams
+	background string   // the --background-image <image>
+	ignored    []string // globs from the input directory's .svg-stackerignore
+	opts       Options
+
+	renderTime time.Duration // spent rendering .puml and other sources, for --stats
+
+	linkMeanings []linkMeaning // the --link-legend-file, nil without one
+
+	// With several input directories each is loaded into its own stacker, shown as
+	// a tab; idPrefix keeps their level ids apart within the one document
+	stacks   []*SVGStacker
+	label    string
+	idPrefix string
+}
+
+// Options holds everything that can be configured from the command line.
+type Options struct {
+	InputDir      string
+	MoreInputDirs []string // further input directories, each shown as its own tab
+	OutputFile    string   // "" or "-" writes to stdout
+	OutputDir     string   // write to an auto-named file in this directory instead of OutputFile
+	outputFromEnv bool     // OutputFile was taken from SVG_STACKER_OUTPUT, not --output
+	LogLevel      LogLevel // from --quiet or --verbose in the command's flags; LogNormal if neither
+	Title         string
+	NoNotesToggle bool           // omit the "Hide Notes" toggle button
+	NoFitToggle   bool           // omit the "Native Size" toggle button
+	KeepLinks     bool           // keep <a href> hyperlinks instead of converting them to onclick navigation
+	KeepMetadata  bool           // keep <metadata> and Inkscape/Sodipodi editor elements and attributes
+	NoClean       bool           // embed diagram content verbatim, without cleaning or re-encoding it
+	NoPretty      bool           // embed cleaned diagram content as it is instead of re-indenting it
+	EmbedFonts    []string       // font files ("path" or "Family=path") to inline as @font-face rules
+	Font          string         // font-family for the header, buttons and placeholders
+	JSFile        string         // navigation script to use instead of the embedded navigation.js
+	ScriptType    string         // type attribute of the <script> element ("" for the default)
+	NoCDATA       bool           // escape the script and --css stylesheets instead of wrapping them in CDATA sections
+	CSSFiles      []string       // stylesheets appended to the generated <style> element
+	Include       []string       // if set, only input files whose name matches one of these globs are used
+	Exclude       []string       // input files whose name matches one of these globs are skipped
+	Only          []string       // if set, stack just these levels; each must be present
+	LevelPattern  *regexp.Regexp // if set, assigns levels from its "level" capture instead of the level names in file names
+	RTL           bool           // right-align the title and lay out nav buttons right-to-left
+	ButtonGap     int            // horizontal space between nav buttons in pixels (0 for the default)
+	Legend        bool           // add a collapsible panel describing each C4 level
+	Overview      bool           // add a toggle showing every level as a clickable thumbnail
+	Mobile        bool           // stack every level in one scrolling column sized to the viewport
+
+	BackgroundImage    string            // image file inlined behind the diagram layers, e.g. a faint logo
+	BackgroundOpacity  float64           // opacity of the background image (0 for the default)
+	NoSourceBackground bool              // keep the white container instead of the source diagram's background fill
+	LevelTint          bool              // shade each level's container, from light (context) to darker (code)
+	LevelColors        map[string]string // container fill for a level, overriding the tint and source background
+	WrapLinkLabels     int               // split link labels longer than this many characters into lines (0 to leave them)
+
+	Scale       float64            // multiply every diagram's native size by this (0 for unscaled)
+	LevelScales map[string]float64 // the factor for one level, overriding Scale
+
+	LinkLegend     bool   // list the relationship line styles in the legend panel (implies Legend)
+	LinkLegendFile string // JSON list of what the line styles mean; only those it names are listed
+
+	AnimationDuration time.Duration // cross-fade between levels over this long (0 to switch instantly)
+	PrintAll          bool          // print every level stacked vertically instead of just the current one
+	Conformant        bool          // avoid markup SVG validators flag: placeholder sizes and un-namespaced metadata
+	NoPlaceholder     bool          // emit no layer at all for levels without a diagram
+	PlaceholderText   string        // text of the layer for a level without a diagram, "{level}" standing for its name
+	PlaceholderFill   string        // background of that layer
+	SortBy            string        // layer and nav button order: "c4" (default), "name", "mtime" or "number"
+	AspectWarnRange   [2]float64    // warn about diagrams whose width/height is outside [min, max] (zeros for the default)
+	MaxFileSize       int64         // refuse source SVGs larger than this many bytes (0 for the default)
+	IfChanged         bool          // leave the output file untouched if only the timestamp would change
+	Check             bool          // compare the output file with what would be generated instead of writing it
+	Stats             bool          // print level count, output size and timings to stderr after generating
+	Open              bool          // open the output file in the default browser once written
+	Poster            string        // also rasterize the context level to this PNG, as a static preview
+	PDF               string        // also render every level to a page of this PDF
+
+	// ContentTransform, if set, rewrites each diagram's content after cleaning and
+	// just before it is embedded. It is only settable from code, not the command line.
+	ContentTransform func(level, content string) string
+
+	TempDir         string        // parent of the directories for rendered and extracted files ("" for the system default)
+	KeepTemp        bool          // leave those directories in place for inspection
+	Strict          bool          // fail if any .puml file fails to render instead of leaving it out
+	PlantUMLServer  string        // render .puml via this server instead of the local binary
+	PlantUMLTimeout time.Duration // maximum time for rendering all .puml files
+	CacheDir        string        // keep rendered .puml SVGs here and re-render only changed files
 }
 
+const (
+	defaultPlantUMLTimeout = 60 * time.Second
+	defaultLLMTimeout      = 30 * time.Minute
+	defaultOutputName      = "stacked-c4-architecture.svg"
+	defaultFontFamily      = "Arial, sans-serif"
+	defaultScriptType      = "text/ecmascript"
+	defaultPlaceholderText = "{level} diagram not found"
+	defaultPlaceholderFill = "#ecf0f1"
+
+	// headerWidth is the initial width of the generated SVG; JavaScript resizes it
+	// to the viewport on load
+	headerWidth = 1920
+
+	defaultAnimationDuration = 250 * time.Millisecond
+	defaultMaxFileSize       = 50 << 20
+	defaultBackgroundOpacity = 0.1
+
+	// Diagrams more elongated than this render poorly in the landscape canvas and
+	// usually mean a PlantUML layout went wrong
+	defaultMinAspectRatio = 0.2
+	defaultMaxAspectRatio = 5.0
+
+	defaultButtonGap = 13
+	navFontSize      = 14
+	navCharWidth     = 0.6 // approximate glyph width as a fraction of the font size
+	navPadding       = 13  // space between a nav button's edge and its label
+	navMinWidth      = 104
+)
+
+// c4Levels are the C4 model levels in drill-down order
+var c4Levels = []string{"context", "container", "component", "code"}
+
+// levelAliases maps a --level-pattern capture, lowercased and without leading
+// zeros, to a level; each level's own name maps to itself too
+var levelAliases = map[string]string{"1": "context", "2": "container", "3": "component", "4": "code"}
+
+// generatorNamespace is the namespace of the generator metadata with --conformant
+const generatorNamespace = "urn:x-stacked-c4-svg"
+
+// scriptTypes are the accepted --script-type values
+var scriptTypes = []string{"text/ecmascript", "application/ecmascript", "text/javascript", "application/javascript"}
+
+// sortOrders are the accepted --sort-by values
+var sortOrders = []string{"c4", "name", "mtime", "number"}
+
+// levelTintLight and levelTintDark are the --level-tint container fills of the
+// first and last levels present; those in between are blended
+var (
+	levelTintLight = [3]uint8{0xfb, 0xfc, 0xfd}
+	levelTintDark  = [3]uint8{0xdc, 0xe3, 0xea}
+)
+
 type DiagramInfo struct {
 	content     string
 	viewBox     string
 	width       float64
 	height      float64
 	aspectRatio float64
+	namespaces  map[string]string // xmlns:* declarations of the source <svg>, by prefix
+	background  string            // fill of the source's full-canvas background, if it has one
+	title       string            // text of the source's top-level <title>, if it has one
+	linkStyles  []linkStyle       // distinct relationship line styles, for --link-legend
+	file        string            // the .svg file it was loaded from
+	modTime     time.Time         // modification time of file
 }
 
-// checks if a string is valid XML
+// Limits on what ValidateXML accepts, well beyond any real diagram, so a hostile
+// file fails fast instead of tying up memory and time
+const (
+	maxXMLDepth  = 512
+	maxXMLTokens = 10_000_000
+)
+
+// checks if a string is valid XML. A DOCTYPE is allowed (its DTD is never
+// fetched) but one declaring entities is rejected, as are documents nested or
+// sized past the limits above.
 func ValidateXML(content string) error {
 	decoder := xml.NewDecoder(strings.NewReader(content))
-	for {
-		_, err := decoder.Token()
+	// Strict with no Entity map: only the five predefined entities are recognised,
+	// so a reference to anything declared in a DOCTYPE is an error, never expanded
+	decoder.Strict = true
+	decoder.Entity = nil
+
+	depth := 0
+	for tokens := 0; ; tokens++ {
+		if tokens > maxXMLTokens {
+			return fmt.Errorf("more than %d XML tokens", maxXMLTokens)
+		}
+		token, err := decoder.Token()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			return err
 		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth++; depth > maxXMLDepth {
+				return fmt.Errorf("elements nested more than %d deep", maxXMLDepth)
+			}
+		case xml.EndElement:
+			depth--
+		case xml.Directive:
+			if err := checkDirective(t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// externalEntityRegex matches an <!ENTITY> declaration whose value comes from a
+// SYSTEM or PUBLIC identifier, i.e. a file or URL
+var externalEntityRegex = regexp.MustCompile(`<!ENTITY\s+(?:%\s+)?[^\s>]+\s+(?:SYSTEM|PUBLIC)\b`)
+
+// entityRegex matches any <!ENTITY> declaration
+var entityRegex = regexp.MustCompile(`<!ENTITY\b`)
+
+// checkDirective rejects a <!DOCTYPE> that declares entities: external ones could
+// read local files or make requests, and internal ones can nest into an
+// exponential expansion ("billion laughs"), were anything ever to resolve them
+func checkDirective(directive xml.Directive) error {
+	if externalEntityRegex.Match(directive) {
+		return fmt.Errorf("DOCTYPE declares an external entity, which is not allowed")
+	}
+	if entityRegex.Match(directive) {
+		return fmt.Errorf("DOCTYPE declares entities, which are not allowed")
 	}
 	return nil
 }
@@ -71,13 +282,137 @@ func printUsage() {
 
 COMMANDS:
   prompt              Generate C4 diagram prompt for Claude Code
-  <directory>         Combine SVG/PlantUML files into stacked SVG (default)
+  serve               Serve the stacked SVG over HTTP, regenerating it when files change
+  levels [--json]     List the level names recognized in diagram file names
+  diff FILE --dir DIR Regenerate from DIR and report which levels differ from FILE
+  index DIR [--output FILE]
+                      Write an HTML page linking to every stacked SVG under DIR
+  lint DIR [OPTIONS]  Report every problem that would break or skew stacking DIR: files
+                      without a level, duplicate levels, malformed SVGs, unusable sizes
+                      and missing levels
+  <directory>...      Combine SVG/PlantUML files into stacked SVG (default); a .zip,
+                      .tar.gz or .tgz archive of them can be given instead. With
+                      several directories, each becomes a tab in the one output
 
 OPTIONS:
   -h, --help          Show this help message and exit
   -v, --version       Show version information and exit
-  --output FILE       Output file path (default: stdout)
+  -q, --quiet         Only print warnings and errors to stderr (applies to all commands)
+  --verbose           Also print file discovery, level assignment and dimension details
+  --output FILE       Output file path, or - for stdout (default: stdout)
+  --output-dir DIR    Write to DIR, naming the file after the title (an existing file is overwritten)
+  --if-changed        Skip writing the output file if its content is unchanged, and print the
+                      content hash to stderr
+  --check             Write nothing, but exit with 5 and list the changed levels if the output
+                      file differs from what would be generated, e.g. to catch stale SVGs in CI
+  --poster FILE.png   Also rasterize the context level to a PNG, e.g. for OpenGraph previews
+                      (needs rsvg-convert)
+  --pdf FILE.pdf      Also render each level to its own page of a PDF, under a header naming it
+                      (needs rsvg-convert)
+  --open              Open the output file in the default browser once it is written
+  --stats             Print the number of levels, output size, largest diagram and render and
+                      stacking times to stderr
   --title TITLE       Title for the diagram (default: "🏗️ Stacked C4 Architecture")
+  --title-file FILE   Read the title from the first line of FILE
+  --no-notes-toggle   Omit the "Hide Notes" toggle button
+  --no-fit-toggle     Omit the "Native Size" toggle button
+  --minimal-ui        Omit all toggle buttons (same as both flags above)
+  --keep-links        Keep $link targets as real hyperlinks instead of click-to-drill-down
+  --keep-metadata     Keep <metadata>, RDF and Inkscape/Sodipodi editor markup in the diagrams
+  --pretty            Indent the embedded diagrams (default)
+  --no-pretty         Embed the cleaned diagrams without re-indenting them: faster, and smaller
+                      for compact sources
+  --wrap-link-labels N
+                      Split relationship labels longer than N characters into several lines
+  --no-clean          Embed diagrams exactly as they are, keeping scripts and links (for debugging)
+  --no-source-background
+                      Keep the white diagram background even when a source diagram has its own
+  --level-tint        Shade each level's background, lightest for context and darkest for code
+  --scale [LEVEL=]FACTOR
+                      Multiply the native size of every diagram, or of one level's, by FACTOR,
+                      e.g. 1.5 or component=0.75 (repeatable); a level's factor overrides the
+                      global one. Auto Scale mode still fits the diagram to the window
+  --level-color LEVEL=COLOR
+                      Background for one level, e.g. component=#eef6ee (repeatable); takes
+                      precedence over --level-tint and source backgrounds
+  --embed-fonts [FAMILY=]FILE
+                      Inline a .woff2/.woff/.ttf/.otf font (repeatable); FAMILY defaults to the file name
+  --background-image FILE
+                      Inline a .png/.jpg/.gif/.webp/.svg image behind the diagrams as a watermark
+  --background-opacity N
+                      Opacity of the background image, above 0 and up to 1 (default: 0.1)
+  --font FAMILY       Font for the title and buttons (default: "Arial, sans-serif")
+  --js FILE           Use FILE as the navigation script instead of the built-in one (see README)
+  --script-type TYPE  type of the <script> element: text/ecmascript (default),
+                      application/ecmascript, text/javascript or application/javascript
+  --no-cdata          Escape the script and --css stylesheets instead of wrapping them in
+                      CDATA sections, for SVG sanitizers that reject CDATA
+  --css FILE          Append the CSS rules in FILE to the generated stylesheet (repeatable)
+  --include GLOB      Only use input files whose name matches GLOB, e.g. "0*" (repeatable)
+  --exclude GLOB      Skip input files whose name matches GLOB, e.g. "*-draft.*" (repeatable)
+                      Globs in the input directory's .svg-stackerignore are skipped too
+  --only LEVELS       Stack just these comma-separated levels, e.g. context,container
+  --level-pattern REGEX
+                      Assign levels from the (?P<level>...) group of REGEX matched against each
+                      file name, e.g. "^L(?P<level>[1-4])-"; the group is 1-4 or a level name
+  --rtl               Right-to-left header: right-aligned title, nav buttons from the right
+  --button-gap PX     Space between navigation buttons in pixels (default: 13)
+  --legend            Add a collapsible panel explaining each C4 level in the stack
+  --link-legend       Also list the line styles of relationships in the legend (implies --legend)
+  --link-legend-file FILE
+                      JSON list of what line styles mean, e.g. [{"dash": "dashed", "meaning":
+                      "Asynchronous"}]; only the styles it names are listed (implies --link-legend)
+  --overview          Add an "Overview" toggle showing every level as a clickable thumbnail
+  --mobile            Lay every level out in one column that fits the screen width and
+                      scrolls, for phones, instead of showing one level at a time
+  --sort-by ORDER     Order of the layers and nav buttons: c4 (default), name (file name),
+                      mtime (oldest first) or number (numeric file name prefix)
+  --no-placeholder    Emit no layer for C4 levels without a diagram
+  --placeholder-text TEXT
+                      Text of the layer for a level without a diagram; {level} is replaced by
+                      its name (default: "{level} diagram not found")
+  --placeholder-fill COLOR
+                      Background of that layer (default: #ecf0f1)
+  --conformant        Generate output that passes SVG validators: real diagram sizes instead of
+                      placeholders, version="1.1" and namespaced generator metadata
+  --print-all         Print every level one after another instead of only the current one
+  --animate           Cross-fade and slide between levels instead of switching instantly
+  --animate-duration DURATION
+                      Length of the level transition, implies --animate (default: 250ms)
+  --cache-dir DIR     Cache rendered .puml files in DIR and re-render only those that changed
+                      (changes to !included files are not detected)
+  --plantuml-server URL
+                      Render .puml files via a PlantUML server instead of a local plantuml
+  --plantuml-timeout DURATION
+                      Abort PlantUML rendering after DURATION (default: 60s)
+  --strict            Fail if any .puml file fails to render, instead of leaving out its level
+  --temp-dir DIR      Write rendered PlantUML and extracted archive files under DIR instead
+                      of the system temp directory
+  --keep-temp         Keep the rendered PlantUML SVGs (and extracted archive files) and print
+                      where they are
+  --aspect-warn MIN:MAX
+                      Warn about diagrams whose width/height ratio is outside MIN to MAX
+                      (default: 0.2:5)
+  --max-file-size SIZE
+                      Refuse source SVGs larger than SIZE, e.g. 500KB or 100MB (default: 50MB)
+
+PROMPT OPTIONS:
+  --timeout DURATION  Abort the assistant session after DURATION (default: 30m)
+  --print-only        Print the assembled prompt to stdout instead of running the assistant
+  --c4-dir DIR        Directory for the generated .puml files (default: docs/c4/)
+  --readme-lines N    Maximum README lines included in the prompt (default: 10)
+  --prompt-out FILE   Save the assembled prompt to FILE
+  --llm NAME          Assistant CLI to run: claude (default) or codex
+  --llm-cmd CMD       Run CMD with the prompt on stdin instead of a built-in assistant
+  --spec NAME|FILE    Diagram conventions to ask for: c4 (default), c4-brief, arc42, or a
+                      Markdown file of your own
+
+DIFF OPTIONS (plus any OPTIONS above except --output/--output-dir):
+  --dir DIR           Directory of SVG/PlantUML files to regenerate from (required)
+
+SERVE OPTIONS (plus any OPTIONS above except --output/--output-dir):
+  --dir DIR           Directory of SVG/PlantUML files to serve (required)
+  --addr ADDR         Address to listen on (default: :8080)
 
 EXAMPLES:
   # Generate C4 diagrams with Claude
@@ -87,72 +422,475 @@ EXAMPLES:
   svg-stacker ./examples
   svg-stacker ./examples --output output.svg
   svg-stacker ./examples --title "My Architecture"
+
+  # One file with a tab per system
+  svg-stacker docs/c4/billing docs/c4/shipping --output systems.svg
+
+  # Serve docs/c4 at http://localhost:8080/
+  svg-stacker serve --dir docs/c4
+
+  # List every stacked SVG of a docs site on one page
+  svg-stacker index docs --output docs/architecture.html
+
+  # Check a diagram directory before stacking it
+  svg-stacker lint docs/c4
+
+  # Fail CI if the committed SVG is out of date
+  svg-stacker diff docs/c4/stacked-c4-architecture.svg --dir docs/c4
+
+Any argument of the form @FILE is replaced by the whitespace-separated arguments in FILE.
+
+ENVIRONMENT:
+  SVG_STACKER_TITLE   Title to use when neither --title nor --title-file is given
+  SVG_STACKER_OUTPUT  Output file to use when neither --output nor --output-dir is given
+
+EXIT CODES:
+  0  Success
+  1  Invalid arguments or any other failure
+  2  No C4 diagrams found in the input directory
+  3  A required external tool (plantuml, rsvg-convert) is not installed
+  4  An input SVG is malformed
+  5  diff or --check found differences
+  6  lint found only warnings
+  7  lint found errors
 `)
 }
 
+// parseLevelsArgs parses the arguments following 'levels', returning whether to print JSON
+func parseLevelsArgs(args []string) (asJSON bool, err error) {
+	for _, arg := range args {
+		if _, ok := logLevelFlag(arg); ok {
+			continue // levels only writes to stdout
+		}
+		if arg != "--json" {
+			return false, fmt.Errorf("unknown flag for levels: %s", arg)
+		}
+		asJSON = true
+	}
+	return asJSON, nil
+}
+
+// printLevels writes the levels extractLevel recognizes, in drill-down order: one
+// name per line, or as a JSON array of names and descriptions
+func printLevels(w io.Writer, asJSON bool) error {
+	if !asJSON {
+		for _, level := range c4Levels {
+			fmt.Fprintln(w, level)
+		}
+		return nil
+	}
+
+	type levelInfo struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	levels := make([]levelInfo, 0, len(c4Levels))
+	for _, level := range c4Levels {
+		levels = append(levels, levelInfo{Name: level, Description: levelDescriptions[level]})
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(levels)
+}
+
 func printVersion() {
 	fmt.Printf("svg-stacker version %s\n", version)
 }
 
-func parseArgsSlice(args []string) (inputDir, outputFile, title string, err error) {
+func parseArgsSlice(args []string) (opts Options, err error) {
 	if len(args) < 1 {
-		return "", "", "", fmt.Errorf("directory argument required")
+		return Options{}, fmt.Errorf("directory argument required")
 	}
 
-	// Check for subcommands and help/version flags first
+	// Check for subcommands and help/version flags first. A subcommand is only one
+	// in first place, so a directory or flag value of the same name isn't taken for it.
+	switch args[0] {
+	case "prompt", "serve", "levels", "diff", "index", "lint":
+		return Options{}, fmt.Errorf("%s", args[0])
+	}
 	for _, arg := range args {
-		if arg == "prompt" {
-			return "", "", "", fmt.Errorf("prompt")
-		}
 		if arg == "-h" || arg == "--help" {
-			return "", "", "", fmt.Errorf("help")
+			return Options{}, fmt.Errorf("help")
 		}
 		if arg == "-v" || arg == "--version" {
-			return "", "", "", fmt.Errorf("version")
+			return Options{}, fmt.Errorf("version")
 		}
 	}
 
-	inputDir = args[0]
-	outputFile = ""
-	title = ""
+	var titleFile string
 
-	for i := 1; i < len(args); i++ {
+	for i := 0; i < len(args); i++ {
 		switch args[i] {
 		case "--output":
-			if i+1 < len(args) {
-				outputFile = args[i+1]
-				i++
-			} else {
-				return "", "", "", fmt.Errorf("--output requires an argument")
+			if opts.OutputFile, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--output-dir":
+			if opts.OutputDir, err = flagValue(args, &i); err != nil {
+				return Options{}, err
 			}
 		case "--title":
-			if i+1 < len(args) {
-				title = args[i+1]
-				i++
+			if opts.Title, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--title-file":
+			if titleFile, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--temp-dir":
+			if opts.TempDir, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--strict":
+			opts.Strict = true
+		case "--keep-temp":
+			opts.KeepTemp = true
+		case "--cache-dir":
+			if opts.CacheDir, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--plantuml-server":
+			if opts.PlantUMLServer, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--plantuml-timeout":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			if opts.PlantUMLTimeout, err = parseTimeout(v); err != nil {
+				return Options{}, fmt.Errorf("--plantuml-timeout: %w", err)
+			}
+		case "--no-notes-toggle":
+			opts.NoNotesToggle = true
+		case "--no-fit-toggle":
+			opts.NoFitToggle = true
+		case "--minimal-ui":
+			opts.NoNotesToggle = true
+			opts.NoFitToggle = true
+		case "--keep-links":
+			opts.KeepLinks = true
+		case "--keep-metadata":
+			opts.KeepMetadata = true
+		case "--pretty":
+			opts.NoPretty = false
+		case "--no-pretty":
+			opts.NoPretty = true
+		case "--no-clean":
+			opts.NoClean = true
+		case "--embed-fonts":
+			font, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			opts.EmbedFonts = append(opts.EmbedFonts, font)
+		case "--background-image":
+			if opts.BackgroundImage, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--background-opacity":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			opacity, err := strconv.ParseFloat(v, 64)
+			if err != nil || opacity <= 0 || opacity > 1 {
+				return Options{}, fmt.Errorf("--background-opacity must be a number above 0 and up to 1, got %q", v)
+			}
+			opts.BackgroundOpacity = opacity
+		case "--js":
+			if opts.JSFile, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--script-type":
+			if opts.ScriptType, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+			if !slices.Contains(scriptTypes, opts.ScriptType) {
+				return Options{}, fmt.Errorf("--script-type must be one of %s, got %q", strings.Join(scriptTypes, ", "), opts.ScriptType)
+			}
+		case "--no-cdata":
+			opts.NoCDATA = true
+		case "--css":
+			file, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			opts.CSSFiles = append(opts.CSSFiles, file)
+		case "--level-pattern":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			if opts.LevelPattern, err = regexp.Compile(v); err != nil {
+				return Options{}, fmt.Errorf("--level-pattern: %w", err)
+			}
+			if opts.LevelPattern.SubexpIndex("level") < 0 {
+				return Options{}, fmt.Errorf("--level-pattern %q has no (?P<level>...) group", v)
+			}
+		case "--only":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			for _, level := range strings.Split(v, ",") {
+				if level = strings.TrimSpace(level); level != "" {
+					opts.Only = append(opts.Only, level)
+				}
+			}
+		case "--include", "--exclude":
+			flag := args[i]
+			pattern, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return Options{}, fmt.Errorf("%s %q: %w", flag, pattern, err)
+			}
+			if flag == "--include" {
+				opts.Include = append(opts.Include, pattern)
 			} else {
-				return "", "", "", fmt.Errorf("--title requires an argument")
+				opts.Exclude = append(opts.Exclude, pattern)
+			}
+		case "--font":
+			if opts.Font, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--rtl":
+			opts.RTL = true
+		case "--legend":
+			opts.Legend = true
+		case "--overview":
+			opts.Overview = true
+		case "--link-legend":
+			opts.LinkLegend, opts.Legend = true, true
+		case "--link-legend-file":
+			if opts.LinkLegendFile, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+			opts.LinkLegend, opts.Legend = true, true
+		case "--mobile":
+			opts.Mobile = true
+		case "--no-source-background":
+			opts.NoSourceBackground = true
+		case "--level-tint":
+			opts.LevelTint = true
+		case "--level-color":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			level, color, ok := strings.Cut(v, "=")
+			if !ok || !slices.Contains(c4Levels, level) || strings.TrimSpace(color) == "" {
+				return Options{}, fmt.Errorf("--level-color must be LEVEL=COLOR with LEVEL one of %s, got %q", strings.Join(c4Levels, ", "), v)
+			}
+			if opts.LevelColors == nil {
+				opts.LevelColors = make(map[string]string)
+			}
+			opts.LevelColors[level] = strings.TrimSpace(color)
+		case "--scale":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			level, factor, perLevel := strings.Cut(v, "=")
+			if !perLevel {
+				factor = v
+			} else if !slices.Contains(c4Levels, level) {
+				return Options{}, fmt.Errorf("--scale level must be one of %s, got %q", strings.Join(c4Levels, ", "), v)
+			}
+			scale, err := strconv.ParseFloat(strings.TrimSpace(factor), 64)
+			if err != nil || scale <= 0 || math.IsInf(scale, 0) {
+				return Options{}, fmt.Errorf("--scale factor must be a positive number, got %q", v)
+			}
+			if !perLevel {
+				opts.Scale = scale
+				break
+			}
+			if opts.LevelScales == nil {
+				opts.LevelScales = make(map[string]float64)
+			}
+			opts.LevelScales[level] = scale
+		case "--aspect-warn":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			if opts.AspectWarnRange, err = parseAspectRange(v); err != nil {
+				return Options{}, fmt.Errorf("--aspect-warn: %w", err)
+			}
+		case "--sort-by":
+			if opts.SortBy, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+			if !slices.Contains(sortOrders, opts.SortBy) {
+				return Options{}, fmt.Errorf("--sort-by must be one of %s, got %q", strings.Join(sortOrders, ", "), opts.SortBy)
+			}
+		case "--poster":
+			if opts.Poster, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--pdf":
+			if opts.PDF, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--open":
+			opts.Open = true
+		case "--stats":
+			opts.Stats = true
+		case "--no-placeholder":
+			opts.NoPlaceholder = true
+		case "--placeholder-text":
+			if opts.PlaceholderText, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--placeholder-fill":
+			if opts.PlaceholderFill, err = flagValue(args, &i); err != nil {
+				return Options{}, err
+			}
+		case "--conformant":
+			opts.Conformant = true
+		case "--print-all":
+			opts.PrintAll = true
+		case "--if-changed":
+			opts.IfChanged = true
+		case "--check":
+			opts.Check = true
+		case "--max-file-size":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			if opts.MaxFileSize, err = parseSize(v); err != nil {
+				return Options{}, fmt.Errorf("--max-file-size: %w", err)
+			}
+		case "--animate":
+			if opts.AnimationDuration == 0 {
+				opts.AnimationDuration = defaultAnimationDuration
 			}
+		case "--animate-duration":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			if opts.AnimationDuration, err = time.ParseDuration(v); err != nil || opts.AnimationDuration <= 0 {
+				return Options{}, fmt.Errorf("--animate-duration must be a positive duration, got %q", v)
+			}
+		case "--wrap-link-labels":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			if opts.WrapLinkLabels, err = strconv.Atoi(v); err != nil || opts.WrapLinkLabels < 1 {
+				return Options{}, fmt.Errorf("--wrap-link-labels must be a positive integer, got %q", v)
+			}
+		case "--button-gap":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return Options{}, err
+			}
+			if opts.ButtonGap, err = strconv.Atoi(v); err != nil || opts.ButtonGap < 1 {
+				return Options{}, fmt.Errorf("--button-gap must be a positive integer, got %q", v)
+			}
+		case "-q", "--quiet", "--verbose":
+			opts.LogLevel, _ = logLevelFlag(args[i])
 		case "-h", "--help", "-v", "--version":
 			// Already handled above
 		default:
+			// The first argument that isn't a flag or a flag's value is the input
+			// directory, and any others are further ones
+			if !strings.HasPrefix(args[i], "-") {
+				if opts.InputDir == "" {
+					opts.InputDir = args[i]
+				} else {
+					opts.MoreInputDirs = append(opts.MoreInputDirs, args[i])
+				}
+				continue
+			}
 			// Unknown flag
-			return "", "", "", fmt.Errorf("unknown flag: %s", args[i])
+			return Options{}, fmt.Errorf("unknown flag: %s", args[i])
+		}
+	}
+
+	if opts.InputDir == "" {
+		return Options{}, fmt.Errorf("directory argument required")
+	}
+	if opts.OutputFile != "" && opts.OutputDir != "" {
+		return Options{}, fmt.Errorf("--output and --output-dir cannot be used together")
+	}
+	if opts.Mobile && (opts.JSFile != "" || opts.Overview || opts.Legend || opts.PrintAll) {
+		return Options{}, fmt.Errorf("--mobile cannot be used with --js, --overview, --legend or --print-all")
+	}
+	// The environment supplies defaults for options not given as flags
+	if opts.Title == "" && titleFile == "" {
+		opts.Title = os.Getenv(envTitle)
+	}
+	if opts.OutputFile == "" && opts.OutputDir == "" {
+		opts.OutputFile = os.Getenv(envOutput)
+		opts.outputFromEnv = opts.OutputFile != ""
+	}
+	if titleFile != "" {
+		if opts.Title != "" {
+			return Options{}, fmt.Errorf("--title and --title-file cannot be used together")
 		}
+		if opts.Title, err = readTitleFile(titleFile); err != nil {
+			return Options{}, err
+		}
+	}
+	if opts.IfChanged && (opts.OutputFile == "" || opts.OutputFile == "-") && opts.OutputDir == "" {
+		return Options{}, fmt.Errorf("--if-changed requires --output or --output-dir")
+	}
+	if opts.Check && (opts.OutputFile == "" || opts.OutputFile == "-") && opts.OutputDir == "" {
+		return Options{}, fmt.Errorf("--check requires --output or --output-dir, the file to compare with")
 	}
 
-	return inputDir, outputFile, title, nil
+	return opts, nil
 }
 
 // ProjectContext holds discovered information about a project
 type ProjectContext struct {
-	Name       string
-	ReadmeSnip string
-	Languages  []string
-	MainFiles  []string
+	Name         string
+	ReadmeSnip   string
+	Languages    []string
+	MainFiles    []string
+	Module       string   // Go module path from go.mod, if any
+	Dependencies []string // notable dependencies from go.mod, package.json and requirements.txt
+
+	ExistingDiagrams []DiagramSource // .puml files already in the C4 directory, to be updated
+}
+
+// DiagramSource is the name and contents of a diagram source file
+type DiagramSource struct {
+	Name    string
+	Content string
+}
+
+// maxExistingDiagramBytes caps how much of each existing diagram goes into the prompt
+const maxExistingDiagramBytes = 16 << 10
+
+// existingDiagrams reads the .puml files in dir, sorted by name, so a re-run of
+// prompt can ask for them to be updated rather than regenerated from scratch
+func existingDiagrams(dir string) []DiagramSource {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.puml"))
+	sort.Strings(files)
+
+	var diagrams []DiagramSource
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil || len(bytes.TrimSpace(content)) == 0 {
+			continue
+		}
+		text := string(content)
+		if len(text) > maxExistingDiagramBytes {
+			text = strings.ToValidUTF8(text[:maxExistingDiagramBytes], "") + "\n' ... (truncated)"
+		}
+		diagrams = append(diagrams, DiagramSource{Name: filepath.Base(file), Content: text})
+	}
+	return diagrams
 }
 
 // gatherProjectContext analyzes the current directory to discover project information
-func gatherProjectContext() ProjectContext {
+func gatherProjectContext(readmeLines int) ProjectContext {
 	// Get current directory name as primary project name
 	wd, _ := os.Getwd()
 	dirName := filepath.Base(wd)
@@ -168,41 +906,11 @@ func gatherProjectContext() ProjectContext {
 
 	// Read README if it exists
 	if content, err := os.ReadFile("README.md"); err == nil {
-		lines := strings.Split(string(content), "\n")
-		if len(lines) > 10 {
-			ctx.ReadmeSnip = strings.Join(lines[:10], "\n")
-		} else {
-			ctx.ReadmeSnip = string(content)
-		}
+		ctx.ReadmeSnip = readmeSnippet(string(content), readmeLines)
 	}
 
 	// Detect primary languages by file extensions
-	langCount := make(map[string]int)
-	if entries, err := os.ReadDir("."); err == nil {
-		for _, entry := range entries {
-			if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
-				ext := filepath.Ext(entry.Name())
-				if ext != "" {
-					langCount[ext]++
-				}
-			}
-		}
-	}
-
-	// Get top languages
-	type langFreq struct {
-		ext   string
-		count int
-	}
-	var langs []langFreq
-	for ext, count := range langCount {
-		langs = append(langs, langFreq{ext, count})
-	}
-	sort.Slice(langs, func(i, j int) bool { return langs[i].count > langs[j].count })
-
-	for i := 0; i < len(langs) && i < 3; i++ {
-		ctx.Languages = append(ctx.Languages, langs[i].ext)
-	}
+	ctx.Languages = append(ctx.Languages, detectLanguages(".")...)
 
 	// List main files/dirs
 	mainItems := []string{"go.mod", "package.json", "Dockerfile", "Makefile", "src/", "cmd/", "main.go"}
@@ -212,471 +920,1836 @@ func gatherProjectContext() ProjectContext {
 		}
 	}
 
+	// Detect frameworks and libraries from dependency manifests
+	ctx.Module, ctx.Dependencies = detectDependencies(".")
+
 	return ctx
 }
 
-// runPromptCommand handles the 'prompt' subcommand
-func runPromptCommand() {
-	// Check if Claude Code is available
-	claudePath, err := exec.LookPath("claude")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: Claude Code CLI not found in PATH\n")
-		fmt.Fprintf(os.Stderr, "Install Claude Code from: https://claude.ai/code\n")
-		os.Exit(1)
-	}
-
-	// Gather project context
-	ctx := gatherProjectContext()
-
-	// Construct the prompt for Claude
-	var promptBuf strings.Builder
-	promptBuf.WriteString("Generate C4 architecture diagrams for this project.\n\n")
-	promptBuf.WriteString("IMPORTANT: Save all generated .puml files to: docs/c4/\n\n")
-	promptBuf.WriteString(c4DiagramSpec)
-	promptBuf.WriteString("\n\n---\n\n")
-	promptBuf.WriteString("PROJECT CONTEXT\n")
-	promptBuf.WriteString("===============\n\n")
-	promptBuf.WriteString(fmt.Sprintf("Project Name: %s\n", ctx.Name))
-	if ctx.ReadmeSnip != "" {
-		promptBuf.WriteString(fmt.Sprintf("\nREADME.md (first 10 lines):\n%s\n", ctx.ReadmeSnip))
-	}
-	if len(ctx.Languages) > 0 {
-		promptBuf.WriteString(fmt.Sprintf("\nPrimary file types: %s\n", strings.Join(ctx.Languages, ", ")))
-	}
-	if len(ctx.MainFiles) > 0 {
-		promptBuf.WriteString(fmt.Sprintf("Key files/directories: %s\n", strings.Join(ctx.MainFiles, ", ")))
+// readmeSnippet returns the README title followed by the first paragraph of prose,
+// skipping badges, images and HTML chrome. If no prose is found it falls back to the
+// first maxLines lines. The result never exceeds maxLines lines.
+func readmeSnippet(content string, maxLines int) string {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+
+	var title string
+	var paragraph []string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			if len(paragraph) > 0 {
+				break // End of the first prose paragraph
+			}
+			continue
+		}
+		if isReadmeChrome(trimmed) {
+			if len(paragraph) > 0 {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") {
+			if len(paragraph) > 0 {
+				break
+			}
+			if title == "" {
+				title = trimmed
+			}
+			continue
+		}
+		paragraph = append(paragraph, line)
 	}
-	promptBuf.WriteString("\n---\n\n")
-	promptBuf.WriteString("Please analyze this project and generate appropriate C4 diagrams following the spec above.\n")
-	promptBuf.WriteString("Generate files: 01-context.puml, 02-container.puml, 03-component.puml, and optionally 04-code.puml\n")
-	promptBuf.WriteString("All files should be saved to: docs/c4/\n")
-
-	// Invoke claude with --print flag and streaming for real-time feedback
-	// This avoids the raw mode TTY issue and provides streaming output
-	cmd := exec.Command(claudePath, "--print",
-		"--input-format", "text",
-		"--output-format", "stream-json",
-		"--include-partial-messages",
-		"--verbose")
-	cmd.Stdin = strings.NewReader(promptBuf.String())
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	_ = cmd.Run()
 
-	// After Claude session ends, try to generate the stacked SVG
-	fmt.Fprintf(os.Stderr, "\n")
-	fmt.Fprintf(os.Stderr, "Generating stacked SVG from ./docs/c4/...\n")
-
-	docsC4Dir := "./docs/c4/"
-	if _, err := os.Stat(docsC4Dir); err == nil {
-		// Directory exists, try to generate stacked SVG
-		outputPath := filepath.Join(docsC4Dir, "stacked-c4-architecture.svg")
-		stacker := NewSVGStacker(docsC4Dir, outputPath, fmt.Sprintf("🏗️ %s Architecture", ctx.Name))
-		if err := stacker.CreateStackedSVG(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Could not auto-generate stacked SVG: %v\n", err)
-			fmt.Fprintf(os.Stderr, "\nTo generate manually, run:\n")
-			fmt.Fprintf(os.Stderr, "  ./svg-stacker ./docs/c4/ --output ./docs/c4/stacked-c4-architecture.svg\n")
-		} else {
-			fmt.Fprintf(os.Stderr, "\n✓ Generated: %s\n", outputPath)
-			fmt.Fprintf(os.Stderr, "\nTo regenerate the stacked SVG in the future, run:\n")
-			fmt.Fprintf(os.Stderr, "  ./svg-stacker ./docs/c4/ --output ./docs/c4/stacked-c4-architecture.svg\n")
+	var snippet []string
+	if len(paragraph) > 0 {
+		if title != "" {
+			snippet = append(snippet, title, "")
 		}
+		snippet = append(snippet, paragraph...)
 	} else {
-		fmt.Fprintf(os.Stderr, "To generate stacked SVG after creating .puml files, run:\n")
-		fmt.Fprintf(os.Stderr, "  ./svg-stacker ./docs/c4/ --output ./docs/c4/stacked-c4-architecture.svg\n")
-	}
-}
-
-func parseArgs() (inputDir, outputFile, title string, shouldExit bool, exitCode int) {
-	if len(os.Args) < 2 {
-		printUsage()
-		return "", "", "", true, 1
-	}
-
-	inputDir, outputFile, title, err := parseArgsSlice(os.Args[1:])
-	if err == nil {
-		return inputDir, outputFile, title, false, 0
+		snippet = lines
 	}
 
-	// Handle special cases
-	switch err.Error() {
-	case "prompt":
-		runPromptCommand()
-		return "", "", "", true, 0
-	case "help":
-		printUsage()
-		return "", "", "", true, 0
-	case "version":
-		printVersion()
-		return "", "", "", true, 0
-	default:
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		fmt.Fprintf(os.Stderr, "Use 'svg-stacker --help' for usage information\n")
-		return "", "", "", true, 1
+	if len(snippet) > maxLines {
+		snippet = snippet[:maxLines]
 	}
+	return strings.TrimSpace(strings.Join(snippet, "\n"))
 }
 
-func main() {
-	inputDir, outputFile, title, shouldExit, exitCode := parseArgs()
-	if shouldExit {
-		os.Exit(exitCode)
-	}
-
-	stacker := NewSVGStacker(inputDir, outputFile, title)
-	if err := stacker.CreateStackedSVG(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+// isReadmeChrome reports whether a README line is a badge, image or HTML wrapper
+func isReadmeChrome(line string) bool {
+	for _, prefix := range []string{"[![", "![", "<"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
 	}
+	return false
 }
 
-func NewSVGStacker(inputDir, outputFile, title string) *SVGStacker {
-	if title == "" {
-		title = "🏗️ Stacked C4 Architecture"
-	}
-	return &SVGStacker{
-		diagrams:   make(map[string]DiagramInfo),
-		inputDir:   inputDir,
-		outputFile: outputFile,
-		title:      title,
-	}
-}
+// maxDependenciesPerManifest caps how many dependencies each manifest contributes
+const maxDependenciesPerManifest = 10
 
-func (s *SVGStacker) CreateStackedSVG() error {
-	// Check if input directory contains .puml files
-	hasPuml, err := s.hasPumlFiles()
-	if err != nil {
-		return err
+// detectDependencies reads go.mod, package.json and requirements.txt in root
+// and returns the Go module path (if any) and a short list of dependencies.
+func detectDependencies(root string) (module string, deps []string) {
+	if content, err := os.ReadFile(filepath.Join(root, "go.mod")); err == nil {
+		var goDeps []string
+		module, goDeps = parseGoMod(string(content))
+		deps = append(deps, limitStrings(goDeps, maxDependenciesPerManifest)...)
 	}
 
-	if hasPuml {
-		// Generate SVG files from PlantUML
-		if err := s.generateSVGsFromPuml(); err != nil {
-			return err
+	if content, err := os.ReadFile(filepath.Join(root, "package.json")); err == nil {
+		var pkg struct {
+			Dependencies map[string]string `json:"dependencies"`
 		}
-		// Clean up temp directory on exit
-		defer func() {
-			if s.tempDir != "" {
-				os.RemoveAll(s.tempDir)
+		if err := json.Unmarshal(content, &pkg); err == nil {
+			var names []string
+			for name := range pkg.Dependencies {
+				names = append(names, name)
 			}
-		}()
+			sort.Strings(names)
+			deps = append(deps, limitStrings(names, maxDependenciesPerManifest)...)
+		}
 	}
 
-	// Load all SVG files
-	if err := s.loadDiagrams(); err != nil {
-		return err
+	if content, err := os.ReadFile(filepath.Join(root, "requirements.txt")); err == nil {
+		deps = append(deps, limitStrings(parseRequirements(string(content)), maxDependenciesPerManifest)...)
 	}
 
-	// Create the master SVG
-	stackedSVG := s.buildStackedSVG()
+	return module, deps
+}
 
-	// Write to stdout or file
-	if s.outputFile == "" {
-		fmt.Print(stackedSVG)
-	} else {
-		if err := os.WriteFile(s.outputFile, []byte(stackedSVG), 0644); err != nil {
-			return err
+// parseGoMod extracts the module path and direct (non-indirect) requirements
+func parseGoMod(content string) (module string, requires []string) {
+	inRequireBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "module "):
+			module = strings.TrimSpace(strings.TrimPrefix(line, "module "))
+		case line == "require (":
+			inRequireBlock = true
+		case inRequireBlock && line == ")":
+			inRequireBlock = false
+		case inRequireBlock || strings.HasPrefix(line, "require "):
+			if strings.Contains(line, "// indirect") {
+				continue
+			}
+			fields := strings.Fields(strings.TrimPrefix(line, "require "))
+			if len(fields) > 0 && !strings.HasPrefix(fields[0], "//") {
+				requires = append(requires, fields[0])
+			}
 		}
 	}
-
-	return nil
+	return module, requires
 }
 
-func (s *SVGStacker) hasPumlFiles() (bool, error) {
-	files, err := filepath.Glob(filepath.Join(s.inputDir, "*.puml"))
-	if err != nil {
-		return false, err
+// parseRequirements extracts package names from a pip requirements file
+func parseRequirements(content string) []string {
+	var names []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "-") {
+			continue
+		}
+		// Strip version specifiers, extras and environment markers
+		if i := strings.IndexAny(line, "=<>!~[;@ "); i >= 0 {
+			line = line[:i]
+		}
+		if line != "" {
+			names = append(names, line)
+		}
 	}
-	return len(files) > 0, nil
+	return names
 }
 
-func (s *SVGStacker) generateSVGsFromPuml() error {
-	// Find all .puml files numbered 01-04
-	pumlFiles, err := s.findNumberedPumlFiles()
-	if err != nil {
-		return err
-	}
-
-	if len(pumlFiles) < 3 {
-		return fmt.Errorf("expected at least 3 numbered .puml files (01-*.puml through 03-*.puml), found %d", len(pumlFiles))
+func limitStrings(items []string, n int) []string {
+	if len(items) > n {
+		return items[:n]
 	}
+	return items
+}
 
-	// Create temp directory
-	tempDir, err := os.MkdirTemp("", "svg-stacker-*")
-	if err != nil {
-		return err
-	}
-	s.tempDir = tempDir
-
-	// Run plantuml to generate SVG files
-	plantumlPath, err := exec.LookPath("plantuml")
-	if err != nil {
-		return fmt.Errorf("plantuml not found in PATH: %w", err)
-	}
-
-	args := []string{"-tsvg", "-o", tempDir, "-nbthread", "auto"}
-	args = append(args, pumlFiles...)
-
-	cmd := exec.Command(plantumlPath, args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "PlantUML output: %s\n", string(output))
-		return fmt.Errorf("plantuml failed: %w", err)
-	}
+// ignoredDirs are skipped when walking a project for language detection
+var ignoredDirs = map[string]bool{
+	"node_modules": true,
+	"vendor":       true,
+	"dist":         true,
+	"build":        true,
+	"target":       true,
+	"__pycache__":  true,
+}
 
-	// Update inputDir to point to temp directory
-	s.inputDir = tempDir
-	return nil
+// languageNames maps file extensions to human-readable language names
+var languageNames = map[string]string{
+	".go":    "Go",
+	".ts":    "TypeScript",
+	".tsx":   "TypeScript",
+	".js":    "JavaScript",
+	".jsx":   "JavaScript",
+	".mjs":   "JavaScript",
+	".py":    "Python",
+	".rb":    "Ruby",
+	".java":  "Java",
+	".kt":    "Kotlin",
+	".scala": "Scala",
+	".rs":    "Rust",
+	".c":     "C",
+	".h":     "C",
+	".cpp":   "C++",
+	".cc":    "C++",
+	".hpp":   "C++",
+	".cs":    "C#",
+	".php":   "PHP",
+	".swift": "Swift",
+	".ex":    "Elixir",
+	".exs":   "Elixir",
+	".clj":   "Clojure",
+	".sh":    "Shell",
+	".sql":   "SQL",
+	".html":  "HTML",
+	".css":   "CSS",
+	".scss":  "SCSS",
+	".md":    "Markdown",
+	".yml":   "YAML",
+	".yaml":  "YAML",
+	".json":  "JSON",
+	".tf":    "Terraform",
+	".puml":  "PlantUML",
 }
 
-func (s *SVGStacker) findNumberedPumlFiles() ([]string, error) {
-	files, err := filepath.Glob(filepath.Join(s.inputDir, "*.puml"))
-	if err != nil {
-		return nil, err
+// languageName returns the language for a file extension, or the extension itself if unknown
+func languageName(ext string) string {
+	if name, ok := languageNames[strings.ToLower(ext)]; ok {
+		return name
 	}
+	return ext
+}
 
-	// Filter and sort by number prefix (01-04, with 04 being optional)
-	var numbered []string
-	numberRegex := regexp.MustCompile(`^0[1-4]-.*\.puml$`)
+// detectLanguages walks root counting files per language and returns the top three.
+// Hidden files and directories (including .git) and ignoredDirs are skipped.
+func detectLanguages(root string) []string {
+	langCount := make(map[string]int)
+	filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip unreadable entries
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			if path != root && (strings.HasPrefix(name, ".") || ignoredDirs[name]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(name, ".") {
+			if ext := filepath.Ext(name); ext != "" {
+				langCount[languageName(ext)]++
+			}
+		}
+		return nil
+	})
 
-	for _, file := range files {
-		base := filepath.Base(file)
-		if numberRegex.MatchString(base) {
-			numbered = append(numbered, file)
+	// Get top languages
+	type langFreq struct {
+		name  string
+		count int
+	}
+	var langs []langFreq
+	for name, count := range langCount {
+		langs = append(langs, langFreq{name, count})
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		if langs[i].count != langs[j].count {
+			return langs[i].count > langs[j].count
+		}
+		return langs[i].name < langs[j].name
+	})
+
+	var top []string
+	for i := 0; i < len(langs) && i < 3; i++ {
+		top = append(top, langs[i].name)
+	}
+	return top
+}
+
+// PromptOptions holds the flags accepted by the 'prompt' subcommand
+type PromptOptions struct {
+	Timeout     time.Duration // maximum duration of the assistant session
+	PrintOnly   bool          // write the prompt to stdout instead of invoking the assistant
+	C4Dir       string        // where the generated .puml files are saved
+	ReadmeLines int           // maximum README lines included in the prompt
+	PromptOut   string        // also save the assembled prompt to this file
+	LLM         LLMCLI        // assistant CLI that receives the prompt
+	Spec        string        // diagram specification included in the prompt
+	LogLevel    LogLevel      // from --quiet or --verbose; LogNormal if neither
+}
+
+const (
+	defaultC4Dir       = "docs/c4/"
+	defaultReadmeLines = 10
+)
+
+func parsePromptArgs(args []string) (opts PromptOptions, err error) {
+	opts.Timeout = defaultLLMTimeout
+	opts.C4Dir = defaultC4Dir
+	opts.ReadmeLines = defaultReadmeLines
+	opts.Spec = c4DiagramSpec
+	var llmName, llmCmd string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--timeout":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return PromptOptions{}, err
+			}
+			if opts.Timeout, err = parseTimeout(v); err != nil {
+				return PromptOptions{}, fmt.Errorf("--timeout: %w", err)
+			}
+		case "--print-only":
+			opts.PrintOnly = true
+		case "-q", "--quiet", "--verbose":
+			opts.LogLevel, _ = logLevelFlag(args[i])
+		case "--c4-dir":
+			if opts.C4Dir, err = flagValue(args, &i); err != nil {
+				return PromptOptions{}, err
+			}
+		case "--readme-lines":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return PromptOptions{}, err
+			}
+			if opts.ReadmeLines, err = strconv.Atoi(v); err != nil || opts.ReadmeLines < 1 {
+				return PromptOptions{}, fmt.Errorf("--readme-lines must be a positive integer, got %q", v)
+			}
+		case "--prompt-out":
+			if opts.PromptOut, err = flagValue(args, &i); err != nil {
+				return PromptOptions{}, err
+			}
+		case "--llm":
+			if llmName, err = flagValue(args, &i); err != nil {
+				return PromptOptions{}, err
+			}
+		case "--llm-cmd":
+			if llmCmd, err = flagValue(args, &i); err != nil {
+				return PromptOptions{}, err
+			}
+		case "--spec":
+			v, err := flagValue(args, &i)
+			if err != nil {
+				return PromptOptions{}, err
+			}
+			if opts.Spec, err = loadSpec(v); err != nil {
+				return PromptOptions{}, fmt.Errorf("--spec: %w", err)
+			}
+		default:
+			return PromptOptions{}, fmt.Errorf("unknown prompt flag: %s", args[i])
+		}
+	}
+
+	if opts.LLM, err = selectLLM(llmName, llmCmd); err != nil {
+		return PromptOptions{}, err
+	}
+
+	return opts, nil
+}
+
+// Environment variables that supply defaults for --title and --output
+const (
+	envTitle  = "SVG_STACKER_TITLE"
+	envOutput = "SVG_STACKER_OUTPUT"
+)
+
+// outputFlagged reports whether --output or --output-dir was given, as opposed to
+// the output being taken from SVG_STACKER_OUTPUT
+func outputFlagged(opts Options) bool {
+	return opts.OutputDir != "" || (opts.OutputFile != "" && !opts.outputFromEnv)
+}
+
+// readTitleFile returns the first non-empty line of file, for --title-file
+func readTitleFile(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("--title-file: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimPrefix(string(data), "\ufeff"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("--title-file: %s is empty", file)
+}
+
+// expandResponseFiles replaces each "@file" argument with the whitespace-separated
+// arguments read from that file. Response files are not expanded recursively.
+func expandResponseFiles(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		if len(arg) < 2 || arg[0] != '@' {
+			expanded = append(expanded, arg)
+			continue
+		}
+		data, err := os.ReadFile(arg[1:])
+		if err != nil {
+			return nil, fmt.Errorf("reading response file: %w", err)
+		}
+		expanded = append(expanded, strings.Fields(string(data))...)
+	}
+	return expanded, nil
+}
+
+// flagValue consumes the argument following the flag at args[*i]
+func flagValue(args []string, i *int) (string, error) {
+	if *i+1 < len(args) {
+		*i++
+		return args[*i], nil
+	}
+	return "", fmt.Errorf("%s requires an argument", args[*i])
+}
+
+// parseTimeout parses a positive Go duration such as "90s" or "5m"
+func parseTimeout(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("timeout must be positive, got %s", value)
+	}
+	return d, nil
+}
+
+// parseAspectRange parses a "MIN:MAX" width/height ratio range, such as "0.2:5"
+func parseAspectRange(value string) ([2]float64, error) {
+	minValue, maxValue, ok := strings.Cut(value, ":")
+	if !ok {
+		return [2]float64{}, fmt.Errorf("expected MIN:MAX, got %q", value)
+	}
+	minRatio, errMin := strconv.ParseFloat(minValue, 64)
+	maxRatio, errMax := strconv.ParseFloat(maxValue, 64)
+	if errMin != nil || errMax != nil || minRatio <= 0 || maxRatio < minRatio {
+		return [2]float64{}, fmt.Errorf("expected positive MIN:MAX with MIN <= MAX, got %q", value)
+	}
+	return [2]float64{minRatio, maxRatio}, nil
+}
+
+// parseSize parses a positive byte count with an optional KB, MB or GB suffix
+// (powers of 1024), such as "500KB" or "100MB"
+func parseSize(value string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("size must be a positive number of bytes, KB, MB or GB, got %q", value)
+	}
+	return n * multiplier, nil
+}
+
+// runPromptCommand handles the 'prompt' subcommand
+func runPromptCommand(opts PromptOptions) {
+	// Gather project context
+	ctx := gatherProjectContext(opts.ReadmeLines)
+	ctx.ExistingDiagrams = existingDiagrams(opts.C4Dir)
+	if len(ctx.ExistingDiagrams) > 0 {
+		logger.Infof("Including %d existing diagrams from %s to update\n", len(ctx.ExistingDiagrams), opts.C4Dir)
+	}
+	prompt := buildPrompt(ctx, opts.C4Dir, opts.Spec)
+
+	if opts.PromptOut != "" {
+		if err := os.WriteFile(opts.PromptOut, []byte(prompt), 0644); err != nil {
+			logger.Errorf("Error: could not write prompt: %v\n", err)
+			os.Exit(exitFailure)
+		}
+		logger.Infof("Prompt written to %s\n", opts.PromptOut)
+	}
+
+	if opts.PrintOnly {
+		fmt.Print(prompt)
+		return
+	}
+
+	// Check if the assistant CLI is available; the prompt is still useful without it
+	binary, args := opts.LLM.Command()
+	binaryPath, err := exec.LookPath(binary)
+	if err != nil {
+		logger.Warnf("%s not found in PATH, printing the prompt instead\n", opts.LLM.Name())
+		logger.Warnf("%s\n\n", opts.LLM.InstallHint())
+		fmt.Print(prompt)
+		return
+	}
+
+	// Invoke the assistant with the prompt on stdin
+	runCtx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, binaryPath, args...)
+	cmd.Stdin = strings.NewReader(prompt)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil && runCtx.Err() == context.DeadlineExceeded {
+		logger.Warnf("\nWarning: %s session timed out after %s\n", opts.LLM.Name(), opts.Timeout)
+	}
+
+	// After the assistant session ends, try to generate the stacked SVG
+	logger.Infof("\nGenerating stacked SVG from %s...\n", opts.C4Dir)
+
+	outputPath := filepath.Join(opts.C4Dir, defaultOutputName)
+	regenerateCmd := fmt.Sprintf("  ./svg-stacker %s --output %s\n", opts.C4Dir, outputPath)
+	if _, err := os.Stat(opts.C4Dir); err == nil {
+		// Directory exists, check the expected files were written before rendering
+		if problems := checkGeneratedPuml(opts.C4Dir); len(problems) > 0 {
+			logger.Warnf("Warning: Could not auto-generate stacked SVG:\n")
+			for _, problem := range problems {
+				logger.Warnf("  - %s\n", problem)
+			}
+			logger.Warnf("\nOnce the files are in place, run:\n%s", regenerateCmd)
+			return
+		}
+
+		stacker := NewSVGStacker(opts.C4Dir, outputPath, fmt.Sprintf("🏗️ %s Architecture", ctx.Name))
+		if err := stacker.CreateStackedSVG(); err != nil {
+			logger.Warnf("Warning: Could not auto-generate stacked SVG: %v\n", err)
+			logger.Warnf("\nTo generate manually, run:\n%s", regenerateCmd)
+		} else {
+			logger.Infof("\n✓ Generated: %s\n", outputPath)
+			logger.Infof("\nTo regenerate the stacked SVG in the future, run:\n%s", regenerateCmd)
+		}
+	} else {
+		logger.Infof("To generate stacked SVG after creating .puml files, run:\n%s", regenerateCmd)
+	}
+}
+
+// expectedPumlFiles are the numbered files the prompt asks for; 04 is optional
+var expectedPumlFiles = []struct {
+	prefix   string
+	name     string
+	required bool
+}{
+	{"01-", "01-context.puml", true},
+	{"02-", "02-container.puml", true},
+	{"03-", "03-component.puml", true},
+	{"04-", "04-code.puml", false},
+}
+
+// checkGeneratedPuml reports required numbered .puml files in dir that are missing,
+// and any numbered files that exist but are empty
+func checkGeneratedPuml(dir string) []string {
+	var problems []string
+	for _, expected := range expectedPumlFiles {
+		matches, _ := filepath.Glob(filepath.Join(dir, expected.prefix+"*.puml"))
+		if len(matches) == 0 {
+			if expected.required {
+				problems = append(problems, fmt.Sprintf("%s was not generated", expected.name))
+			}
+			continue
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Size() == 0 {
+				problems = append(problems, fmt.Sprintf("%s is empty", filepath.Base(match)))
+			}
+		}
+	}
+	return problems
+}
+
+// specNames lists the built-in specifications --spec accepts
+func specNames() []string {
+	names := []string{"c4"}
+	entries, _ := builtinSpecs.ReadDir("specs")
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".md"))
+	}
+	return names
+}
+
+// loadSpec returns the built-in specification called value ("c4" is the default
+// C4-DIAGRAM-SPEC.md), or else the contents of the file value names
+func loadSpec(value string) (string, error) {
+	if value == "c4" {
+		return c4DiagramSpec, nil
+	}
+	if spec, err := builtinSpecs.ReadFile("specs/" + value + ".md"); err == nil {
+		return string(spec), nil
+	}
+
+	spec, err := os.ReadFile(value)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%q is neither a built-in spec (%s) nor a file", value, strings.Join(specNames(), ", "))
+		}
+		return "", err
+	}
+	if strings.TrimSpace(string(spec)) == "" {
+		return "", fmt.Errorf("%s is empty", value)
+	}
+	return string(spec), nil
+}
+
+// buildPrompt assembles the full prompt: instructions, C4 spec and project context
+func buildPrompt(ctx ProjectContext, c4Dir, spec string) string {
+	var promptBuf strings.Builder
+	promptBuf.WriteString("Generate C4 architecture diagrams for this project.\n\n")
+	promptBuf.WriteString(fmt.Sprintf("IMPORTANT: Save all generated .puml files to: %s\n\n", c4Dir))
+	promptBuf.WriteString(spec)
+	promptBuf.WriteString("\n\n---\n\n")
+	promptBuf.WriteString("PROJECT CONTEXT\n")
+	promptBuf.WriteString("===============\n\n")
+	promptBuf.WriteString(fmt.Sprintf("Project Name: %s\n", ctx.Name))
+	if ctx.ReadmeSnip != "" {
+		promptBuf.WriteString(fmt.Sprintf("\nREADME.md (excerpt):\n%s\n", ctx.ReadmeSnip))
+	}
+	if len(ctx.Languages) > 0 {
+		promptBuf.WriteString(fmt.Sprintf("\nPrimary languages: %s\n", strings.Join(ctx.Languages, ", ")))
+	}
+	if len(ctx.MainFiles) > 0 {
+		promptBuf.WriteString(fmt.Sprintf("Key files/directories: %s\n", strings.Join(ctx.MainFiles, ", ")))
+	}
+	if ctx.Module != "" {
+		promptBuf.WriteString(fmt.Sprintf("Go module: %s\n", ctx.Module))
+	}
+	if len(ctx.Dependencies) > 0 {
+		promptBuf.WriteString(fmt.Sprintf("Notable dependencies: %s\n", strings.Join(ctx.Dependencies, ", ")))
+	}
+	if len(ctx.ExistingDiagrams) > 0 {
+		promptBuf.WriteString("\n---\n\n")
+		promptBuf.WriteString("EXISTING DIAGRAMS\n")
+		promptBuf.WriteString("=================\n\n")
+		promptBuf.WriteString("These diagrams were generated earlier. Update them to match the project as it is now rather than replacing them:\n")
+		promptBuf.WriteString("keep element ids, names, layout choices and notes that are still accurate, and change only what is out of date or missing.\n")
+		for _, diagram := range ctx.ExistingDiagrams {
+			promptBuf.WriteString(fmt.Sprintf("\n%s:\n```plantuml\n%s\n```\n", diagram.Name, strings.TrimRight(diagram.Content, "\n")))
+		}
+	}
+	promptBuf.WriteString("\n---\n\n")
+	if len(ctx.ExistingDiagrams) > 0 {
+		promptBuf.WriteString("Please analyze this project and update the existing diagrams above, following the spec.\n")
+	} else {
+		promptBuf.WriteString("Please analyze this project and generate appropriate C4 diagrams following the spec above.\n")
+	}
+	promptBuf.WriteString("Generate files: 01-context.puml, 02-container.puml, 03-component.puml, and optionally 04-code.puml\n")
+	promptBuf.WriteString(fmt.Sprintf("All files should be saved to: %s\n", c4Dir))
+
+	return promptBuf.String()
+}
+
+func parseArgs() (opts Options, shouldExit bool, exitCode int) {
+	return parseCommandLine(os.Args[1:])
+}
+
+// parseCommandLine parses the arguments after the program name, running any
+// subcommand they give. Response files are expanded first, so an @file can hold
+// a subcommand and its flags.
+func parseCommandLine(args []string) (opts Options, shouldExit bool, exitCode int) {
+	if len(args) == 0 {
+		printUsage()
+		return Options{}, true, exitFailure
+	}
+	args, err := expandResponseFiles(args)
+	if err != nil {
+		logger.Errorf("Error: %v\n", err)
+		return Options{}, true, exitFailure
+	}
+
+	level, args := parseGlobalFlags(args)
+	logger.SetLevel(level)
+	if len(args) == 0 {
+		printUsage()
+		return Options{}, true, exitFailure
+	}
+
+	opts, err = parseArgsSlice(args)
+	if err == nil {
+		setLogLevel(opts.LogLevel)
+		return opts, false, exitOK
+	}
+
+	// Handle special cases
+	switch err.Error() {
+	case "prompt":
+		promptOpts, err := parsePromptArgs(args[1:])
+		if err != nil {
+			logger.Errorf("Error: %v\n", err)
+			logger.Errorf("Use 'svg-stacker --help' for usage information\n")
+			return Options{}, true, exitFailure
+		}
+		setLogLevel(promptOpts.LogLevel)
+		runPromptCommand(promptOpts)
+		return Options{}, true, exitOK
+	case "serve":
+		serveOpts, err := parseServeArgs(args[1:])
+		if err != nil {
+			logger.Errorf("Error: %v\n", err)
+			logger.Errorf("Use 'svg-stacker --help' for usage information\n")
+			return Options{}, true, exitFailure
+		}
+		setLogLevel(serveOpts.Stacker.LogLevel)
+		if err := runServeCommand(serveOpts); err != nil {
+			logger.Errorf("Error: %v\n", err)
+			return Options{}, true, exitFailure
+		}
+		return Options{}, true, exitOK
+	case "diff":
+		diffOpts, err := parseDiffArgs(args[1:])
+		if err != nil {
+			logger.Errorf("Error: %v\n", err)
+			logger.Errorf("Use 'svg-stacker --help' for usage information\n")
+			return Options{}, true, exitFailure
+		}
+		setLogLevel(diffOpts.Stacker.LogLevel)
+		differs, err := runDiffCommand(diffOpts, os.Stdout)
+		if err != nil {
+			logger.Errorf("Error: %v\n", err)
+			return Options{}, true, exitCodeFor(err)
+		}
+		if differs {
+			return Options{}, true, exitDiffers
+		}
+		return Options{}, true, exitOK
+	case "index":
+		indexOpts, err := parseIndexArgs(args[1:])
+		if err != nil {
+			logger.Errorf("Error: %v\n", err)
+			logger.Errorf("Use 'svg-stacker --help' for usage information\n")
+			return Options{}, true, exitFailure
+		}
+		setLogLevel(indexOpts.LogLevel)
+		if err := runIndexCommand(indexOpts); err != nil {
+			logger.Errorf("Error: %v\n", err)
+			return Options{}, true, exitCodeFor(err)
+		}
+		return Options{}, true, exitOK
+	case "lint":
+		lintOpts, err := parseLintArgs(args[1:])
+		if err != nil {
+			logger.Errorf("Error: %v\n", err)
+			logger.Errorf("Use 'svg-stacker --help' for usage information\n")
+			return Options{}, true, exitFailure
+		}
+		setLogLevel(lintOpts.Stacker.LogLevel)
+		code, err := runLintCommand(lintOpts, os.Stdout)
+		if err != nil {
+			logger.Errorf("Error: %v\n", err)
+			return Options{}, true, exitCodeFor(err)
+		}
+		return Options{}, true, code
+	case "levels":
+		asJSON, err := parseLevelsArgs(args[1:])
+		if err != nil {
+			logger.Errorf("Error: %v\n", err)
+			logger.Errorf("Use 'svg-stacker --help' for usage information\n")
+			return Options{}, true, exitFailure
+		}
+		if err := printLevels(os.Stdout, asJSON); err != nil {
+			logger.Errorf("Error: %v\n", err)
+			return Options{}, true, exitFailure
+		}
+		return Options{}, true, exitOK
+	case "help":
+		printUsage()
+		return Options{}, true, exitOK
+	case "version":
+		printVersion()
+		return Options{}, true, exitOK
+	default:
+		logger.Errorf("Error: %v\n", err)
+		logger.Errorf("Use 'svg-stacker --help' for usage information\n")
+		return Options{}, true, exitFailure
+	}
+}
+
+func main() {
+	opts, shouldExit, exitCode := parseArgs()
+	if shouldExit {
+		os.Exit(exitCode)
+	}
+
+	stacker := NewSVGStackerWithOptions(opts)
+	if err := stacker.CreateStackedSVG(); err != nil {
+		logger.Errorf("Error: %v\n", err)
+		os.Exit(exitCodeFor(err))
+	}
+
+	if opts.Open && !opts.Check {
+		if stacker.outputFile == "" || stacker.outputFile == "-" {
+			logger.Warnf("Warning: --open ignored, the output went to stdout\n")
+		} else if err := openInBrowser(stacker.outputFile); err != nil {
+			logger.Warnf("Warning: %v\n", err)
+		}
+	}
+}
+
+func NewSVGStacker(inputDir, outputFile, title string) *SVGStacker {
+	return NewSVGStackerWithOptions(Options{
+		InputDir:   inputDir,
+		OutputFile: outputFile,
+		Title:      title,
+	})
+}
+
+// NewSVGStackerWithOptions creates a stacker configured from the full set of options.
+func NewSVGStackerWithOptions(opts Options) *SVGStacker {
+	title := opts.Title
+	if title == "" {
+		title = "🏗️ Stacked C4 Architecture"
+	}
+	outputFile := opts.OutputFile
+	if opts.OutputDir != "" {
+		outputFile = filepath.Join(opts.OutputDir, outputNameForTitle(title))
+	}
+	return &SVGStacker{
+		diagrams:   make(map[string]DiagramInfo),
+		inputDir:   opts.InputDir,
+		outputFile: outputFile,
+		title:      title,
+		script:     navigationJS,
+		opts:       opts,
+	}
+}
+
+func (s *SVGStacker) CreateStackedSVG() error {
+	return s.CreateStackedSVGContext(context.Background())
+}
+
+// CreateStackedSVGContext is CreateStackedSVG with cancellation: ctx aborts
+// PlantUML rendering and stops loading between files
+func (s *SVGStacker) CreateStackedSVGContext(ctx context.Context) error {
+	start := time.Now()
+	stackedSVG, err := s.GenerateContext(ctx)
+	if err != nil {
+		return err
+	}
+	if s.opts.Stats {
+		s.printStats(os.Stderr, stackedSVG, time.Since(start))
+	}
+	if s.opts.Check {
+		return s.checkOutput(stackedSVG)
+	}
+	if s.opts.Poster != "" {
+		if err := s.writePoster(ctx, s.opts.Poster); err != nil {
+			return err
+		}
+	}
+	if s.opts.PDF != "" {
+		if err := s.writePDF(ctx, s.opts.PDF); err != nil {
+			return err
+		}
+	}
+
+	// Write to stdout or file
+	if s.outputFile == "" || s.outputFile == "-" {
+		fmt.Print(stackedSVG)
+	} else {
+		// Create the --output-dir, or the parent directories of --output, on first use
+		if err := os.MkdirAll(filepath.Dir(s.outputFile), 0755); err != nil {
+			return err
+		}
+		if s.opts.IfChanged {
+			hash := contentHash(stackedSVG)
+			logger.Infof("Content hash: %s\n", hash)
+			if existing, err := os.ReadFile(s.outputFile); err == nil && contentHash(string(existing)) == hash {
+				logger.Infof("%s unchanged\n", s.outputFile)
+				return nil
+			}
+		}
+		if err := os.WriteFile(s.outputFile, []byte(stackedSVG), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// printStats writes the --stats summary of a generation that took elapsed in
+// total and produced svg
+func (s *SVGStacker) printStats(w io.Writer, svg string, elapsed time.Duration) {
+	var levels int
+	var renderTime time.Duration
+	var largest, largestLevel string
+	for _, stack := range s.stacks {
+		levels += len(stack.diagrams)
+		renderTime += stack.renderTime
+		for _, level := range stack.levelOrder() {
+			if content := stack.diagrams[level].content; len(content) > len(largest) {
+				largest, largestLevel = content, level
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "Levels:    %d\n", levels)
+	fmt.Fprintf(w, "Output:    %d bytes\n", len(svg))
+	if largestLevel != "" {
+		fmt.Fprintf(w, "Largest:   %s (%d bytes)\n", largestLevel, len(largest))
+	}
+	fmt.Fprintf(w, "Rendering: %s\n", renderTime.Round(time.Millisecond))
+	fmt.Fprintf(w, "Stacking:  %s\n", (elapsed - renderTime).Round(time.Millisecond))
+}
+
+// timestampRegex matches the <timestamp> element of a stacked SVG, capturing
+// its start tag
+var timestampRegex = regexp.MustCompile(`(<timestamp[^>]*>)[^<]*</timestamp>`)
+
+// contentHash is the SHA-256 of a stacked SVG, ignoring the generation timestamp
+// so that regenerating unchanged diagrams gives the same hash
+func contentHash(svg string) string {
+	sum := sha256.Sum256([]byte(timestampRegex.ReplaceAllString(svg, "$1</timestamp>")))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Generate renders the input into a stacked SVG without writing it anywhere.
+// A stacker is single-use: create a new one for each generation.
+func (s *SVGStacker) Generate() (string, error) {
+	return s.GenerateContext(context.Background())
+}
+
+// GenerateContext is Generate with cancellation, as for CreateStackedSVGContext
+func (s *SVGStacker) GenerateContext(ctx context.Context) (string, error) {
+	if len(s.opts.MoreInputDirs) == 0 {
+		if err := s.loadInput(ctx); err != nil {
+			return "", err
+		}
+		s.stacks = []*SVGStacker{s}
+	} else {
+		ids := make(map[string]bool)
+		for _, dir := range append([]string{s.inputDir}, s.opts.MoreInputDirs...) {
+			stack := &SVGStacker{diagrams: make(map[string]DiagramInfo), inputDir: dir, opts: s.opts}
+			if err := stack.loadInput(ctx); err != nil {
+				return "", fmt.Errorf("%s: %w", dir, err)
+			}
+			stack.label = stackLabel(dir)
+			id := levelID(stack.label)
+			for n := 2; ids[id]; n++ {
+				id = fmt.Sprintf("%s-%d", levelID(stack.label), n)
+			}
+			ids[id] = true
+			stack.idPrefix = id + "-"
+			s.stacks = append(s.stacks, stack)
+		}
+	}
+
+	// Inline any fonts to embed
+	var err error
+	if s.fontFaces, err = fontFaceCSS(s.opts.EmbedFonts); err != nil {
+		return "", err
+	}
+	if s.opts.BackgroundImage != "" {
+		if s.background, err = backgroundImage(s.opts.BackgroundImage, s.backgroundOpacity()); err != nil {
+			return "", err
+		}
+	}
+	if s.opts.LinkLegendFile != "" {
+		if s.linkMeanings, err = readLinkLegendFile(s.opts.LinkLegendFile); err != nil {
+			return "", err
+		}
+	}
+	if s.opts.JSFile != "" {
+		if s.script, err = customScript(s.opts.JSFile); err != nil {
+			return "", err
+		}
+	}
+	if s.extraCSS, err = customCSS(s.opts.CSSFiles, s.opts.NoCDATA); err != nil {
+		return "", err
+	}
+
+	// Hoisting re-encodes the diagrams, which --no-clean promises not to do
+	if !s.opts.NoClean {
+		s.hoistInlineImages()
+	}
+
+	// Create the master SVG
+	if s.opts.Mobile {
+		return s.buildMobileSVG(), nil
+	}
+	return s.buildStackedSVG(), nil
+}
+
+// loadInput loads the diagrams of s.inputDir, rendering .puml files and unpacking
+// archives as needed; temporary files are removed before it returns
+func (s *SVGStacker) loadInput(ctx context.Context) error {
+	// Read diagrams straight from a .zip or .tar.gz bundle by unpacking it first
+	if isArchive(s.inputDir) {
+		archiveDir, err := extractArchive(s.inputDir, s.opts.TempDir, s.maxFileSize())
+		if err != nil {
+			return err
+		}
+		defer s.removeTemp(archiveDir, "extracted files")
+		s.inputDir = archiveDir
+	}
+
+	ignored, err := readIgnoreFile(s.inputDir)
+	if err != nil {
+		return err
+	}
+	s.ignored = ignored
+
+	// Render .puml and other registered source formats to SVG. Clean up the temp
+	// directory on exit, including when rendering fails
+	defer func() {
+		if s.tempDir != "" {
+			s.removeTemp(s.tempDir, "rendered SVGs")
+			s.tempDir = ""
+		}
+	}()
+	renderStart := time.Now()
+	if err := s.renderSources(ctx); err != nil {
+		return err
+	}
+	s.renderTime = time.Since(renderStart)
+
+	// Load all SVG files
+	return s.loadDiagrams(ctx)
+}
+
+// stackLabel names a stack's tab after its input directory or archive
+func stackLabel(input string) string {
+	name := filepath.Base(filepath.Clean(input))
+	for _, ext := range []string{".zip", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// allStacks returns the stacks in the document, which is just s itself unless
+// several input directories were loaded
+func (s *SVGStacker) allStacks() []*SVGStacker {
+	if len(s.stacks) == 0 {
+		return []*SVGStacker{s}
+	}
+	return s.stacks
+}
+
+// anyStackHas reports whether any stack in the document has a diagram for level
+func (s *SVGStacker) anyStackHas(level string) bool {
+	for _, stack := range s.allStacks() {
+		if _, exists := stack.diagrams[level]; exists {
+			return true
 		}
 	}
+	return false
+}
 
-	sort.Strings(numbered)
-	return numbered, nil
+// levelKey is the id of a level within the document: its levelID, prefixed by
+// the stack's id when there are several stacks
+func (s *SVGStacker) levelKey(level string) string {
+	return s.idPrefix + levelID(level)
 }
 
-func (s *SVGStacker) loadDiagrams() error {
+// outputNameForTitle derives the file name used with --output-dir by slugifying
+// the title, so the default title maps to defaultOutputName
+func outputNameForTitle(title string) string {
+	slug := slugify(title)
+	if slug == "" {
+		return defaultOutputName
+	}
+	return slug + ".svg"
+}
+
+// slugify lowercases s and collapses everything other than ASCII letters and
+// digits into single hyphens
+func slugify(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else if b.Len() > 0 && !strings.HasSuffix(b.String(), "-") {
+			b.WriteByte('-')
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// levelID returns the form of a level name used in element ids and by the
+// navigation script; the display label is derived from the level name separately
+func levelID(level string) string {
+	if id := slugify(level); id != "" {
+		return id
+	}
+	return "level"
+}
+
+func (s *SVGStacker) loadDiagrams(ctx context.Context) error {
 	// Find all SVG files in the input directory
 	files, err := filepath.Glob(filepath.Join(s.inputDir, "*.svg"))
 	if err != nil {
 		return err
 	}
 
+	maxSize := s.maxFileSize()
 	for _, file := range files {
-		content, err := os.ReadFile(file)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Rendered SVGs come from sources the globs already selected
+		if s.tempDir == "" && !s.selected(file) {
+			continue
+		}
+
+		content, stat, err := readSVGFile(file, maxSize)
 		if err != nil {
 			return err
 		}
 
 		// Validate XML before processing
 		if err := ValidateXML(string(content)); err != nil {
-			return err
+			return withExitCode(exitMalformed, fmt.Errorf("%s: %w", file, err))
 		}
 
 		level := s.extractLevel(filepath.Base(file))
 		if level == "unknown" {
+			logger.Debugf("skipping %s: no C4 level in filename\n", file)
 			continue // Skip files that don't match C4 patterns
 		}
+		logger.Debugf("assigned %s to level %s\n", file, level)
 
 		info, err := s.parseSVG(string(content), level)
 		if err != nil {
-			return err
+			return withExitCode(exitMalformed, fmt.Errorf("%s: %w", file, err))
 		}
 
+		info.file, info.modTime = file, stat.ModTime()
+		// Scaling the native size leaves the viewBox, and so the aspect ratio, as it is
+		if scale := s.scaleFor(level); scale != 1 {
+			logger.Debugf("scaling %s by %g\n", file, scale)
+			info.width, info.height = info.width*scale, info.height*scale
+		}
+		if minRatio, maxRatio := s.aspectWarnRange(); info.aspectRatio < minRatio || info.aspectRatio > maxRatio {
+			logger.Warnf("Warning: %s has an aspect ratio of %.2f, outside %g to %g; check its layout\n", file, info.aspectRatio, minRatio, maxRatio)
+		}
 		s.diagrams[level] = info
 	}
 
 	if len(s.diagrams) == 0 {
-		return fmt.Errorf("no C4 SVG files found")
+		return withExitCode(exitNoInputs, fmt.Errorf("no C4 SVG files found"))
 	}
 
+	return s.keepOnly(s.opts.Only)
+}
+
+// readSVGFile reads an input SVG of at most maxSize bytes, decompressing it if
+// it is gzipped and normalizing a byte-order mark and CRLF line endings away
+func readSVGFile(file string, maxSize int64) ([]byte, os.FileInfo, error) {
+	// Check the size first: the whole file is read into memory and re-encoded
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stat.Size() > maxSize {
+		return nil, nil, fmt.Errorf("%s is %d bytes, larger than the %d byte limit (raise it with --max-file-size)", file, stat.Size(), maxSize)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isGzip(content) {
+		logger.Debugf("%s is gzip-compressed, decompressing\n", file)
+		if content, err = gunzip(content, maxSize); err != nil {
+			return nil, nil, withExitCode(exitMalformed, fmt.Errorf("%s: %w", file, err))
+		}
+	}
+	// Some Windows tools write a UTF-8 byte-order mark; drop it before validating and parsing
+	content = bytes.TrimPrefix(content, []byte("\ufeff"))
+	// As an XML parser would, so the parts copied verbatim (CDATA, foreignObject,
+	// --no-clean content) come out the same as from a file with LF endings
+	content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	return content, stat, nil
+}
+
+// keepOnly drops the loaded diagrams whose level isn't in levels, for --only. It's
+// an error for a level in levels to have no diagram. Levels are compared by id,
+// so "Deployment View" matches "deployment-view".
+func (s *SVGStacker) keepOnly(levels []string) error {
+	if len(levels) == 0 {
+		return nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, requested := range levels {
+		found := false
+		for level := range s.diagrams {
+			if levelID(level) == levelID(requested) {
+				wanted[level], found = true, true
+			}
+		}
+		if !found {
+			return withExitCode(exitNoInputs, fmt.Errorf("--only: no diagram for level %q (found %s)", requested, strings.Join(s.levelOrder(), ", ")))
+		}
+	}
+	for level := range s.diagrams {
+		if !wanted[level] {
+			logger.Debugf("leaving out level %s: not in --only\n", level)
+			delete(s.diagrams, level)
+		}
+	}
 	return nil
 }
 
+// isGzip reports whether content starts with the gzip magic bytes, as SVGZ files
+// saved with a plain .svg extension do
+func isGzip(content []byte) bool {
+	return len(content) >= 2 && content[0] == 0x1f && content[1] == 0x8b
+}
+
+// gunzip decompresses content, refusing output larger than maxSize bytes
+func gunzip(content []byte, maxSize int64) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	data, err := io.ReadAll(io.LimitReader(gz, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("decompresses to more than the %d byte limit (raise it with --max-file-size)", maxSize)
+	}
+	return data, nil
+}
+
+// ignoreFileName lists globs of input files to skip, one per line, like
+// .gitignore. It is read from the input directory.
+const ignoreFileName = ".svg-stackerignore"
+
+// readIgnoreFile returns the globs in dir's ignore file, skipping blank lines and
+// # comments. A missing file ignores nothing.
+func readIgnoreFile(dir string) ([]string, error) {
+	path := filepath.Join(dir, ignoreFileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []string
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := filepath.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q: %v", path, n+1, line, err)
+		}
+		patterns = append(patterns, line)
+	}
+	logger.Debugf("ignoring %d patterns from %s\n", len(patterns), path)
+	return patterns, nil
+}
+
+// selected reports whether an input file passes the --include and --exclude
+// globs and the ignore file, which are matched against its base name
+func (s *SVGStacker) selected(file string) bool {
+	name := filepath.Base(file)
+	matchesAny := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(s.opts.Include) > 0 && !matchesAny(s.opts.Include) {
+		logger.Debugf("skipping %s: not matched by --include\n", file)
+		return false
+	}
+	if matchesAny(s.opts.Exclude) {
+		logger.Debugf("skipping %s: matched by --exclude\n", file)
+		return false
+	}
+	if matchesAny(s.ignored) {
+		logger.Debugf("skipping %s: matched by %s\n", file, ignoreFileName)
+		return false
+	}
+	return true
+}
+
 func (s *SVGStacker) extractLevel(filename string) string {
+	if pattern := s.opts.LevelPattern; pattern != nil {
+		m := pattern.FindStringSubmatch(filename)
+		if m == nil {
+			return "unknown"
+		}
+		capture := strings.ToLower(m[pattern.SubexpIndex("level")])
+		if level, ok := levelAliases[strings.TrimLeft(capture, "0")]; ok {
+			return level
+		}
+		if slices.Contains(c4Levels, capture) {
+			return capture
+		}
+		return "unknown"
+	}
+
 	lower := strings.ToLower(filename)
-	if strings.Contains(lower, "context") {
-		return "context"
+	for _, level := range c4Levels {
+		if strings.Contains(lower, level) {
+			return level
+		}
+	}
+	return "unknown"
+}
+
+// linkTarget returns the level a diagram link points to, from the file name or
+// fragment of its href as extractLevel would read it, or "unknown"
+func (s *SVGStacker) linkTarget(href string) string {
+	file, fragment, _ := strings.Cut(href, "#")
+	file, _, _ = strings.Cut(file, "?")
+	name := path.Base(file)
+	if file == "" {
+		name = fragment
+	}
+	if name == "" || name == "." || name == "/" {
+		return "unknown"
 	}
-	if strings.Contains(lower, "container") {
-		return "container"
+	return s.extractLevel(name)
+}
+
+// attrValue returns the value of the named attribute in a start tag, allowing
+// whitespace (including newlines) around "=" and either quote style
+func attrValue(tag, name string) (string, bool) {
+	attrRegex := regexp.MustCompile(`(?:^|\s)` + regexp.QuoteMeta(name) + `\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	m := attrRegex.FindStringSubmatch(tag)
+	if m == nil {
+		return "", false
 	}
-	if strings.Contains(lower, "component") {
-		return "component"
+	if strings.HasSuffix(m[0], "'") {
+		return m[2], true
 	}
-	if strings.Contains(lower, "code") {
-		return "code"
+	return m[1], true
+}
+
+// parseViewBox parses "min-x min-y width height", allowing the values to be
+// separated by any mix of whitespace and commas as SVG permits
+func parseViewBox(viewBox string) ([4]float64, error) {
+	var vb [4]float64
+	fields := strings.FieldsFunc(viewBox, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) != 4 {
+		return vb, fmt.Errorf("viewBox %q: expected 4 values, got %d", viewBox, len(fields))
 	}
-	return "unknown"
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return vb, fmt.Errorf("viewBox %q: invalid number %q", viewBox, field)
+		}
+		vb[i] = v
+	}
+	if vb[2] <= 0 || vb[3] <= 0 {
+		return vb, fmt.Errorf("viewBox %q: width and height must be positive", viewBox)
+	}
+	return vb, nil
+}
+
+// formatViewBox renders a parsed viewBox in the canonical space-separated form
+func formatViewBox(vb [4]float64) string {
+	return fmt.Sprintf("%g %g %g %g", vb[0], vb[1], vb[2], vb[3])
 }
 
+// svgLength returns the named attribute of an <svg> start tag as a number of
+// pixels, reporting false if it is absent or not a plain length
+func svgLength(tag, name string) (float64, bool) {
+	value, ok := attrValue(tag, name)
+	if !ok {
+		return 0, false
+	}
+	length, err := strconv.ParseFloat(strings.TrimSuffix(value, "px"), 64)
+	return length, err == nil
+}
+
+// svgStartRegex matches the root <svg> start tag. Tag names are matched
+// case-insensitively, as some hand-edited files use <SVG>
+var svgStartRegex = regexp.MustCompile(`(?i)<svg(?:[\s/][^>]*)?>`)
+
 func (s *SVGStacker) parseSVG(content string, level string) (DiagramInfo, error) {
 	var info DiagramInfo
 
 	// Extract SVG element attributes
-	svgRegex := regexp.MustCompile(`<svg[^>]*>`)
-	match := svgRegex.FindString(content)
-	if match == "" {
+	svgLoc := svgStartRegex.FindStringIndex(content)
+	if svgLoc == nil {
 		return info, fmt.Errorf("no SVG element")
 	}
+	match := content[svgLoc[0]:svgLoc[1]]
 
 	// Extract viewBox
-	viewBoxRegex := regexp.MustCompile(`viewBox="([^"]*)"`)
-	if viewBoxMatch := viewBoxRegex.FindStringSubmatch(match); len(viewBoxMatch) > 1 {
-		info.viewBox = viewBoxMatch[1]
+	if viewBox, ok := attrValue(match, "viewBox"); ok {
+		info.viewBox = viewBox
+	}
+
+	// Extract width and height; values that aren't plain numbers, such as
+	// percentages, count as absent
+	width, hasWidth := svgLength(match, "width")
+	height, hasHeight := svgLength(match, "height")
+
+	// The viewBox carries PlantUML's intended geometry; when the declared
+	// width/height disagree with it, keep the declared width and fix the height.
+	// Missing dimensions are derived from it, and only without a usable viewBox
+	// do they fall back to 400x300.
+	if vb, err := parseViewBox(info.viewBox); err == nil {
+		info.viewBox = formatViewBox(vb)
+		vbRatio := vb[2] / vb[3]
+		switch {
+		case hasWidth && hasHeight:
+			info.width, info.height = width, height
+			if math.Abs(vbRatio-width/height) > 0.001 {
+				logger.Debugf("%s: %gx%g disagrees with viewBox %q, using viewBox proportions\n", level, width, height, info.viewBox)
+				info.height = width / vbRatio
+			}
+		case hasWidth:
+			info.width, info.height = width, width/vbRatio
+		case hasHeight:
+			info.width, info.height = height*vbRatio, height
+		default:
+			info.width, info.height = vb[2], vb[3]
+		}
+		info.aspectRatio = vbRatio
 	} else {
-		info.viewBox = "0 0 400 300"
+		if info.viewBox != "" {
+			logger.Debugf("%s: ignoring %v\n", level, err)
+		}
+		info.width, info.height = 400, 300
+		if hasWidth {
+			info.width = width
+		}
+		if hasHeight {
+			info.height = height
+		}
+		info.aspectRatio = info.width / info.height
+		info.viewBox = fmt.Sprintf("0 0 %g %g", info.width, info.height)
+	}
+	logger.Debugf("%s: viewBox %q, %gx%g, aspect ratio %.3f\n", level, info.viewBox, info.width, info.height, info.aspectRatio)
+
+	// Extract content between the opening tag and the last </svg>
+	startIdx := svgLoc[1]
+	endIdx := -1
+	closeRegex := regexp.MustCompile(`(?i)</svg\s*>`)
+	if closes := closeRegex.FindAllStringIndex(content[startIdx:], -1); closes != nil {
+		endIdx = startIdx + closes[len(closes)-1][0]
+	}
+
+	if endIdx == -1 {
+		return info, fmt.Errorf("no </svg> tag")
+	}
+
+	rawContent := content[startIdx:endIdx]
+	if vb, err := parseViewBox(info.viewBox); err == nil {
+		info.background = sourceBackground(match, rawContent, vb)
+	}
+	info.namespaces = svgNamespaces(match)
+	info.title = sourceTitle(rawContent)
+	if s.opts.LinkLegend {
+		info.linkStyles = linkStyles(rawContent, info.namespaces)
+	}
+	if s.opts.NoClean {
+		// For comparing against the cleaned output when diagnosing rendering problems
+		info.content = strings.TrimSpace(rawContent)
+	} else {
+		cleanedContent, err := s.cleanDiagramContent(rawContent, level, info.namespaces)
+		if err != nil {
+			return info, fmt.Errorf("%s diagram: %w", level, err)
+		}
+		// Pretty-print the content for better readability (namespace context is preserved)
+		if s.opts.NoPretty {
+			info.content = strings.TrimSpace(cleanedContent)
+		} else {
+			info.content = s.prettyPrintXML(cleanedContent, info.namespaces)
+		}
+	}
+
+	if s.opts.ContentTransform != nil {
+		info.content = s.opts.ContentTransform(level, info.content)
+	}
+	return info, nil
+}
+
+// sourceBackground returns the fill a diagram paints behind itself: that of a
+// leading <rect> covering the whole viewBox, or failing that the background
+// colour PlantUML puts in the style of the <svg> tag. It returns "" if there is none.
+func sourceBackground(svgTag, content string, vb [4]float64) string {
+	rectRegex := regexp.MustCompile(`<rect\s[^>]*>`)
+	if rect := rectRegex.FindString(content); rect != "" && coversViewBox(rect, vb) {
+		if fill, ok := attrValue(rect, "fill"); ok && fill != "none" && fill != "transparent" {
+			return fill
+		}
+	}
+
+	if style, ok := attrValue(svgTag, "style"); ok {
+		backgroundRegex := regexp.MustCompile(`(?:^|;)\s*background(?:-color)?\s*:\s*([^;]+)`)
+		if m := backgroundRegex.FindStringSubmatch(style); m != nil {
+			if fill := strings.TrimSpace(m[1]); fill != "none" && fill != "transparent" {
+				return fill
+			}
+		}
+	}
+	return ""
+}
+
+// sourceTitle returns the text of the first <title> directly inside the source
+// <svg>, with whitespace collapsed; titles of individual shapes are tooltips, not
+// the diagram's name. It returns "" if there is none.
+func sourceTitle(content string) string {
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	decoder.Strict = false
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth == 0 && t.Name.Local == "title" {
+				var text strings.Builder
+				for {
+					token, err := decoder.Token()
+					if err != nil {
+						return ""
+					}
+					if data, ok := token.(xml.CharData); ok {
+						text.Write(data)
+					}
+					if _, ok := token.(xml.EndElement); ok {
+						return strings.Join(strings.Fields(text.String()), " ")
+					}
+				}
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// coversViewBox reports whether a <rect> tag spans the whole of vb. Percentages
+// are relative to the viewBox, so "100%" covers it.
+func coversViewBox(rect string, vb [4]float64) bool {
+	length := func(name string, defaultValue, full float64) (float64, bool) {
+		value, ok := attrValue(rect, name)
+		if !ok {
+			return defaultValue, true
+		}
+		if percent, ok := strings.CutSuffix(value, "%"); ok {
+			v, err := strconv.ParseFloat(percent, 64)
+			return v / 100 * full, err == nil
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(value, "px"), 64)
+		return v, err == nil
+	}
+
+	x, okX := length("x", 0, vb[2])
+	y, okY := length("y", 0, vb[3])
+	width, okW := length("width", 0, vb[2])
+	height, okH := length("height", 0, vb[3])
+	if !okX || !okY || !okW || !okH {
+		return false
+	}
+	// Allow for rounding in the exported coordinates
+	const slack = 0.5
+	return x <= vb[0]+slack && y <= vb[1]+slack &&
+		x+width >= vb[0]+vb[2]-slack && y+height >= vb[1]+vb[3]-slack
+}
+
+func (s *SVGStacker) prettyPrintXML(content string, namespaces map[string]string) string {
+	formatted, err := transformXML(content, namespaces, true, nil)
+	if err != nil {
+		// If parsing fails, return original content
+		return content
 	}
+	return strings.TrimSpace(formatted)
+}
 
-	// Extract width and height
-	widthRegex := regexp.MustCompile(`width="([^"]*)"`)
-	heightRegex := regexp.MustCompile(`height="([^"]*)"`)
+// cleanDiagramContent drops scripts and event handlers, turns links into onclick
+// navigation, wraps long link labels with --wrap-link-labels and marks note groups
+func (s *SVGStacker) cleanDiagramContent(content string, currentLevel string, namespaces map[string]string) (string, error) {
+	cleaner := &diagramCleaner{
+		keepLinks:    s.opts.KeepLinks,
+		keepMetadata: s.opts.KeepMetadata,
+		backLink: func(href string) bool {
+			target := s.linkTarget(href)
+			if target == "unknown" || slices.Index(c4Levels, target) > slices.Index(c4Levels, currentLevel) {
+				return false
+			}
+			logger.Warnf("Warning: %s links to %q, the %s level, which isn't below it; leaving the link unclickable\n", currentLevel, href, target)
+			return true
+		},
+	}
+	cleaned, err := transformXML(content, namespaces, false, cleaner.token)
+	if err != nil {
+		return "", err
+	}
+	if s.opts.WrapLinkLabels > 0 {
+		wrapper := &linkLabelWrapper{maxChars: s.opts.WrapLinkLabels}
+		if cleaned, err = transformXML(cleaned, namespaces, false, wrapper.token); err != nil {
+			return "", err
+		}
+	}
+	return cleaned, nil
+}
+
+func (s *SVGStacker) buildStackedSVG() string {
+	levels := s.levelOrder()
+
+	var sb strings.Builder
+
+	// SVG Header - JavaScript will set explicit dimensions
+	sb.WriteString(s.svgPreamble(`
+     width="` + strconv.Itoa(headerWidth) + `"
+     height="1080"
+     style="background: #f8f9fa; display: block;"`))
+
+	// The watermark comes first so everything else is drawn over it
+	sb.WriteString(s.background)
+
+	font := xmlEscape(s.fontFamily())
+
+	// In RTL mode the header is mirrored: the title and nav buttons start from the
+	// right edge (repositioned via JavaScript once the viewport width is known)
+	titleX := 26
+	direction := ""
+	if s.opts.RTL {
+		titleX = headerWidth - 26
+		direction = ` direction="rtl"`
+	}
+
+	sb.WriteString(fmt.Sprintf(`
+
+  <!-- Navigation Header (hidden when printing) -->
+  <g id="nav-chrome">
+  <rect x="0" y="0" width="100%%" height="80" fill="#2c3e50"/>
+  <text x="%d" y="50" font-family="%s" font-size="30" font-weight="bold" fill="white"%s
+        id="header-title">
+    %s
+  </text>
+`, titleX, font, direction, xmlEscape(s.title)))
+
+	stacks := s.allStacks()
+	if len(stacks) > 1 {
+		sb.WriteString(s.createStackTabs(font, direction))
+	}
+
+	sb.WriteString(`
+  <!-- Navigation Buttons -->
+`)
+
+	for i, stack := range stacks {
+		if len(stacks) > 1 {
+			display := "block"
+			if i > 0 {
+				display = "none"
+			}
+			sb.WriteString(fmt.Sprintf("  <g id=\"stack-nav-%s\" style=\"display:%s\">\n", strings.TrimSuffix(stack.idPrefix, "-"), display))
+		}
+
+		// Generate navigation buttons (only for levels that exist), each sized to its
+		// label and laid out sequentially
+		offset := 26
+		for _, level := range levels {
+			if _, exists := stack.diagrams[level]; !exists {
+				continue // Skip button if diagram doesn't exist
+			}
+
+			label := stack.levelLabel(level)
+			width := navButtonWidth(label)
+			x := offset
+			textX := x + navPadding
+			if s.opts.RTL {
+				x = headerWidth - offset - width
+				textX = x + width - navPadding
+			}
+			offset += width + s.buttonGap()
 
-	if widthMatch := widthRegex.FindStringSubmatch(match); len(widthMatch) > 1 {
-		widthStr := strings.TrimSuffix(widthMatch[1], "px")
-		parsedWidth, err := strconv.ParseFloat(widthStr, 64)
-		if err != nil {
-			info.width = 400
-		} else {
-			info.width = parsedWidth
+			key := stack.levelKey(level)
+			sb.WriteString(fmt.Sprintf(`  <rect x="%d" y="91" width="%d" height="33" rx="4"
+        fill="#3498db" stroke="#2980b9" stroke-width="1"
+        style="cursor:pointer" onclick="showLevel(%s)"
+        id="nav-%s"/>
+  <text x="%d" y="113" font-family="%s" font-size="%d"%s
+        fill="white" style="cursor:pointer; user-select: none"
+        onclick="showLevel(%s)" id="nav-text-%s">
+    %s
+  </text>
+`, x, width, jsString(key), key, textX, font, navFontSize, direction, jsString(key), key, xmlEscape(label)))
 		}
-	} else {
-		info.width = 400
-	}
 
-	if heightMatch := heightRegex.FindStringSubmatch(match); len(heightMatch) > 1 {
-		heightStr := strings.TrimSuffix(heightMatch[1], "px")
-		parsedHeight, err := strconv.ParseFloat(heightStr, 64)
-		if err != nil {
-			info.height = 300
-		} else {
-			info.height = parsedHeight
+		if len(stacks) > 1 {
+			sb.WriteString("  </g>\n")
 		}
-	} else {
-		info.height = 300
 	}
 
-	info.aspectRatio = info.width / info.height
-
-	// Extract content between <svg> and </svg> more robustly
-	// Find the end of the opening <svg> tag
-	svgStartPos := strings.Index(content, "<svg")
-	if svgStartPos == -1 {
-		return info, fmt.Errorf("no <svg> tag")
+	// Add toggle buttons (positioned via JavaScript on load/resize)
+	if s.opts.Overview {
+		sb.WriteString(fmt.Sprintf(`
+  <!-- Overview Toggle (right-aligned via JavaScript) -->
+  <rect x="832" y="91" width="130" height="33" rx="4"
+        fill="#3498db" stroke="#2980b9" stroke-width="1"
+        style="cursor:pointer" onclick="toggleOverview()"
+        id="overview-toggle"/>
+  <text x="845" y="113" font-family="%s" font-size="14"
+        fill="white" style="cursor:pointer; user-select: none"
+        onclick="toggleOverview()" id="overview-text">
+    Overview
+  </text>
+`, font))
 	}
 
-	svgTagEndPos := strings.Index(content[svgStartPos:], ">")
-	if svgTagEndPos == -1 {
-		return info, fmt.Errorf("malformed <svg> tag")
+	if s.opts.Legend {
+		sb.WriteString(fmt.Sprintf(`
+  <!-- Legend Toggle (right-aligned via JavaScript) -->
+  <rect x="676" y="91" width="130" height="33" rx="4"
+        fill="#3498db" stroke="#2980b9" stroke-width="1"
+        style="cursor:pointer" onclick="toggleLegend()"
+        id="legend-toggle"/>
+  <text x="689" y="113" font-family="%s" font-size="14"
+        fill="white" style="cursor:pointer; user-select: none"
+        onclick="toggleLegend()" id="legend-text">
+    Hide Legend
+  </text>
+`, font))
 	}
 
-	startIdx := svgStartPos + svgTagEndPos + 1
-	endIdx := strings.LastIndex(content, "</svg>")
-
-	if endIdx == -1 || endIdx <= startIdx {
-		return info, fmt.Errorf("no </svg> tag")
+	if !s.opts.NoNotesToggle {
+		sb.WriteString(fmt.Sprintf(`
+  <!-- Notes Toggle (right-aligned via JavaScript) -->
+  <rect x="364" y="91" width="130" height="33" rx="4"
+        fill="#3498db" stroke="#2980b9" stroke-width="1"
+        style="cursor:pointer" onclick="toggleNotes()"
+        id="notes-toggle"/>
+  <text x="377" y="113" font-family="%s" font-size="14"
+        fill="white" style="cursor:pointer; user-select: none"
+        onclick="toggleNotes()" id="notes-text">
+    Hide Notes
+  </text>
+`, font))
 	}
 
-	rawContent := content[startIdx:endIdx]
-	cleanedContent := s.cleanDiagramContent(rawContent, level)
-	// Pretty-print the content for better readability (namespace context is preserved)
-	info.content = s.prettyPrintXML(cleanedContent)
-
-	return info, nil
-}
-
-func (s *SVGStacker) prettyPrintXML(content string) string {
-	// Wrap in a root element with namespace declarations for parsing.
-	// IMPORTANT: We must include xmlns:xlink here because the content we're formatting
-	// is extracted from inside an <svg> tag (which normally declares xmlns:xlink).
-	// Without this declaration, Go's xml.Encoder will mangle xlink:href attributes
-	// by changing xmlns:xlink="http://www.w3.org/1999/xlink" to xmlns:xlink="xlink",
-	// which breaks <image> elements that use xlink:href for embedded data URIs.
-	wrapped := `<root xmlns:xlink="http://www.w3.org/1999/xlink">` + content + "</root>"
+	if !s.opts.NoFitToggle {
+		sb.WriteString(fmt.Sprintf(`
+  <!-- Fit to Width Toggle (right-aligned via JavaScript) -->
+  <rect x="520" y="91" width="130" height="33" rx="4"
+        fill="#3498db" stroke="#2980b9" stroke-width="1"
+        style="cursor:pointer" onclick="toggleFitMode()"
+        id="fit-toggle"/>
+  <text x="533" y="113" font-family="%s" font-size="14"
+        fill="white" style="cursor:pointer; user-select: none"
+        onclick="toggleFitMode()" id="fit-text">
+    Native Size
+  </text>
+`, font))
+	}
 
-	var buf bytes.Buffer
-	decoder := xml.NewDecoder(strings.NewReader(wrapped))
-	encoder := xml.NewEncoder(&buf)
-	encoder.Indent("      ", "  ")
+	sb.WriteString(`  </g>
 
-	for {
-		token, err := decoder.Token()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			// If parsing fails, return original content
-			return content
-		}
+  <!-- Diagram Layers (positioned below header at y=140) -->
+`)
 
-		// Skip the root wrapper element
-		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "root" {
-			continue
-		}
-		if end, ok := token.(xml.EndElement); ok && end.Name.Local == "root" {
-			continue
+	// Generate diagram layers
+	for _, stack := range stacks {
+		for _, level := range levels {
+			sb.WriteString(stack.createDiagramLayer(level))
 		}
+	}
 
-		if err := encoder.EncodeToken(token); err != nil {
-			return content
-		}
+	if s.opts.Overview {
+		sb.WriteString(s.createOverview(levels, font))
 	}
 
-	if err := encoder.Flush(); err != nil {
-		return content
+	// The legend comes after the layers so it is drawn on top of them
+	if s.opts.Legend {
+		sb.WriteString(s.createLegend(levels, font))
 	}
 
-	return strings.TrimSpace(buf.String())
-}
+	// Add JavaScript
+	var js strings.Builder
+	// Inject actual diagram dimensions
+	js.WriteString("const diagramData = {\n")
+	diagramCount := 0
+	for _, stack := range stacks {
+		for _, level := range levels {
+			if diagram, exists := stack.diagrams[level]; exists {
+				if diagramCount > 0 {
+					js.WriteString(",\n")
+				}
+				// Shortest exact representation, so small diagrams don't drift when fitted
+				js.WriteString(fmt.Sprintf("  %s: { width: %s, height: %s, ratio: %s }",
+					jsString(stack.levelKey(level)), jsNumber(diagram.width), jsNumber(diagram.height), jsNumber(diagram.width/diagram.height)))
+				diagramCount++
+			}
+		}
+	}
+	js.WriteString("\n};\n\n")
+
+	// Inject the levels of each stack; the navigation works on those of the first
+	// and switches availableLevels when another tab is selected
+	js.WriteString("const stacks = [")
+	if len(stacks) > 1 {
+		for i, stack := range stacks {
+			if i > 0 {
+				js.WriteString(",")
+			}
+			js.WriteString(fmt.Sprintf("\n  { id: %s, prefix: %s, levels: %s }",
+				jsString(strings.TrimSuffix(stack.idPrefix, "-")), jsString(stack.idPrefix), stack.availableLevelsJS(levels)))
+		}
+		js.WriteString("\n")
+	}
+	js.WriteString("];\n\n")
+	js.WriteString("let availableLevels = " + stacks[0].availableLevelsJS(levels) + ";\n\n")
 
-func (s *SVGStacker) cleanDiagramContent(content string, currentLevel string) string {
-	// Remove scripts
-	scriptRegex := regexp.MustCompile(`<script[^>]*>.*?</script>`)
-	content = scriptRegex.ReplaceAllString(content, "")
+	js.WriteString(fmt.Sprintf("const rtlLayout = %t;\nconst buttonGap = %d;\nconst animationDuration = %d; // ms, 0 = no animation\nconst printAll = %t;\n\n",
+		s.opts.RTL, s.buttonGap(), s.opts.AnimationDuration.Milliseconds(), s.opts.PrintAll))
 
-	// Add onclick handlers and clean up <a> tags
-	aTagRegex := regexp.MustCompile(`(<g[^>]*>)\s*<a\s+[^>]*href="[^"]*"[^>]*>(.*?)</a>`)
-	content = aTagRegex.ReplaceAllStringFunc(content, func(match string) string {
-		submatches := aTagRegex.FindStringSubmatch(match)
-		if len(submatches) >= 3 {
-			gTag := submatches[1]          // <g ...>
-			contentInside := submatches[2] // content inside <a>
+	js.WriteString(s.script)
 
-			// Add onclick to the g element
-			return strings.Replace(gTag, ">", ` onclick="navigateDown()" style="cursor:pointer;">`, 1) + contentInside
-		}
-		return match
-	})
+	sb.WriteString(`
+  <!-- Navigation Script -->
+` + s.scriptElement(js.String()) + `
 
-	// Clean up any remaining <a> tags
-	content = regexp.MustCompile(`<a\s+[^>]*>`).ReplaceAllString(content, "")
-	content = strings.ReplaceAll(content, "</a>", "")
+</svg>`)
 
-	return content
+	return sb.String()
 }
 
-func (s *SVGStacker) buildStackedSVG() string {
-	levels := []string{"context", "container", "component", "code"}
-
-	// Use embedded JavaScript for interactive mode
-	jsContent := []byte(navigationJS)
-
+// svgPreamble writes the XML declaration, the root <svg> start tag with the size
+// attributes in size, and the title, metadata, styles and shared defs that every
+// layout starts with
+func (s *SVGStacker) svgPreamble(size string) string {
 	var sb strings.Builder
+	// With --conformant the root declares its SVG version, and the generator
+	// metadata, which isn't SVG, is put in its own namespace
+	svgVersion, metaNamespace := "", ""
+	if s.opts.Conformant {
+		svgVersion = "\n     version=\"1.1\""
+		metaNamespace = ` xmlns="` + generatorNamespace + `"`
+	}
 
-	// SVG Header - JavaScript will set explicit dimensions
 	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
 <svg xmlns="http://www.w3.org/2000/svg"
-     xmlns:xlink="http://www.w3.org/1999/xlink"
-     width="1920"
-     height="1080"
-     style="background: #f8f9fa; display: block;">
+     xmlns:xlink="http://www.w3.org/1999/xlink"` + svgVersion + size + `>
 
   <title>Stacked C4 Architecture Diagrams</title>
 
   <!-- Generator Metadata (invisible) -->
   <metadata>
-    <generator>stacked-c4-svg</generator>
-    <version>` + version + `</version>
-    <timestamp>` + time.Now().UTC().Format(time.RFC3339) + `</timestamp>
+    <generator` + metaNamespace + `>stacked-c4-svg</generator>
+    <version` + metaNamespace + `>` + version + `</version>
+    <timestamp` + metaNamespace + `>` + time.Now().UTC().Format(time.RFC3339) + `</timestamp>
   </metadata>
 
   <!-- CSS Styles for Progressive Enhancement -->
-  <style>
+  <style>` + s.fontFaces + `
     /* Path highlighting - works without JavaScript */
     .link path,
     .link polygon {
@@ -713,140 +2786,516 @@ func (s *SVGStacker) buildStackedSVG() string {
     .link.dimmed polygon {
       opacity: 0.3;
     }
+
+    /* Print only the diagrams; JavaScript fits them to the page on beforeprint */
+    @media print {
+      #nav-chrome,
+      #legend-panel,
+      #overview {
+        display: none !important;
+      }
+    }` + s.extraCSS + `
   </style>`)
 
-	sb.WriteString(fmt.Sprintf(`
+	if s.imageDefs != "" {
+		sb.WriteString(`
 
-  <!-- Navigation Header -->
-  <rect x="0" y="0" width="100%%" height="80" fill="#2c3e50"/>
-  <text x="26" y="50" font-family="Arial, sans-serif" font-size="30" font-weight="bold" fill="white">
-    %s
-  </text>
+  <!-- Inline images shared between diagrams -->
+  <defs>
+` + s.imageDefs + `  </defs>`)
+	}
 
-  <!-- Navigation Buttons -->
-`, s.title))
+	return sb.String()
+}
+
+// scriptElement wraps js in a <script> of the --script-type, as CDATA or, with
+// --no-cdata, escaped
+func (s *SVGStacker) scriptElement(js string) string {
+	if s.opts.NoCDATA {
+		js = "\n    " + escapeCharData(js) + "\n  "
+	} else {
+		js = "<![CDATA[\n    " + js + "\n  ]]>"
+	}
+	return `  <script type="` + xmlEscape(s.scriptType()) + `">` + js + `</script>`
+}
+
+// customScript reads a --js replacement for navigation.js. Like it, the script is
+// appended after the injected diagramData, stacks and availableLevels declarations.
+func customScript(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("--js: %w", err)
+	}
+	script := string(content)
+	if strings.TrimSpace(script) == "" {
+		return "", fmt.Errorf("--js: %s is empty", file)
+	}
+	if strings.Contains(script, "]]>") {
+		return "", fmt.Errorf("--js: %s contains \"]]>\", which would end the script's CDATA section", file)
+	}
+	return script, nil
+}
+
+// customCSS reads the --css files, each wrapped in a CDATA section so selectors
+// such as "a > b" and strings containing "&" need no escaping. With noCDATA they
+// are escaped instead.
+func customCSS(files []string, noCDATA bool) (string, error) {
+	var sb strings.Builder
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("--css: %w", err)
+		}
+		css := string(content)
+		if strings.Contains(strings.ToLower(css), "</style") {
+			return "", fmt.Errorf("--css: %s contains </style>, which would end the stylesheet early", file)
+		}
+		if noCDATA {
+			sb.WriteString("\n\n" + escapeCharData(strings.TrimRight(css, "\n")))
+			continue
+		}
+		if strings.Contains(css, "]]>") {
+			return "", fmt.Errorf("--css: %s contains \"]]>\", which would end its CDATA section", file)
+		}
+		sb.WriteString("\n\n    <![CDATA[\n" + strings.TrimRight(css, "\n") + "\n    ]]>")
+	}
+	return sb.String(), nil
+}
+
+// levelOrder returns c4Levels in the --sort-by order of their diagrams. Levels
+// without a diagram go last; ties keep the C4 order. With several stacks a
+// level is placed by the diagram in the first stack that has one.
+func (s *SVGStacker) levelOrder() []string {
+	levels := slices.Clone(c4Levels)
+	if s.opts.SortBy == "" || s.opts.SortBy == "c4" {
+		return levels
+	}
+
+	diagram := func(level string) (DiagramInfo, bool) {
+		for _, stack := range s.allStacks() {
+			if d, ok := stack.diagrams[level]; ok {
+				return d, true
+			}
+		}
+		return DiagramInfo{}, false
+	}
+	slices.SortStableFunc(levels, func(a, b string) int {
+		da, okA := diagram(a)
+		db, okB := diagram(b)
+		switch {
+		case okA && !okB:
+			return -1
+		case !okA && okB:
+			return 1
+		case !okA:
+			return 0
+		}
+		switch s.opts.SortBy {
+		case "name":
+			return strings.Compare(strings.ToLower(filepath.Base(da.file)), strings.ToLower(filepath.Base(db.file)))
+		case "mtime":
+			return da.modTime.Compare(db.modTime)
+		case "number":
+			return fileNumber(da.file) - fileNumber(db.file)
+		}
+		return 0
+	})
+	return levels
+}
+
+// fileNumber is the numeric prefix of a file name, such as 2 for
+// "02-container.svg", or math.MaxInt32 if it has none
+func fileNumber(file string) int {
+	base := filepath.Base(file)
+	end := strings.IndexFunc(base, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(base)
+	}
+	n, err := strconv.Atoi(base[:end])
+	if err != nil || n > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return n
+}
 
-	// Generate navigation buttons (only for levels that exist)
-	buttonIndex := 0
+// availableLevelsJS renders the keys of the stack's levels as a JavaScript array
+func (s *SVGStacker) availableLevelsJS(levels []string) string {
+	var keys []string
 	for _, level := range levels {
-		if _, exists := s.diagrams[level]; !exists {
-			continue // Skip button if diagram doesn't exist
+		if _, exists := s.diagrams[level]; exists {
+			keys = append(keys, jsString(s.levelKey(level)))
 		}
+	}
+	return "[" + strings.Join(keys, ", ") + "]"
+}
 
-		x := 26 + buttonIndex*117
-		buttonIndex++
+// createStackTabs renders the tab bar selecting between stacks, placed in the
+// header after the title (positioned via JavaScript once the title is measured)
+func (s *SVGStacker) createStackTabs(font, direction string) string {
+	var sb strings.Builder
+	sb.WriteString(`
+  <!-- Stack Tabs -->
+`)
+	offset := 26 + int(math.Ceil(float64(utf8.RuneCountInString(s.title))*30*navCharWidth)) + 39
+	for i, stack := range s.stacks {
+		id := strings.TrimSuffix(stack.idPrefix, "-")
+		width := navButtonWidth(stack.label)
+		x := offset
+		textX := x + navPadding
+		if s.opts.RTL {
+			x = headerWidth - offset - width
+			textX = x + width - navPadding
+		}
+		offset += width + s.buttonGap()
 
-		sb.WriteString(fmt.Sprintf(`  <rect x="%d" y="91" width="104" height="33" rx="4"
-        fill="#3498db" stroke="#2980b9" stroke-width="1"
-        style="cursor:pointer" onclick="showLevel('%s')"
-        id="nav-%s"/>
-  <text x="%d" y="113" font-family="Arial, sans-serif" font-size="14"
+		fill := "#34495e"
+		if i == 0 {
+			fill = "#3498db"
+		}
+		sb.WriteString(fmt.Sprintf(`  <rect x="%d" y="24" width="%d" height="33" rx="4"
+        fill="%s" stroke="#2980b9" stroke-width="1"
+        style="cursor:pointer" onclick="switchStack(%s)"
+        id="tab-%s"/>
+  <text x="%d" y="46" font-family="%s" font-size="%d"%s
         fill="white" style="cursor:pointer; user-select: none"
-        onclick="showLevel('%s')">
+        onclick="switchStack(%s)" id="tab-text-%s">
     %s
   </text>
-`, x, level, level, x+13, level, titleCase(level)))
+`, x, width, fill, jsString(id), id, textX, font, navFontSize, direction, jsString(id), id, xmlEscape(stack.label)))
 	}
+	return sb.String()
+}
 
-	// Add toggle buttons (positioned via JavaScript on load/resize)
-	sb.WriteString(`
-  <!-- Notes Toggle (right-aligned via JavaScript) -->
-  <rect x="364" y="91" width="130" height="33" rx="4"
-        fill="#3498db" stroke="#2980b9" stroke-width="1"
-        style="cursor:pointer" onclick="toggleNotes()"
-        id="notes-toggle"/>
-  <text x="377" y="113" font-family="Arial, sans-serif" font-size="14"
-        fill="white" style="cursor:pointer; user-select: none"
-        onclick="toggleNotes()" id="notes-text">
-    Hide Notes
-  </text>
+// xmlEscape escapes s for use in XML text or attribute values
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
 
-  <!-- Fit to Width Toggle (right-aligned via JavaScript) -->
-  <rect x="520" y="91" width="130" height="33" rx="4"
-        fill="#3498db" stroke="#2980b9" stroke-width="1"
-        style="cursor:pointer" onclick="toggleFitMode()"
-        id="fit-toggle"/>
-  <text x="533" y="113" font-family="Arial, sans-serif" font-size="14"
-        fill="white" style="cursor:pointer; user-select: none"
-        onclick="toggleFitMode()" id="fit-text">
-    Native Size
-  </text>
-`)
+// charDataEscaper escapes the characters that can't appear literally in element
+// text. Unlike xmlEscape it leaves newlines and tabs alone, so a script or
+// stylesheet stays readable.
+var charDataEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
 
-	sb.WriteString(`
+// escapeCharData escapes s for use as element text, for --no-cdata
+func escapeCharData(s string) string {
+	return charDataEscaper.Replace(s)
+}
 
-  <!-- Diagram Layers (positioned below header at y=140) -->
-`)
+// jsString quotes s as a single-quoted JavaScript string literal. Quotes and markup
+// characters are written as escapes so the literal is also safe inside an XML
+// attribute or a CDATA section.
+func jsString(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '\'':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '"' || r == '<' || r == '>' || r == '&' || r < 0x20 || r == 0x2028 || r == 0x2029:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
 
-	// Generate diagram layers
+// jsNumber formats v as a JavaScript number literal without losing precision
+func jsNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// scriptType is the type attribute of the navigation <script>
+func (s *SVGStacker) scriptType() string {
+	if s.opts.ScriptType != "" {
+		return s.opts.ScriptType
+	}
+	return defaultScriptType
+}
+
+// fontFamily is the font used for the header, buttons and placeholder text
+func (s *SVGStacker) fontFamily() string {
+	if s.opts.Font != "" {
+		return s.opts.Font
+	}
+	return defaultFontFamily
+}
+
+// levelDescriptions are the legend's one-line summaries of the C4 model levels
+var levelDescriptions = map[string]string{
+	"context":   "The system and the people and systems around it",
+	"container": "Applications and data stores inside the system",
+	"component": "Building blocks inside a single container",
+	"code":      "Classes, functions or tables of a component",
+}
+
+const legendWidth = 340
+
+// createLegend renders the legend panel listing the levels present in any stack,
+// and with --link-legend the relationship line styles. Clicking a level entry
+// shows that level of the current stack; the panel is positioned via JavaScript.
+func (s *SVGStacker) createLegend(levels []string, font string) string {
+	var entries strings.Builder
+	count := 0
 	for _, level := range levels {
-		sb.WriteString(s.createDiagramLayer(level))
+		if !s.anyStackHas(level) {
+			continue
+		}
+		y := 52 + count*40
+		count++
+		entries.WriteString(fmt.Sprintf(`    <g style="cursor:pointer" onclick="showStackLevel(%s)">
+      <text x="16" y="%d" font-family="%s" font-size="14" font-weight="bold" fill="#2c3e50">%s</text>
+      <text x="16" y="%d" font-family="%s" font-size="12" fill="#555">%s</text>
+    </g>
+`, jsString(levelID(level)), y, font, xmlEscape(titleCase(level)), y+17, font, xmlEscape(levelDescriptions[level])))
 	}
 
-	// Add JavaScript
+	height := 44 + count*40
+	if s.opts.LinkLegend {
+		height += s.writeLinkLegend(&entries, levels, font, height)
+	}
+
+	return fmt.Sprintf(`
+  <!-- Legend (right-aligned via JavaScript) -->
+  <g id="legend-panel" transform="translate(%d, 140)">
+    <rect x="0" y="0" width="%d" height="%d" rx="4"
+          fill="white" fill-opacity="0.95" stroke="#bdc3c7" stroke-width="1"/>
+    <text x="16" y="26" font-family="%s" font-size="14" fill="#7f8c8d">C4 model levels</text>
+%s  </g>
+`, headerWidth-26-legendWidth, legendWidth, height, font, entries.String())
+}
+
+const (
+	overviewTileWidth  = 320
+	overviewTileHeight = 268 // 28px label plus a 230px thumbnail and margin
+)
+
+// createOverview renders every level as a scaled-down tile; clicking a tile shows
+// that level. The overview is hidden until toggled and its tiles are arranged in
+// a grid via JavaScript.
+func (s *SVGStacker) createOverview(levels []string, font string) string {
+	var sb strings.Builder
 	sb.WriteString(`
-  <!-- Navigation Script -->
-  <script type="text/ecmascript"><![CDATA[
-    `)
-	// Inject actual diagram dimensions
-	sb.WriteString("const diagramData = {\n")
-	diagramCount := 0
-	for _, level := range levels {
-		if diagram, exists := s.diagrams[level]; exists {
-			if diagramCount > 0 {
-				sb.WriteString(",\n")
+  <!-- Overview (tiles laid out via JavaScript) -->
+  <g id="overview" style="display:none">
+`)
+	for _, stack := range s.allStacks() {
+		count := 0
+		for _, level := range levels {
+			diagram, exists := stack.diagrams[level]
+			if !exists {
+				continue
+			}
+			x := 26 + count*(overviewTileWidth+26)
+			count++
+			key := stack.levelKey(level)
+			// The layer has the same content, so the copy's ids are made unique
+			prefixer := &idPrefixer{prefix: "overview-" + key + "-"}
+			content, err := transformXML(diagram.content, diagram.namespaces, false, prefixer.token)
+			if err != nil {
+				logger.Warnf("Warning: leaving the %s overview tile empty: %v\n", key, err)
+				content = ""
 			}
-			sb.WriteString(fmt.Sprintf("  '%s': { width: %.0f, height: %.0f, ratio: %.2f }",
-				level, diagram.width, diagram.height, diagram.aspectRatio))
-			diagramCount++
+			sb.WriteString(fmt.Sprintf(`    <g id="overview-tile-%s" transform="translate(%d, 150)" style="cursor:pointer" onclick="showLevel(%s)">
+      <rect x="0" y="0" width="%d" height="%d" rx="4" fill="white" stroke="#bdc3c7" stroke-width="1"/>
+      <text x="12" y="20" font-family="%s" font-size="14" font-weight="bold" fill="#2c3e50">%s</text>
+      <svg viewBox="%s" x="10" y="28" width="%d" height="%d" preserveAspectRatio="xMidYMid meet" pointer-events="none"%s>
+        %s
+      </svg>
+    </g>
+`, key, x, jsString(key), overviewTileWidth, overviewTileHeight, font, xmlEscape(titleCase(level)),
+				diagram.viewBox, overviewTileWidth-20, overviewTileHeight-38, diagram.namespaceAttrs(), content))
 		}
 	}
-	sb.WriteString("\n};\n\n")
+	sb.WriteString("  </g>\n")
+	return sb.String()
+}
 
-	// Inject available levels list
-	sb.WriteString("const availableLevels = [")
-	levelCount := 0
-	for _, level := range levels {
-		if _, exists := s.diagrams[level]; exists {
-			if levelCount > 0 {
-				sb.WriteString(", ")
-			}
-			sb.WriteString(fmt.Sprintf("'%s'", level))
-			levelCount++
+// aspectWarnRange is the range of width/height ratios loaded diagrams are expected in
+func (s *SVGStacker) aspectWarnRange() (float64, float64) {
+	if s.opts.AspectWarnRange[1] > 0 {
+		return s.opts.AspectWarnRange[0], s.opts.AspectWarnRange[1]
+	}
+	return defaultMinAspectRatio, defaultMaxAspectRatio
+}
+
+// removeTemp deletes a temporary directory of intermediate files, or with
+// --keep-temp leaves it and says where it is
+func (s *SVGStacker) removeTemp(dir, contents string) {
+	if s.opts.KeepTemp {
+		logger.Infof("Kept %s in %s\n", contents, dir)
+		return
+	}
+	os.RemoveAll(dir)
+}
+
+// makeTempDir creates a new temporary directory under --temp-dir, or the system
+// temp directory by default, creating --temp-dir first if need be. The path is
+// absolute, since plantuml resolves a relative -o against each source's directory.
+func makeTempDir(parent, pattern string) (string, error) {
+	if parent != "" {
+		var err error
+		if parent, err = filepath.Abs(parent); err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return "", err
 		}
 	}
-	sb.WriteString("];\n\n")
+	return os.MkdirTemp(parent, pattern)
+}
 
-	sb.Write(jsContent)
-	sb.WriteString(`
-  ]]></script>
+// maxFileSize is the largest source SVG, in bytes, that will be read
+func (s *SVGStacker) maxFileSize() int64 {
+	if s.opts.MaxFileSize > 0 {
+		return s.opts.MaxFileSize
+	}
+	return defaultMaxFileSize
+}
 
-</svg>`)
+// scaleFor returns the --scale factor for level: its own, else the global one,
+// else 1
+func (s *SVGStacker) scaleFor(level string) float64 {
+	if scale, ok := s.opts.LevelScales[level]; ok {
+		return scale
+	}
+	if s.opts.Scale > 0 {
+		return s.opts.Scale
+	}
+	return 1
+}
 
-	return sb.String()
+// backgroundOpacity returns the --background-opacity, or the default
+func (s *SVGStacker) backgroundOpacity() float64 {
+	if s.opts.BackgroundOpacity > 0 {
+		return s.opts.BackgroundOpacity
+	}
+	return defaultBackgroundOpacity
+}
+
+// buttonGap is the horizontal space between navigation buttons
+func (s *SVGStacker) buttonGap() int {
+	if s.opts.ButtonGap > 0 {
+		return s.opts.ButtonGap
+	}
+	return defaultButtonGap
+}
+
+// navButtonWidth estimates the width a navigation button needs for label from its
+// character count, since the SVG is generated without access to font metrics
+func navButtonWidth(label string) int {
+	text := math.Ceil(float64(utf8.RuneCountInString(label)) * navFontSize * navCharWidth)
+	return max(int(text)+2*navPadding, navMinWidth)
 }
 
 func (s *SVGStacker) createDiagramLayer(level string) string {
 	diagram, exists := s.diagrams[level]
+	id := s.levelKey(level)
 	if !exists {
+		// navigation.js only shows the levels in availableLevels, so the layer is
+		// for custom --js scripts that offer every level
+		if s.opts.NoPlaceholder {
+			return ""
+		}
+		text, fill := s.opts.PlaceholderText, s.opts.PlaceholderFill
+		if text == "" {
+			text = defaultPlaceholderText
+		}
+		if fill == "" {
+			fill = defaultPlaceholderFill
+		}
 		return fmt.Sprintf(`
   <!-- %s layer (not found) -->
   <g id="layer-%s" style="display:none">
-    <rect x="50" y="120" width="700" height="450" fill="#ecf0f1" stroke="#bdc3c7"/>
-    <text x="400" y="350" text-anchor="middle" font-family="Arial" font-size="16" fill="#7f8c8d">
-      %s diagram not found
+    <rect x="50" y="120" width="700" height="450" fill="%s" stroke="#bdc3c7"/>
+    <text x="400" y="350" text-anchor="middle" font-family="%s" font-size="16" fill="#7f8c8d">
+      %s
     </text>
-  </g>`, level, level, titleCase(level))
+  </g>`, id, id, xmlEscape(fill), xmlEscape(s.fontFamily()), xmlEscape(strings.ReplaceAll(text, "{level}", titleCase(level))))
+	}
+
+	// navigation.js sizes the layer to the viewport; until then the placeholder
+	// lets the diagram show in full. --conformant uses its native size instead,
+	// as in "Native Size" mode.
+	containerWidth, containerHeight, width, height := "99999", "99999", "99999", "99999"
+	if s.opts.Conformant {
+		containerWidth, containerHeight = jsNumber(diagram.width+20), jsNumber(diagram.height+20)
+		width, height = jsNumber(diagram.width), jsNumber(diagram.height)
 	}
 
 	return fmt.Sprintf(`
   <!-- %s layer -->
   <g id="layer-%s" style="display:none">
-    <rect x="5" y="145" width="99999" height="99999" fill="white" stroke="#ddd" stroke-width="1" rx="5" id="container-%s"/>
+    <title>%s</title>
+    <rect x="5" y="145" width="%s" height="%s" fill="%s" stroke="#ddd" stroke-width="1" rx="5" id="container-%s"/>
     <g id="diagram-%s">
-      <svg viewBox="%s" x="10" y="150" width="99999" height="99999" preserveAspectRatio="xMidYMin meet">
+      <svg viewBox="%s" x="10" y="150" width="%s" height="%s" preserveAspectRatio="xMidYMin meet"%s>
         %s
       </svg>
     </g>
-  </g>`, level, level, level, level, diagram.viewBox, diagram.content)
+  </g>`, id, id, xmlEscape(s.levelLabel(level)), containerWidth, containerHeight, xmlEscape(s.containerFill(level)), id, id, diagram.viewBox, width, height, diagram.namespaceAttrs(), diagram.content)
+}
+
+// levelLabel names a level on its nav button and in its layer's tooltip: the
+// title of its source diagram, or else the level name
+func (s *SVGStacker) levelLabel(level string) string {
+	if title := s.diagrams[level].title; title != "" {
+		return title
+	}
+	return titleCase(level)
+}
+
+// containerFill is the background of the rect a level's diagram sits on: a
+// --level-color for the level, else the diagram's own background so it isn't
+// framed in white, else its --level-tint shade
+func (s *SVGStacker) containerFill(level string) string {
+	if color, ok := s.opts.LevelColors[level]; ok {
+		return color
+	}
+	if background := s.diagrams[level].background; background != "" && !s.opts.NoSourceBackground {
+		return background
+	}
+	if s.opts.LevelTint {
+		return s.levelTint(level)
+	}
+	return "white"
+}
+
+// levelTint blends from levelTintLight to levelTintDark across the levels the
+// stack has, so the shades stay distinct however many levels are present
+func (s *SVGStacker) levelTint(level string) string {
+	var present []string
+	for _, l := range c4Levels {
+		if _, ok := s.diagrams[l]; ok {
+			present = append(present, l)
+		}
+	}
+	index := slices.Index(present, level)
+	if index < 0 || len(present) < 2 {
+		return fmt.Sprintf("#%02x%02x%02x", levelTintLight[0], levelTintLight[1], levelTintLight[2])
+	}
+
+	t := float64(index) / float64(len(present)-1)
+	var rgb [3]uint8
+	for i := range rgb {
+		rgb[i] = uint8(math.Round(float64(levelTintLight[i]) + t*(float64(levelTintDark[i])-float64(levelTintLight[i]))))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2])
+}
+
+// namespaceAttrs re-declares the source namespaces for an inner <svg> holding the
+// diagram content, so prefixed names resolve; xlink is already declared on the
+// outer <svg>
+func (d DiagramInfo) namespaceAttrs() string {
+	namespaces := make(map[string]string)
+	for prefix, url := range d.namespaces {
+		if prefix != "xlink" {
+			namespaces[prefix] = url
+		}
+	}
+	return namespaceAttrs(namespaces)
 }
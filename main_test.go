@@ -1,12 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 // createTestSVGFiles creates minimal valid SVG files for testing.
@@ -283,14 +296,17 @@ func TestCleanDiagramContentPreservesXMLStructure(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			stacker := &SVGStacker{}
-			result := stacker.cleanDiagramContent(tc.input, tc.level)
+			result, err := stacker.cleanDiagramContent(tc.input, tc.level, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
 
 			// Wrap in a minimal SVG structure for XML validation
 			xmlContent := `<?xml version="1.0" encoding="UTF-8"?>
 <svg xmlns="http://www.w3.org/2000/svg">` + result + `</svg>`
 
 			var xmlDoc interface{}
-			err := xml.Unmarshal([]byte(xmlContent), &xmlDoc)
+			err = xml.Unmarshal([]byte(xmlContent), &xmlDoc)
 
 			if tc.expectValid && err != nil {
 				t.Errorf("Expected valid XML but got error: %v", err)
@@ -307,6 +323,59 @@ func TestCleanDiagramContentPreservesXMLStructure(t *testing.T) {
 	}
 }
 
+func TestCleanDiagramContentMarksNotes(t *testing.T) {
+	input := `<g class="entity" data-entity="GMN9" id="entity_GMN9"><path d="M0,0 L10,10" fill="#FEFFDD"></path><text>A note</text></g>` +
+		`<g class="entity" data-entity="api" id="entity_api"><rect fill="#438DD5"/><text>API</text></g>`
+
+	stacker := &SVGStacker{}
+	result, err := stacker.cleanDiagramContent(input, "context", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(result, `<g id="entity_GMN9" class="entity note" data-entity="GMN9"`) {
+		t.Errorf("expected note group to be tagged with note class, got: %s", result)
+	}
+	if !strings.Contains(result, `<g id="entity_api" class="entity" data-entity="api"`) {
+		t.Errorf("expected non-note group to be left alone, got: %s", result)
+	}
+
+	// The note's path comes after a nested group, and the next entity isn't a note
+	nested := `<g class="entity" id="entity_N1"><g><rect/></g><path fill="#feffdd"/></g>` +
+		`<g class="entity" id="entity_db"><g><path fill="#FEFFDD"/></g></g>` +
+		`<g class="entity" id="entity_web"><g></g><rect/></g>`
+	if result, err = stacker.cleanDiagramContent(nested, "context", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{`<g id="entity_N1" class="entity note">`, `<g id="entity_db" class="entity note">`, `<g id="entity_web" class="entity">`} {
+		if !strings.Contains(result, want) {
+			t.Errorf("expected %s, got: %s", want, result)
+		}
+	}
+}
+
+// TestCleanDiagramContentSingleQuotes tests link rewriting and note marking with single-quoted attributes
+func TestCleanDiagramContentSingleQuotes(t *testing.T) {
+	input := `<g id='elem_api'><a href='02-container.svg' xlink:href = '02-container.svg'><rect fill='#438DD5'/></a></g>` +
+		`<g class='entity' id='entity_GMN9'><path d='M0,0 L10,10' fill='#FEFFDD'></path></g>`
+
+	stacker := &SVGStacker{}
+	result, err := stacker.cleanDiagramContent(input, "context", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(result, `<g id="elem_api" onclick="navigateDown()" style="cursor:pointer;"><rect`) {
+		t.Errorf("expected single-quoted link to become an onclick handler, got: %s", result)
+	}
+	if strings.Contains(result, "<a ") {
+		t.Errorf("expected <a> tags to be removed, got: %s", result)
+	}
+	if !strings.Contains(result, `<g id="entity_GMN9" class="entity note">`) {
+		t.Errorf("expected single-quoted note group to be tagged, got: %s", result)
+	}
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -413,7 +482,7 @@ func TestParseArgsSlice(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			inputDir, outputFile, title, err := parseArgsSlice(tt.args)
+			opts, err := parseArgsSlice(tt.args)
 
 			if (err != nil) != tt.expectErr {
 				if tt.expectErr {
@@ -424,104 +493,2351 @@ func TestParseArgsSlice(t *testing.T) {
 			}
 
 			if !tt.expectErr {
-				if inputDir != tt.expectDir {
-					t.Errorf("InputDir: got %q, want %q", inputDir, tt.expectDir)
+				if opts.InputDir != tt.expectDir {
+					t.Errorf("InputDir: got %q, want %q", opts.InputDir, tt.expectDir)
 				}
 
-				if outputFile != tt.expectOutput {
-					t.Errorf("OutputFile: got %q, want %q", outputFile, tt.expectOutput)
+				if opts.OutputFile != tt.expectOutput {
+					t.Errorf("OutputFile: got %q, want %q", opts.OutputFile, tt.expectOutput)
 				}
 
-				if title != tt.expectTitle {
-					t.Errorf("Title: got %q, want %q", title, tt.expectTitle)
+				if opts.Title != tt.expectTitle {
+					t.Errorf("Title: got %q, want %q", opts.Title, tt.expectTitle)
 				}
 			}
 		})
 	}
 }
 
-// TestValidateXML tests XML validation
-func TestValidateXML(t *testing.T) {
+// TestParseArgsSliceToggleFlags tests the flags that omit the toggle buttons
+func TestParseArgsSliceToggleFlags(t *testing.T) {
 	tests := []struct {
-		name      string
-		content   string
-		expectErr bool
+		name        string
+		args        []string
+		expectNotes bool
+		expectFit   bool
 	}{
-		{
-			name:      "valid simple XML",
-			content:   `<root><item>test</item></root>`,
-			expectErr: false,
-		},
-		{
-			name:      "valid SVG snippet",
-			content:   `<g><rect x="0" y="0"/></g>`,
-			expectErr: false,
-		},
-		{
-			name:      "invalid XML - missing close tag",
-			content:   `<root><item>test</root>`,
-			expectErr: true,
-		},
-		{
-			name:      "invalid XML - malformed tag",
-			content:   `<root><item test>content</item></root>`,
-			expectErr: true,
-		},
-		{
-			name:      "invalid XML - unclosed tag",
-			content:   `<root><item>`,
-			expectErr: true,
-		},
-		{
-			name:      "valid XML with attributes",
-			content:   `<svg xmlns="http://www.w3.org/2000/svg"><rect width="100"/></svg>`,
-			expectErr: false,
-		},
+		{"defaults", []string{"./examples"}, false, false},
+		{"no notes toggle", []string{"./examples", "--no-notes-toggle"}, true, false},
+		{"no fit toggle", []string{"./examples", "--no-fit-toggle"}, false, true},
+		{"minimal ui", []string{"./examples", "--minimal-ui"}, true, true},
+	}
+
+	if opts, err := parseArgsSlice([]string{"./examples", "--keep-links"}); err != nil || !opts.KeepLinks {
+		t.Errorf("--keep-links: got %v, %v", opts.KeepLinks, err)
+	}
+	if opts, err := parseArgsSlice([]string{"./examples", "--no-source-background"}); err != nil || !opts.NoSourceBackground {
+		t.Errorf("--no-source-background: got %v, %v", opts.NoSourceBackground, err)
+	}
+	if opts, err := parseArgsSlice([]string{"./examples", "--rtl"}); err != nil || !opts.RTL {
+		t.Errorf("--rtl: got %v, %v", opts.RTL, err)
+	}
+	if opts, err := parseArgsSlice([]string{"./examples", "--legend"}); err != nil || !opts.Legend {
+		t.Errorf("--legend: got %v, %v", opts.Legend, err)
+	}
+	if opts, err := parseArgsSlice([]string{"./examples", "--overview"}); err != nil || !opts.Overview {
+		t.Errorf("--overview: got %v, %v", opts.Overview, err)
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := ValidateXML(tt.content)
+			opts, err := parseArgsSlice(tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opts.NoNotesToggle != tt.expectNotes {
+				t.Errorf("NoNotesToggle: got %v, want %v", opts.NoNotesToggle, tt.expectNotes)
+			}
+			if opts.NoFitToggle != tt.expectFit {
+				t.Errorf("NoFitToggle: got %v, want %v", opts.NoFitToggle, tt.expectFit)
+			}
+		})
+	}
+}
 
-			if (err != nil) != tt.expectErr {
-				if tt.expectErr {
-					t.Errorf("expected error, got nil")
-				} else {
-					t.Errorf("expected no error, got %v", err)
-				}
+// TestOutputDir tests that --output-dir names the file after the title
+func TestOutputDir(t *testing.T) {
+	if _, err := parseArgsSlice([]string{"./examples", "--output", "a.svg", "--output-dir", "out"}); err == nil {
+		t.Errorf("expected error when combining --output and --output-dir")
+	}
+
+	tests := []struct {
+		title  string
+		expect string
+	}{
+		{"", "stacked-c4-architecture.svg"},
+		{"My System: Overview", "my-system-overview.svg"},
+		{"🏗️", "stacked-c4-architecture.svg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			stacker := NewSVGStackerWithOptions(Options{InputDir: "./examples", OutputDir: "out", Title: tt.title})
+			if want := filepath.Join("out", tt.expect); stacker.outputFile != want {
+				t.Errorf("got %q, want %q", stacker.outputFile, want)
 			}
 		})
 	}
 }
 
-// TestExtractLevel tests the level extraction from filenames
-func TestExtractLevel(t *testing.T) {
+// TestOutputCreatesParentDirs tests that --output into a missing directory creates it
+func TestOutputCreatesParentDirs(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g></g></svg>`
+	if err := os.WriteFile(filepath.Join(dir, "context.svg"), []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(t.TempDir(), "docs", "generated", "stack.svg")
+	if err := NewSVGStacker(dir, output, "").CreateStackedSVG(); err != nil {
+		t.Fatalf("CreateStackedSVG failed: %v", err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("expected %s to be written: %v", output, err)
+	}
+}
+
+// TestStats tests the --stats summary
+func TestStats(t *testing.T) {
+	dir := t.TempDir()
+	small := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g></g></svg>`
+	large := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g><rect width="10" height="10"/><rect width="20" height="20"/></g></svg>`
+	os.WriteFile(filepath.Join(dir, "context.svg"), []byte(small), 0644)
+	os.WriteFile(filepath.Join(dir, "container.svg"), []byte(large), 0644)
+
+	stacker := NewSVGStackerWithOptions(Options{InputDir: dir})
+	svg, err := stacker.Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	var buf bytes.Buffer
+	stacker.printStats(&buf, svg, 1500*time.Millisecond)
+	for _, want := range []string{"Levels:    2\n", fmt.Sprintf("Output:    %d bytes\n", len(svg)), "Largest:   container (", "Rendering: 0s\n", "Stacking:  1.5s\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in stats, got:\n%s", want, buf.String())
+		}
+	}
+
+	if opts, err := parseArgsSlice([]string{dir, "--stats"}); err != nil || !opts.Stats {
+		t.Errorf("--stats: got %v, %v", opts.Stats, err)
+	}
+}
+
+// TestOutputStdoutSentinel tests that --output - writes to stdout rather than a file named "-"
+func TestOutputStdoutSentinel(t *testing.T) {
+	opts, err := parseArgsSlice([]string{"./examples", "--output", "-"})
+	if err != nil || opts.OutputFile != "-" {
+		t.Fatalf("--output -: got %q, %v", opts.OutputFile, err)
+	}
+
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g></g></svg>`
+	if err := os.WriteFile(filepath.Join(dir, "context.svg"), []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	captured := make(chan []byte)
+	go func() {
+		output, _ := io.ReadAll(r)
+		captured <- output
+	}()
+	err = NewSVGStacker(".", "-", "").CreateStackedSVG()
+	w.Close()
+	os.Stdout = stdout
+	output := <-captured
+	if err != nil {
+		t.Fatalf("CreateStackedSVG failed: %v", err)
+	}
+
+	if !strings.Contains(string(output), `id="layer-context"`) {
+		t.Errorf("expected the stacked SVG on stdout")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "-")); !os.IsNotExist(err) {
+		t.Errorf("expected no file named \"-\" to be written")
+	}
+}
+
+// TestTitleFile tests reading the title from a file with --title-file
+func TestTitleFile(t *testing.T) {
+	dir := t.TempDir()
+	version := filepath.Join(dir, "VERSION")
+	if err := os.WriteFile(version, []byte("\ufeff\n  Release <1.2> & \"friends\"  \r\nsecond line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "EMPTY")
+	if err := os.WriteFile(empty, []byte("\n \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgsSlice([]string{dir, "--title-file", version})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.Title != `Release <1.2> & "friends"` {
+		t.Errorf("got title %q", opts.Title)
+	}
+
+	for _, args := range [][]string{
+		{dir, "--title-file", version, "--title", "Inline"},
+		{dir, "--title-file", empty},
+		{dir, "--title-file", filepath.Join(dir, "missing")},
+	} {
+		if _, err := parseArgsSlice(args); err == nil {
+			t.Errorf("%v: expected an error", args[1:])
+		}
+	}
+}
+
+// TestEnvDefaults tests that SVG_STACKER_* variables fill in options not given as flags
+func TestEnvDefaults(t *testing.T) {
+	t.Setenv(envTitle, "From Env")
+	t.Setenv(envOutput, "env.svg")
+
 	tests := []struct {
-		filename  string
-		expectLvl string
+		name   string
+		args   []string
+		title  string
+		output string
 	}{
-		{"01-context.svg", "context"},
-		{"02-container.svg", "container"},
-		{"03-component.svg", "component"},
-		{"04-code.svg", "code"},
-		{"Context-Diagram.svg", "context"},
-		{"CONTAINER.svg", "container"},
-		{"component-diagram.svg", "component"},
-		{"CODE_LEVEL.svg", "code"},
-		{"unknown.svg", "unknown"},
-		{"random-file.txt", "unknown"},
+		{"env only", []string{"dir"}, "From Env", "env.svg"},
+		{"flags win", []string{"dir", "--title", "Flag", "--output", "flag.svg"}, "Flag", "flag.svg"},
+		{"output dir wins", []string{"dir", "--output-dir", "docs"}, "From Env", ""},
+		{"if-changed uses env output", []string{"dir", "--if-changed"}, "From Env", "env.svg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseArgsSlice(tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opts.Title != tt.title || opts.OutputFile != tt.output {
+				t.Errorf("got title %q output %q, want %q %q", opts.Title, opts.OutputFile, tt.title, tt.output)
+			}
+		})
 	}
 
-	stacker := &SVGStacker{}
+	// serve and diff don't write a file, so they ignore SVG_STACKER_OUTPUT
+	if opts, err := parseServeArgs([]string{"--dir", "docs/c4"}); err != nil || opts.Stacker.OutputFile != "" || opts.Stacker.Title != "From Env" {
+		t.Errorf("serve: got %+v, %v", opts.Stacker, err)
+	}
+	if opts, err := parseDiffArgs([]string{"old.svg", "--dir", "docs/c4"}); err != nil || opts.Stacker.OutputFile != "" {
+		t.Errorf("diff: got %+v, %v", opts.Stacker, err)
+	}
+	if _, err := parseServeArgs([]string{"--dir", "docs/c4", "--output", "x.svg"}); err == nil {
+		t.Errorf("expected --output to still be rejected by serve")
+	}
+	if _, err := parseServeArgs([]string{"--dir", "docs/c4", "--output", "env.svg"}); err == nil {
+		t.Errorf("expected --output matching SVG_STACKER_OUTPUT to be rejected by serve")
+	}
+}
+
+// TestExpandResponseFiles tests splicing @file arguments into the argument list
+func TestExpandResponseFiles(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args.txt")
+	if err := os.WriteFile(argsFile, []byte("--title  Architecture\n--minimal-ui\n\n  --output out.svg\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := expandResponseFiles([]string{"./examples", "@" + argsFile, "--keep-links"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts, err := parseArgsSlice(args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.InputDir != "./examples" || opts.Title != "Architecture" || !opts.NoFitToggle || opts.OutputFile != "out.svg" || !opts.KeepLinks {
+		t.Errorf("response file not expanded in place: %+v", opts)
+	}
+
+	if _, err := expandResponseFiles([]string{"./examples", "@" + filepath.Join(dir, "missing.txt")}); err == nil {
+		t.Errorf("expected error for a missing response file")
+	}
+
+	// A subcommand and its flags can come from a response file, or follow it
+	defer func(l *Logger) { logger = l }(logger)
+	logger = &Logger{level: LogNormal, out: io.Discard}
+	empty := t.TempDir()
+	lintFile := filepath.Join(dir, "lint.txt")
+	if err := os.WriteFile(lintFile, []byte("lint "+empty+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, code := parseCommandLine([]string{"@" + lintFile}); code != exitLintErrors {
+		t.Errorf("expected lint from a response file to find errors, got exit code %d", code)
+	}
+	if err := os.WriteFile(lintFile, []byte(empty), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, code := parseCommandLine([]string{"lint", "@" + lintFile}); code != exitLintErrors {
+		t.Errorf("expected a response file after lint to be expanded, got exit code %d", code)
+	}
+
+	// A lone "@" is an ordinary argument
+	if got, _ := expandResponseFiles([]string{"@"}); len(got) != 1 || got[0] != "@" {
+		t.Errorf("expected a lone @ to be kept, got %q", got)
+	}
+}
+
+// TestParseSize tests parsing of --max-file-size values
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value     string
+		expected  int64
+		expectErr bool
+	}{
+		{"1024", 1024, false},
+		{"500KB", 500 << 10, false},
+		{"100mb", 100 << 20, false},
+		{"2 GB", 2 << 30, false},
+		{"10B", 10, false},
+		{"0", 0, true},
+		{"-5MB", 0, true},
+		{"lots", 0, true},
+	}
 
 	for _, tt := range tests {
-		t.Run(tt.filename, func(t *testing.T) {
-			result := stacker.extractLevel(tt.filename)
-			if result != tt.expectLvl {
-				t.Errorf("got %q, want %q", result, tt.expectLvl)
+		t.Run(tt.value, func(t *testing.T) {
+			n, err := parseSize(tt.value)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("error: got %v, expectErr %v", err, tt.expectErr)
+			}
+			if n != tt.expected {
+				t.Errorf("got %d, want %d", n, tt.expected)
+			}
+		})
+	}
+}
+
+// TestMaxFileSize tests that oversized source SVGs are rejected by name before being read
+func TestMaxFileSize(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g></g></svg>`
+	file := filepath.Join(dir, "context.svg")
+	if err := os.WriteFile(file, []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stacker := NewSVGStackerWithOptions(Options{InputDir: dir, MaxFileSize: 50})
+	err := stacker.loadDiagrams(context.Background())
+	if err == nil || !strings.Contains(err.Error(), file) || !strings.Contains(err.Error(), "--max-file-size") {
+		t.Errorf("expected an error naming %s, got %v", file, err)
+	}
+
+	stacker = NewSVGStackerWithOptions(Options{InputDir: dir, MaxFileSize: int64(len(svg))})
+	if err := stacker.loadDiagrams(context.Background()); err != nil {
+		t.Errorf("file at the limit should load: %v", err)
+	}
+
+	if opts, err := parseArgsSlice([]string{"./examples", "--max-file-size", "10MB"}); err != nil || opts.MaxFileSize != 10<<20 {
+		t.Errorf("--max-file-size 10MB: got %d, %v", opts.MaxFileSize, err)
+	}
+}
+
+// TestLoadDiagramsGzip tests that gzip-compressed files named .svg are decompressed
+func TestLoadDiagramsGzip(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g id="zipped">` +
+		strings.Repeat("<rect/>", 100) + `</g></svg>`
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(svg))
+	gz.Close()
+	if err := os.WriteFile(filepath.Join(dir, "context.svg"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stacker := NewSVGStackerWithOptions(Options{InputDir: dir})
+	if err := stacker.loadDiagrams(context.Background()); err != nil {
+		t.Fatalf("loadDiagrams failed: %v", err)
+	}
+	if !strings.Contains(stacker.diagrams["context"].content, `id="zipped"`) {
+		t.Errorf("expected the decompressed content, got %q", stacker.diagrams["context"].content)
+	}
+
+	// The limit applies to the decompressed size too
+	stacker = NewSVGStackerWithOptions(Options{InputDir: dir, MaxFileSize: int64(len(svg)) - 1})
+	if err := stacker.loadDiagrams(context.Background()); err == nil || !strings.Contains(err.Error(), "--max-file-size") {
+		t.Errorf("expected the decompressed size to be limited, got %v", err)
+	}
+}
+
+// TestLoadDiagramsBOM tests that a UTF-8 byte-order mark is stripped
+func TestLoadDiagramsBOM(t *testing.T) {
+	dir := t.TempDir()
+	svg := "\ufeff<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
+		`<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g id="bom"></g></svg>`
+	if err := os.WriteFile(filepath.Join(dir, "context.svg"), []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := NewSVGStackerWithOptions(Options{InputDir: dir}).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if strings.Contains(output, "\ufeff") {
+		t.Errorf("expected the byte-order mark to be stripped")
+	}
+	if !strings.Contains(output, `id="bom"`) {
+		t.Errorf("expected the diagram in the output")
+	}
+}
+
+// TestLoadDiagramsCRLF tests that Windows line endings are handled like LF
+func TestLoadDiagramsCRLF(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50">
+<script type="text/javascript"><![CDATA[
+  if (a < b) {
+    alert(1);
+  }
+]]></script>
+<g id="body"><text><![CDATA[line one
+line two]]></text></g>
+</svg>`
+
+	load := func(content string) string {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "context.svg"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		stacker := NewSVGStackerWithOptions(Options{InputDir: dir})
+		if err := stacker.loadDiagrams(context.Background()); err != nil {
+			t.Fatalf("loadDiagrams failed: %v", err)
+		}
+		return stacker.diagrams["context"].content
+	}
+
+	lf := load(svg)
+	crlf := load(strings.ReplaceAll(svg, "\n", "\r\n"))
+	if crlf != lf {
+		t.Errorf("CRLF input gave different content:\n%q\nwant:\n%q", crlf, lf)
+	}
+	if strings.Contains(crlf, "<script") || strings.Contains(crlf, "alert") {
+		t.Errorf("expected the multi-line script to be removed, got %q", crlf)
+	}
+}
+
+// TestAspectRatioWarning tests the warning for elongated diagrams
+func TestAspectRatioWarning(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, width, height int) {
+		svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d"><g/></svg>`, width, height, width, height)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("context.svg", 400, 300)
+	write("component.svg", 3000, 200)
+
+	var buf bytes.Buffer
+	defer func(l *Logger) { logger = l }(logger)
+	logger = &Logger{level: LogNormal, out: &buf}
+
+	if err := NewSVGStackerWithOptions(Options{InputDir: dir}).loadDiagrams(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "component.svg has an aspect ratio of 15.00") || strings.Contains(buf.String(), "context.svg") {
+		t.Errorf("expected a warning for component.svg only, got %q", buf.String())
+	}
+
+	buf.Reset()
+	opts, err := parseArgsSlice([]string{dir, "--aspect-warn", "0.1:20"})
+	if err != nil || opts.AspectWarnRange != [2]float64{0.1, 20} {
+		t.Fatalf("--aspect-warn: got %v, %v", opts.AspectWarnRange, err)
+	}
+	if err := NewSVGStackerWithOptions(opts).loadDiagrams(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no warning within the range, got %q", buf.String())
+	}
+
+	for _, bad := range []string{"5", "5:0.2", "0:5", "a:b"} {
+		if _, err := parseAspectRange(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+// TestIncludeExclude tests selecting input files by glob
+func TestIncludeExclude(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g/></svg>`
+	for _, name := range []string{"01-context.svg", "02-container.svg", "03-component-draft.svg", "code-icons.svg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		expect  string
+	}{
+		{"everything", nil, nil, "code,component,container,context"},
+		{"exclude", nil, []string{"*-draft.svg", "*icons*"}, "container,context"},
+		{"include", []string{"0[12]-*"}, nil, "container,context"},
+		{"include and exclude", []string{"0*"}, []string{"02-*"}, "component,context"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stacker := NewSVGStackerWithOptions(Options{InputDir: dir, Include: tt.include, Exclude: tt.exclude})
+			if err := stacker.loadDiagrams(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			levels := make([]string, 0, len(stacker.diagrams))
+			for level := range stacker.diagrams {
+				levels = append(levels, level)
+			}
+			sort.Strings(levels)
+			if got := strings.Join(levels, ","); got != tt.expect {
+				t.Errorf("got %s, want %s", got, tt.expect)
 			}
 		})
 	}
+
+	opts, err := parseArgsSlice([]string{dir, "--include", "0*", "--exclude", "*draft*", "--exclude", "*icons*"})
+	if err != nil || strings.Join(opts.Include, ",") != "0*" || strings.Join(opts.Exclude, ",") != "*draft*,*icons*" {
+		t.Errorf("got %v %v, %v", opts.Include, opts.Exclude, err)
+	}
+	if _, err := parseArgsSlice([]string{dir, "--exclude", "[bad"}); err == nil {
+		t.Errorf("expected an error for a malformed pattern")
+	}
+}
+
+// TestIgnoreFile tests skipping the .svg and .puml files listed in .svg-stackerignore
+func TestIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g></g></svg>`
+	files := map[string]string{
+		"01-context.svg":         svg,
+		"02-container.svg":       svg,
+		"03-component-icons.svg": svg,
+		"04-code.puml":           "@startuml\n@enduml\n",
+		ignoreFileName:           "# not diagrams\n*-icons.svg\n\n*.puml\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The .puml is ignored, so nothing needs plantuml
+	stacker := NewSVGStackerWithOptions(Options{InputDir: dir})
+	if err := stacker.loadInput(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	levels := make([]string, 0, len(stacker.diagrams))
+	for level := range stacker.diagrams {
+		levels = append(levels, level)
+	}
+	sort.Strings(levels)
+	if got := strings.Join(levels, ","); got != "container,context" {
+		t.Errorf("got %s, want container,context", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ignoreFileName), []byte("[bad\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := NewSVGStackerWithOptions(Options{InputDir: dir}).loadInput(context.Background())
+	if err == nil || !strings.Contains(err.Error(), ignoreFileName+":1") {
+		t.Errorf("expected an error naming the bad line, got %v", err)
+	}
+}
+
+// TestScale tests that --scale multiplies the native sizes the script and
+// --conformant use, globally and per level
+func TestScale(t *testing.T) {
+	dir := t.TempDir()
+	createTestSVGFiles(t, dir)
+
+	opts, err := parseArgsSlice([]string{dir, "--scale", "2", "--scale", "container=0.5", "--conformant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Scale != 2 || opts.LevelScales["container"] != 0.5 {
+		t.Fatalf("got %g %v", opts.Scale, opts.LevelScales)
+	}
+	output, err := NewSVGStackerWithOptions(opts).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	// 400x300 context doubled, 500x400 container halved; the viewBoxes are unchanged
+	for _, want := range []string{
+		`'context': { width: 800, height: 600, ratio: 1.3333333333333333 }`,
+		`'container': { width: 250, height: 200, ratio: 1.25 }`,
+		`<svg viewBox="0 0 400 300" x="10" y="150" width="800" height="600"`,
+		`<svg viewBox="0 0 500 400" x="10" y="150" width="250" height="200"`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %s in output", want)
+		}
+	}
+
+	for _, bad := range []string{"0", "-1", "big", "deployment=2", "context="} {
+		if _, err := parseArgsSlice([]string{dir, "--scale", bad}); err == nil {
+			t.Errorf("--scale %s: expected an error", bad)
+		}
+	}
+}
+
+// TestOnly tests that --only stacks just the listed levels
+func TestOnly(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g></g></svg>`
+	for _, name := range []string{"context.svg", "container.svg", "component.svg"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts, err := parseArgsSlice([]string{dir, "--only", "Context, container"})
+	if err != nil || !reflect.DeepEqual(opts.Only, []string{"Context", "container"}) {
+		t.Fatalf("--only: got %v, %v", opts.Only, err)
+	}
+	out, err := NewSVGStackerWithOptions(opts).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	for id, want := range map[string]bool{"diagram-context": true, "diagram-container": true, "diagram-component": false} {
+		if got := strings.Contains(out, `id="`+id+`"`); got != want {
+			t.Errorf("%s: expected present %v, got %v", id, want, got)
+		}
+	}
+
+	_, err = NewSVGStackerWithOptions(Options{InputDir: dir, Only: []string{"context", "code"}}).Generate()
+	if exitCodeFor(err) != exitNoInputs || !strings.Contains(err.Error(), `"code"`) {
+		t.Errorf("expected a missing level to fail with %d naming it, got %v", exitNoInputs, err)
+	}
+}
+
+// TestIfChanged tests that --if-changed only rewrites the output when more than the timestamp differs
+func TestIfChanged(t *testing.T) {
+	if _, err := parseArgsSlice([]string{"./examples", "--if-changed"}); err == nil {
+		t.Errorf("expected --if-changed without an output file to be rejected")
+	}
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in")
+	if err := os.Mkdir(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeSVG := func(id string) {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g id="` + id + `"></g></svg>`
+		if err := os.WriteFile(filepath.Join(input, "context.svg"), []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(dir, "out.svg")
+	generate := func() {
+		t.Helper()
+		if err := NewSVGStackerWithOptions(Options{InputDir: input, OutputFile: output, IfChanged: true}).CreateStackedSVG(); err != nil {
+			t.Fatalf("CreateStackedSVG failed: %v", err)
+		}
+	}
+
+	writeSVG("first")
+	generate()
+	// Stand in for an older run: same content, different timestamp
+	data, _ := os.ReadFile(output)
+	stale := regexp.MustCompile(`<timestamp>[^<]*</timestamp>`).ReplaceAllString(string(data), "<timestamp>2000-01-01T00:00:00Z</timestamp>")
+	if hash := contentHash(stale); hash != contentHash(string(data)) || !strings.HasPrefix(hash, "sha256:") {
+		t.Errorf("expected the hash to ignore the timestamp, got %q", hash)
+	}
+	if err := os.WriteFile(output, []byte(stale), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	generate()
+	if data, _ := os.ReadFile(output); string(data) != stale {
+		t.Errorf("expected an unchanged diagram not to rewrite the output")
+	}
+
+	writeSVG("second")
+	generate()
+	if data, _ := os.ReadFile(output); !strings.Contains(string(data), `id="second"`) {
+		t.Errorf("expected a changed diagram to rewrite the output")
+	}
+}
+
+// TestParseTimeout tests parsing of --plantuml-timeout and prompt --timeout values
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		value     string
+		expected  time.Duration
+		expectErr bool
+	}{
+		{"60s", 60 * time.Second, false},
+		{"2m", 2 * time.Minute, false},
+		{"0s", 0, true},
+		{"-5s", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			d, err := parseTimeout(tt.value)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("expectErr %v, got %v", tt.expectErr, err)
+			}
+			if d != tt.expected {
+				t.Errorf("got %s, want %s", d, tt.expected)
+			}
+		})
+	}
+
+	opts, err := parseArgsSlice([]string{"./examples", "--plantuml-timeout", "90s"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.PlantUMLTimeout != 90*time.Second {
+		t.Errorf("PlantUMLTimeout: got %s, want 90s", opts.PlantUMLTimeout)
+	}
+
+	promptOpts, err := parsePromptArgs(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if promptOpts.Timeout != defaultLLMTimeout {
+		t.Errorf("prompt Timeout: got %s, want default %s", promptOpts.Timeout, defaultLLMTimeout)
+	}
+}
+
+// TestBuildPrompt tests that the assembled prompt includes the spec and project context
+func TestBuildPrompt(t *testing.T) {
+	ctx := ProjectContext{
+		Name:       "demo",
+		ReadmeSnip: "# Demo\nA demo project",
+		Languages:  []string{"Go"},
+		MainFiles:  []string{"go.mod"},
+	}
+
+	prompt := buildPrompt(ctx, defaultC4Dir, c4DiagramSpec)
+
+	expected := []string{
+		c4DiagramSpec,
+		"Project Name: demo",
+		"A demo project",
+		"Primary languages: Go",
+		"Key files/directories: go.mod",
+		"docs/c4/",
+	}
+	for _, s := range expected {
+		if !strings.Contains(prompt, s) {
+			t.Errorf("prompt missing %q", s)
+		}
+	}
+
+	if custom := buildPrompt(ctx, "architecture/", c4DiagramSpec); !strings.Contains(custom, "saved to: architecture/") {
+		t.Errorf("prompt should use the configured C4 directory")
+	}
+
+	opts, err := parsePromptArgs([]string{"--print-only", "--c4-dir", "architecture/", "--prompt-out", "prompt.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !opts.PrintOnly {
+		t.Errorf("expected PrintOnly to be set")
+	}
+	if opts.C4Dir != "architecture/" {
+		t.Errorf("C4Dir: got %q, want %q", opts.C4Dir, "architecture/")
+	}
+	if opts.PromptOut != "prompt.txt" {
+		t.Errorf("PromptOut: got %q, want %q", opts.PromptOut, "prompt.txt")
+	}
+	if opts.Spec != c4DiagramSpec {
+		t.Errorf("expected the C4 spec by default")
+	}
+}
+
+// TestExistingDiagramsInPrompt tests that diagrams from an earlier run are included for updating
+func TestExistingDiagramsInPrompt(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"02-container.puml": "@startuml\nContainer(api, \"API\")\n@enduml\n",
+		"01-context.puml":   "@startuml\nSystem(shop, \"Shop\")\n@enduml\n",
+		"empty.puml":        "  \n",
+		"large.puml":        strings.Repeat("x", maxExistingDiagramBytes+10),
+		"notes.txt":         "not a diagram",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diagrams := existingDiagrams(dir)
+	var names []string
+	for _, d := range diagrams {
+		names = append(names, d.Name)
+	}
+	if strings.Join(names, ",") != "01-context.puml,02-container.puml,large.puml" {
+		t.Fatalf("got %v", names)
+	}
+	if large := diagrams[2].Content; len(large) > maxExistingDiagramBytes+20 || !strings.HasSuffix(large, "(truncated)") {
+		t.Errorf("expected the large diagram to be truncated, got %d bytes", len(large))
+	}
+
+	prompt := buildPrompt(ProjectContext{Name: "shop", ExistingDiagrams: diagrams[:2]}, dir, c4DiagramSpec)
+	for _, want := range []string{"EXISTING DIAGRAMS", "rather than replacing them", "01-context.puml:\n```plantuml\n@startuml\nSystem(shop", "update the existing diagrams"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q", want)
+		}
+	}
+	if strings.Index(prompt, "01-context.puml:") > strings.Index(prompt, "02-container.puml:") {
+		t.Errorf("expected the diagrams in name order")
+	}
+
+	if fresh := buildPrompt(ProjectContext{Name: "shop"}, dir, c4DiagramSpec); strings.Contains(fresh, "EXISTING DIAGRAMS") {
+		t.Errorf("expected no existing diagrams section without any")
+	}
+}
+
+// TestLoadSpec tests selecting the prompt's diagram specification
+func TestLoadSpec(t *testing.T) {
+	for _, name := range specNames() {
+		spec, err := loadSpec(name)
+		if err != nil || strings.TrimSpace(spec) == "" {
+			t.Errorf("%s: got %d bytes, %v", name, len(spec), err)
+		}
+	}
+	if arc42, _ := loadSpec("arc42"); !strings.Contains(arc42, "Building Block View") {
+		t.Errorf("expected the arc42 spec")
+	}
+
+	file := filepath.Join(t.TempDir(), "team.md")
+	os.WriteFile(file, []byte("# Team conventions\nUse our own shapes.\n"), 0644)
+	opts, err := parsePromptArgs([]string{"--spec", file})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prompt := buildPrompt(ProjectContext{Name: "demo"}, defaultC4Dir, opts.Spec); !strings.Contains(prompt, "Use our own shapes.") || strings.Contains(prompt, c4DiagramSpec) {
+		t.Errorf("expected the custom spec in place of the C4 spec")
+	}
+
+	if _, err := loadSpec("no-such-spec"); err == nil || !strings.Contains(err.Error(), "arc42") {
+		t.Errorf("expected an error listing the built-in specs, got %v", err)
+	}
+}
+
+// TestDetectLanguages tests that extensions are counted across the tree, skipping ignored dirs
+func TestDetectLanguages(t *testing.T) {
+	root := t.TempDir()
+	files := []string{
+		"README.md",
+		"src/a.ts", "src/b.ts", "src/lib/c.ts",
+		"src/d.go", "src/e.go",
+		"scripts/f.py",
+		"node_modules/pkg/1.js", "node_modules/pkg/2.js", "node_modules/pkg/3.js", "node_modules/pkg/4.js",
+		"vendor/x/1.c", "vendor/x/2.c", "vendor/x/3.c", "vendor/x/4.c",
+		".git/objects/1.pack", ".git/objects/2.pack", ".git/objects/3.pack", ".git/objects/4.pack",
+	}
+	for _, f := range files {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+	}
+
+	got := detectLanguages(root)
+	want := []string{"TypeScript", "Go", "Markdown"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestLanguageName tests extension to language mapping with raw fallback
+func TestLanguageName(t *testing.T) {
+	tests := []struct {
+		ext      string
+		expected string
+	}{
+		{".go", "Go"},
+		{".tsx", "TypeScript"},
+		{".PY", "Python"},
+		{".xyz", ".xyz"},
+	}
+
+	for _, tt := range tests {
+		if got := languageName(tt.ext); got != tt.expected {
+			t.Errorf("languageName(%q): got %q, want %q", tt.ext, got, tt.expected)
+		}
+	}
+}
+
+// TestDetectDependencies tests reading go.mod, package.json and requirements.txt
+func TestDetectDependencies(t *testing.T) {
+	root := t.TempDir()
+	manifests := map[string]string{
+		"go.mod": `module example.com/shop
+
+go 1.22
+
+require github.com/gin-gonic/gin v1.9.1
+
+require (
+	github.com/lib/pq v1.10.9
+	golang.org/x/sys v0.15.0 // indirect
+)
+`,
+		"package.json":     `{"dependencies": {"react": "^18.0.0", "axios": "^1.0.0"}, "devDependencies": {"jest": "^29.0.0"}}`,
+		"requirements.txt": "# web\nflask==3.0.0\nrequests[socks]>=2.31\n-r other.txt\n",
+	}
+	for name, content := range manifests {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	module, deps := detectDependencies(root)
+
+	if module != "example.com/shop" {
+		t.Errorf("module: got %q, want %q", module, "example.com/shop")
+	}
+	want := []string{"github.com/gin-gonic/gin", "github.com/lib/pq", "axios", "react", "flask", "requests"}
+	if strings.Join(deps, ",") != strings.Join(want, ",") {
+		t.Errorf("deps: got %v, want %v", deps, want)
+	}
+}
+
+// TestReadmeSnippet tests that README badges are skipped in favour of prose
+func TestReadmeSnippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		maxLines int
+		expected string
+	}{
+		{
+			name:     "skips badges and images",
+			content:  "# Shop\n\n[![Build](https://ci/badge.svg)](https://ci)\n![logo](logo.png)\n\nAn online shop.\nSells things.\n\n## Install\n",
+			maxLines: 10,
+			expected: "# Shop\n\nAn online shop.\nSells things.",
+		},
+		{
+			name:     "skips html wrappers",
+			content:  "# Tool\n<p align=\"center\">\n  <img src=\"x.png\">\n</p>\n\nDoes a thing.\n",
+			maxLines: 10,
+			expected: "# Tool\n\nDoes a thing.",
+		},
+		{
+			name:     "truncates to max lines",
+			content:  "# Title\n\none\ntwo\nthree\n",
+			maxLines: 3,
+			expected: "# Title\n\none",
+		},
+		{
+			name:     "falls back to leading lines without prose",
+			content:  "# Title\n## Section\n",
+			maxLines: 10,
+			expected: "# Title\n## Section",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readmeSnippet(tt.content, tt.maxLines); got != tt.expected {
+				t.Errorf("got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestCheckGeneratedPuml tests reporting of missing and empty generated diagrams
+func TestCheckGeneratedPuml(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"01-context.puml":   "@startuml\n@enduml\n",
+		"03-component.puml": "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	problems := checkGeneratedPuml(dir)
+
+	want := []string{"02-container.puml was not generated", "03-component.puml is empty"}
+	if strings.Join(problems, "|") != strings.Join(want, "|") {
+		t.Errorf("got %v, want %v", problems, want)
+	}
+}
+
+// TestParseSVGAspectRatio tests that the viewBox wins when it disagrees with width/height,
+// and supplies them when they are missing
+func TestParseSVGAspectRatio(t *testing.T) {
+	stacker := NewSVGStacker("", "", "")
+	tests := []struct {
+		name         string
+		svg          string
+		expectWidth  float64
+		expectHeight float64
+		expectRatio  float64
+	}{
+		{
+			name:         "consistent",
+			svg:          `<svg width="400px" height="200px" viewBox="0 0 400 200"><g/></svg>`,
+			expectWidth:  400,
+			expectHeight: 200,
+			expectRatio:  2,
+		},
+		{
+			name:         "conflicting",
+			svg:          `<svg width="400px" height="400px" viewBox="0 0 800 200"><g/></svg>`,
+			expectWidth:  400,
+			expectHeight: 100,
+			expectRatio:  4,
+		},
+		{
+			name:         "viewBox only",
+			svg:          `<svg viewBox="0 0 1000 250"><g/></svg>`,
+			expectWidth:  1000,
+			expectHeight: 250,
+			expectRatio:  4,
+		},
+		{
+			name:         "percentage size",
+			svg:          `<svg width="100%" height="100%" viewBox="0 0 300 600"><g/></svg>`,
+			expectWidth:  300,
+			expectHeight: 600,
+			expectRatio:  0.5,
+		},
+		{
+			name:         "height only",
+			svg:          `<svg height="100" viewBox="0 0 800 200"><g/></svg>`,
+			expectWidth:  400,
+			expectHeight: 100,
+			expectRatio:  4,
+		},
+		{
+			name:         "no viewBox or size",
+			svg:          `<svg><g/></svg>`,
+			expectWidth:  400,
+			expectHeight: 300,
+			expectRatio:  400.0 / 300,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := stacker.parseSVG(tt.svg, "context")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.width != tt.expectWidth || info.height != tt.expectHeight {
+				t.Errorf("size: got %gx%g, want %gx%g", info.width, info.height, tt.expectWidth, tt.expectHeight)
+			}
+			if info.aspectRatio != tt.expectRatio {
+				t.Errorf("aspectRatio: got %g, want %g", info.aspectRatio, tt.expectRatio)
+			}
+		})
+	}
+}
+
+// TestParseViewBox tests viewBox parsing with the separators SVG allows
+func TestParseViewBox(t *testing.T) {
+	tests := []struct {
+		name      string
+		viewBox   string
+		expect    [4]float64
+		expectErr bool
+	}{
+		{name: "spaces", viewBox: "0 0 400 300", expect: [4]float64{0, 0, 400, 300}},
+		{name: "commas", viewBox: "0,0,400,300", expect: [4]float64{0, 0, 400, 300}},
+		{name: "commas and spaces", viewBox: "0, 0, 400.5, 300", expect: [4]float64{0, 0, 400.5, 300}},
+		{name: "multiple spaces", viewBox: "  0   0\t400 \n 300 ", expect: [4]float64{0, 0, 400, 300}},
+		{name: "leading minus", viewBox: "-10 -20.5 400 300", expect: [4]float64{-10, -20.5, 400, 300}},
+		{name: "too few values", viewBox: "0 0 400", expectErr: true},
+		{name: "not a number", viewBox: "0 0 wide 300", expectErr: true},
+		{name: "zero height", viewBox: "0 0 400 0", expectErr: true},
+		{name: "empty", viewBox: "", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vb, err := parseViewBox(tt.viewBox)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("expectErr %v, got %v", tt.expectErr, err)
+			}
+			if !tt.expectErr && vb != tt.expect {
+				t.Errorf("got %v, want %v", vb, tt.expect)
+			}
+		})
+	}
+}
+
+// TestParseSVGNormalizesViewBox tests that parseSVG stores a canonical viewBox
+func TestParseSVGNormalizesViewBox(t *testing.T) {
+	stacker := NewSVGStacker("", "", "")
+	tests := []struct {
+		svg    string
+		expect string
+	}{
+		{`<svg width="400" height="300" viewBox="0,0,400,300"><g/></svg>`, "0 0 400 300"},
+		{`<svg width="400" height="300" viewBox="bogus"><g/></svg>`, "0 0 400 300"},
+		{`<svg width="200" height="100"><g/></svg>`, "0 0 200 100"},
+	}
+
+	for _, tt := range tests {
+		info, err := stacker.parseSVG(tt.svg, "context")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if info.viewBox != tt.expect {
+			t.Errorf("%s: got viewBox %q, want %q", tt.svg, info.viewBox, tt.expect)
+		}
+	}
+}
+
+// TestParseSVGAttributeSyntax tests single quotes and whitespace around "=" in the <svg> tag
+func TestParseSVGAttributeSyntax(t *testing.T) {
+	stacker := NewSVGStacker("", "", "")
+	tests := []struct {
+		name string
+		svg  string
+	}{
+		{"double quotes", `<svg width="500px" height="250px" viewBox="0 0 500 250"><g/></svg>`},
+		{"single quotes", `<svg width='500px' height='250px' viewBox='0 0 500 250'><g/></svg>`},
+		{"spaced equals", `<svg width = "500px" height ="250px" viewBox= '0 0 500 250'><g/></svg>`},
+		{"multi-line tag", "<svg\n  width =\n  \"500px\"\n  height=\"250px\"\n  viewBox=\"0 0 500 250\"><g/></svg>"},
+		{"stroke-width is not width", `<svg stroke-width="3" width="500px" height="250px" viewBox="0 0 500 250"><g/></svg>`},
+		{"uppercase tags", `<SVG width="500px" height="250px" viewBox="0 0 500 250"><g/></SVG>`},
+		{"mixed-case tags", `<Svg width="500px" height="250px" viewBox="0 0 500 250"><g/></sVg >`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := stacker.parseSVG(tt.svg, "context")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.width != 500 || info.height != 250 {
+				t.Errorf("size: got %gx%g, want 500x250", info.width, info.height)
+			}
+			if info.viewBox != "0 0 500 250" {
+				t.Errorf("viewBox: got %q", info.viewBox)
+			}
+			if info.content != "<g></g>" {
+				t.Errorf("content: got %q", info.content)
+			}
+		})
+	}
+
+	// A <svgfoo> element is not an <svg> tag
+	if _, err := stacker.parseSVG(`<svgfoo width="5"></svgfoo>`, "context"); err == nil || err.Error() != "no SVG element" {
+		t.Errorf("expected no SVG element, got %v", err)
+	}
+	if _, err := stacker.parseSVG(`<SVG width="5"><g/>`, "context"); err == nil || err.Error() != "no </svg> tag" {
+		t.Errorf("expected a missing closing tag error, got %v", err)
+	}
+}
+
+// TestParseSVGNoClean tests that --no-clean embeds the content untouched
+func TestParseSVGNoClean(t *testing.T) {
+	body := `<script>alert(1)</script><g style="x" id="e"><a href="02-container.svg"><rect/></a></g>`
+	svg := `<svg width="10" height="10" viewBox="0 0 10 10">` + body + `</svg>`
+
+	info, err := NewSVGStackerWithOptions(Options{NoClean: true}).parseSVG(svg, "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.content != body {
+		t.Errorf("got %q, want %q", info.content, body)
+	}
+
+	cleaned, err := NewSVGStacker("", "", "").parseSVG(svg, "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cleaned.content == body || strings.Contains(cleaned.content, "<script") {
+		t.Errorf("expected the content to be cleaned without --no-clean, got %q", cleaned.content)
+	}
+
+	if opts, err := parseArgsSlice([]string{"./examples", "--no-clean"}); err != nil || !opts.NoClean {
+		t.Errorf("--no-clean: got %v, %v", opts.NoClean, err)
+	}
+}
+
+// TestContentTransform tests that the hook sees the cleaned content of each level
+func TestContentTransform(t *testing.T) {
+	var seen []string
+	stacker := NewSVGStackerWithOptions(Options{
+		InputDir: "testdata",
+		ContentTransform: func(level, content string) string {
+			seen = append(seen, level)
+			if strings.Contains(content, "<script") {
+				t.Errorf("%s: expected cleaned content, got %q", level, content)
+			}
+			return `<g class="annotated-` + level + `">` + content + `</g>`
+		},
+	})
+	svg, err := stacker.Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+
+	sort.Strings(seen)
+	if strings.Join(seen, ",") != "container,context" {
+		t.Errorf("expected the hook to run once per level, got %v", seen)
+	}
+	for _, want := range []string{`class="annotated-context"`, `class="annotated-container"`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("expected %s in the output", want)
+		}
+	}
+}
+
+// TestSourceBackground tests detection of a diagram's own background fill
+func TestSourceBackground(t *testing.T) {
+	vb := [4]float64{0, 0, 400, 300}
+	tests := []struct {
+		name    string
+		svgTag  string
+		content string
+		expect  string
+	}{
+		{"full-canvas rect", `<svg>`, `<rect fill="#1E1E1E" height="300" width="400" x="0" y="0"/><rect fill="#FFF" width="10" height="10"/>`, "#1E1E1E"},
+		{"percent size", `<svg>`, `<rect width="100%" height="100%" fill="black"/>`, "black"},
+		{"oversized rect", `<svg>`, `<rect x="-1" y="-1" width="402.5" height="301" fill="navy"/>`, "navy"},
+		{"partial rect", `<svg>`, `<rect x="10" y="10" width="380" height="280" fill="white"/>`, ""},
+		{"unfilled rect", `<svg>`, `<rect width="400" height="300" fill="none"/>`, ""},
+		{"svg style", `<svg style="width:400px;height:300px;background:#222222;">`, `<g/>`, "#222222"},
+		{"none", `<svg>`, `<g/>`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sourceBackground(tt.svgTag, tt.content, vb); got != tt.expect {
+				t.Errorf("got %q, want %q", got, tt.expect)
+			}
+		})
+	}
+
+	svg := `<svg width="400" height="300" viewBox="0 0 400 300"><rect x="0" y="0" width="400" height="300" fill="#1E1E1E"/></svg>`
+	for _, tt := range []struct {
+		opts   Options
+		expect string
+	}{
+		{Options{}, `fill="#1E1E1E" stroke="#ddd"`},
+		{Options{NoSourceBackground: true}, `fill="white" stroke="#ddd"`},
+	} {
+		stacker := NewSVGStackerWithOptions(tt.opts)
+		info, err := stacker.parseSVG(svg, "context")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stacker.diagrams["context"] = info
+		if layer := stacker.createDiagramLayer("context"); !strings.Contains(layer, tt.expect) {
+			t.Errorf("%+v: expected container %s, got:\n%s", tt.opts, tt.expect, layer)
+		}
+	}
+}
+
+// TestLevelTint tests the per-level container fills and their precedence
+func TestLevelTint(t *testing.T) {
+	diagram := DiagramInfo{content: "<g/>", viewBox: "0 0 10 10", width: 10, height: 10, aspectRatio: 1}
+	stacker := NewSVGStackerWithOptions(Options{LevelTint: true, LevelColors: map[string]string{"component": "#eef6ee"}})
+	for _, level := range []string{"context", "container", "component", "code"} {
+		stacker.diagrams[level] = diagram
+	}
+	dark := diagram
+	dark.background = "#1E1E1E"
+	stacker.diagrams["container"] = dark
+
+	tests := []struct {
+		level  string
+		expect string
+	}{
+		{"context", "#fbfcfd"},
+		{"container", "#1E1E1E"}, // the source background wins over the tint
+		{"component", "#eef6ee"}, // an explicit colour wins over everything
+		{"code", "#dce3ea"},
+	}
+	for _, tt := range tests {
+		if got := stacker.containerFill(tt.level); got != tt.expect {
+			t.Errorf("%s: got %q, want %q", tt.level, got, tt.expect)
+		}
+	}
+
+	// The gradient spans the levels present, and is off by default
+	delete(stacker.diagrams, "code")
+	if got := stacker.containerFill("context"); got != "#fbfcfd" {
+		t.Errorf("context: got %q", got)
+	}
+	if got := stacker.levelTint("component"); got != "#dce3ea" {
+		t.Errorf("last present level should be darkest, got %q", got)
+	}
+	if got := NewSVGStacker("", "", "").containerFill("context"); got != "white" {
+		t.Errorf("expected white without --level-tint, got %q", got)
+	}
+
+	for _, args := range [][]string{{"--level-color", "component"}, {"--level-color", "system=#fff"}, {"--level-color", "code="}} {
+		if _, err := parseArgsSlice(append([]string{"./examples"}, args...)); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+	opts, err := parseArgsSlice([]string{"./examples", "--level-tint", "--level-color", "code=#333", "--level-color", "context=lavender"})
+	if err != nil || !opts.LevelTint || opts.LevelColors["code"] != "#333" || opts.LevelColors["context"] != "lavender" {
+		t.Errorf("got %+v, %v", opts, err)
+	}
+}
+
+// TestBuildStackedSVGDiagramData tests that injected dimensions keep full precision
+func TestBuildStackedSVGDiagramData(t *testing.T) {
+	stacker := NewSVGStacker("", "", "")
+	info, err := stacker.parseSVG(`<svg width="3.4px" height="1.7px" viewBox="0 0 3.4 1.7"><g/></svg>`, "context")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stacker.diagrams["context"] = info
+
+	output := stacker.buildStackedSVG()
+	dataRegex := regexp.MustCompile(`'context': \{ width: ([^,]+), height: ([^,]+), ratio: ([^ ]+) \}`)
+	m := dataRegex.FindStringSubmatch(output)
+	if m == nil {
+		t.Fatalf("diagramData entry not found")
+	}
+
+	var values [3]float64
+	for i := range values {
+		if values[i], err = strconv.ParseFloat(m[i+1], 64); err != nil {
+			t.Fatalf("invalid number %q: %v", m[i+1], err)
+		}
+	}
+	if values[0] != 3.4 || values[1] != 1.7 {
+		t.Errorf("size: got %gx%g, want 3.4x1.7", values[0], values[1])
+	}
+	if math.Abs(values[2]-values[0]/values[1]) > 1e-9 {
+		t.Errorf("ratio %g does not match width/height %g", values[2], values[0]/values[1])
+	}
+}
+
+// TestBuildStackedSVGEscapesTitle tests that markup characters in the title keep the output valid
+func TestBuildStackedSVGEscapesTitle(t *testing.T) {
+	stacker := NewSVGStacker("", "", `Tom & "Jerry" <arch>`)
+	stacker.diagrams["context"] = DiagramInfo{content: "<g></g>", viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+
+	output := stacker.buildStackedSVG()
+	if err := ValidateXML(output); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+	if !strings.Contains(output, `Tom &amp; &#34;Jerry&#34; &lt;arch&gt;`) {
+		t.Errorf("expected escaped title in output")
+	}
+}
+
+// TestBuildStackedSVGRTL tests the mirrored header layout of --rtl
+func TestBuildStackedSVGRTL(t *testing.T) {
+	opts := Options{Title: "معمارية النظام", RTL: true}
+	stacker := NewSVGStackerWithOptions(opts)
+	stacker.diagrams["context"] = DiagramInfo{content: "<g></g>", viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+	stacker.diagrams["container"] = DiagramInfo{content: "<g></g>", viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+
+	output := stacker.buildStackedSVG()
+	if err := ValidateXML(output); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+
+	for _, want := range []string{
+		`<text x="1894" y="50"`,
+		`<rect x="1790" y="91" width="104" height="33" rx="4"` + "\n" + `        fill="#3498db" stroke="#2980b9" stroke-width="1"` + "\n" + `        style="cursor:pointer" onclick="showLevel('context')"`,
+		`<rect x="1673" y="91"`,
+		"const rtlLayout = true;",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output", want)
+		}
+	}
+	if got := strings.Count(output, `direction="rtl"`); got != 3 {
+		t.Errorf("expected direction=\"rtl\" on the title and 2 nav labels, got %d", got)
+	}
+
+	ltr := NewSVGStacker("", "", "Title")
+	ltr.diagrams["context"] = DiagramInfo{content: "<g></g>", viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+	output = ltr.buildStackedSVG()
+	if strings.Contains(output, `direction="rtl"`) || !strings.Contains(output, "const rtlLayout = false;") {
+		t.Errorf("expected left-to-right layout by default")
+	}
+}
+
+// TestNavButtonWidth tests that buttons grow with their label but never shrink below the default
+func TestNavButtonWidth(t *testing.T) {
+	tests := []struct {
+		label  string
+		expect int
+	}{
+		{"Context", 104},
+		{"Container", 104},
+		{"System Context", 144},
+		{"Überblick über das System", 236},
+	}
+
+	for _, tt := range tests {
+		if got := navButtonWidth(tt.label); got != tt.expect {
+			t.Errorf("navButtonWidth(%q): got %d, want %d", tt.label, got, tt.expect)
+		}
+	}
+}
+
+// TestBuildStackedSVGButtonGap tests that nav buttons are laid out sequentially with the configured gap
+func TestBuildStackedSVGButtonGap(t *testing.T) {
+	stacker := NewSVGStackerWithOptions(Options{ButtonGap: 40})
+	for _, level := range []string{"context", "container", "component"} {
+		stacker.diagrams[level] = DiagramInfo{content: "<g></g>", viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+	}
+
+	output := stacker.buildStackedSVG()
+	for _, want := range []string{`<rect x="26" y="91"`, `<rect x="170" y="91"`, `<rect x="314" y="91"`, "const buttonGap = 40;"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output", want)
+		}
+	}
+
+	if _, err := parseArgsSlice([]string{"./examples", "--button-gap", "0"}); err == nil {
+		t.Errorf("expected error for --button-gap 0")
+	}
+	if opts, err := parseArgsSlice([]string{"./examples", "--button-gap", "20"}); err != nil || opts.ButtonGap != 20 {
+		t.Errorf("--button-gap 20: got %d, %v", opts.ButtonGap, err)
+	}
+}
+
+// TestBuildStackedSVGLegend tests that the legend lists only the levels in the stack
+func TestBuildStackedSVGLegend(t *testing.T) {
+	stacker := NewSVGStackerWithOptions(Options{Legend: true})
+	stacker.diagrams["context"] = DiagramInfo{content: "<g></g>", viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+	stacker.diagrams["component"] = DiagramInfo{content: "<g></g>", viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+
+	output := stacker.buildStackedSVG()
+	if err := ValidateXML(output); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+
+	for _, want := range []string{`id="legend-panel"`, `id="legend-toggle"`, levelDescriptions["context"], levelDescriptions["component"], `height="124"`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output", want)
+		}
+	}
+	if strings.Contains(output, levelDescriptions["container"]) {
+		t.Errorf("legend should not describe levels missing from the stack")
+	}
+	if strings.Index(output, `id="legend-panel"`) < strings.Index(output, `id="layer-component"`) {
+		t.Errorf("legend should be drawn on top of the diagram layers")
+	}
+
+	stacker.opts.Legend = false
+	if output := stacker.buildStackedSVG(); strings.Contains(output, `id="legend-panel"`) || strings.Contains(output, `id="legend-toggle"`) {
+		t.Errorf("legend should be opt-in")
+	}
+}
+
+// TestBuildStackedSVGOverview tests the thumbnail tiles of --overview
+func TestBuildStackedSVGOverview(t *testing.T) {
+	stacker := NewSVGStackerWithOptions(Options{Overview: true})
+	stacker.diagrams["context"] = DiagramInfo{content: `<defs><linearGradient id="g"/></defs><rect id="ctx" fill="url(#g)"/><use xlink:href="#ctx"/>`, viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+	stacker.diagrams["code"] = DiagramInfo{content: `<inkscape:thing/>`, viewBox: "0 0 800 200", width: 800, height: 200, aspectRatio: 4,
+		namespaces: map[string]string{"inkscape": "http://www.inkscape.org/namespaces/inkscape"}}
+
+	output := stacker.buildStackedSVG()
+	if err := ValidateXML(output); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+
+	for _, want := range []string{
+		`id="overview-toggle"`,
+		`<g id="overview" style="display:none">`,
+		`<g id="overview-tile-context" transform="translate(26, 150)" style="cursor:pointer" onclick="showLevel('context')">`,
+		`<g id="overview-tile-code" transform="translate(372, 150)" style="cursor:pointer" onclick="showLevel('code')">`,
+		`<svg viewBox="0 0 800 200" x="10" y="28" width="300" height="230" preserveAspectRatio="xMidYMid meet" pointer-events="none" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape">`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output", want)
+		}
+	}
+	// The tile's copy of the content has ids and references of its own
+	for _, want := range []string{
+		`<rect id="ctx" fill="url(#g)"/><use xlink:href="#ctx"/>`,
+		`<linearGradient id="overview-context-g"></linearGradient>`,
+		`<rect id="overview-context-ctx" fill="url(#overview-context-g)"></rect><use xlink:href="#overview-context-ctx"></use>`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output", want)
+		}
+	}
+	if got := strings.Count(output, `id="ctx"`); got != 1 {
+		t.Errorf("expected the diagram's ids once, got %d copies", got)
+	}
+	if strings.Contains(output, "overview-tile-container") {
+		t.Errorf("overview should only include levels in the stack")
+	}
+
+	stacker.opts.Overview = false
+	if output := stacker.buildStackedSVG(); strings.Contains(output, `id="overview"`) || strings.Contains(output, `id="overview-toggle"`) {
+		t.Errorf("overview should be opt-in")
+	}
+}
+
+// TestAnimationOptions tests parsing of --animate and --animate-duration and the injected duration
+func TestAnimationOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		expect  time.Duration
+		wantErr bool
+	}{
+		{"off by default", []string{"./examples"}, 0, false},
+		{"animate", []string{"./examples", "--animate"}, defaultAnimationDuration, false},
+		{"duration", []string{"./examples", "--animate-duration", "400ms"}, 400 * time.Millisecond, false},
+		{"duration before animate", []string{"./examples", "--animate-duration", "1s", "--animate"}, time.Second, false},
+		{"zero duration", []string{"./examples", "--animate-duration", "0s"}, 0, true},
+		{"invalid duration", []string{"./examples", "--animate-duration", "fast"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseArgsSlice(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error: got %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && opts.AnimationDuration != tt.expect {
+				t.Errorf("AnimationDuration: got %v, want %v", opts.AnimationDuration, tt.expect)
+			}
+		})
+	}
+
+	stacker := NewSVGStackerWithOptions(Options{AnimationDuration: 400 * time.Millisecond})
+	stacker.diagrams["context"] = DiagramInfo{content: "<g></g>", viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+	if output := stacker.buildStackedSVG(); !strings.Contains(output, "const animationDuration = 400;") {
+		t.Errorf("expected the animation duration in milliseconds to be injected")
+	}
+}
+
+// TestBuildStackedSVGPrintRules tests the print stylesheet and the --print-all script flag
+func TestBuildStackedSVGPrintRules(t *testing.T) {
+	stacker := NewSVGStacker("", "", "Title")
+	stacker.diagrams["context"] = DiagramInfo{content: "<g></g>", viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+
+	output := stacker.buildStackedSVG()
+	if err := ValidateXML(output); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+	for _, want := range []string{"@media print", `<g id="nav-chrome">`, "const printAll = false;"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output", want)
+		}
+	}
+	// The header and every nav button are inside the chrome group hidden for print
+	chrome := output[strings.Index(output, `<g id="nav-chrome">`):strings.Index(output, "<!-- Diagram Layers")]
+	for _, id := range []string{`id="header-title"`, `id="nav-context"`, `id="notes-toggle"`, `id="fit-toggle"`} {
+		if !strings.Contains(chrome, id) {
+			t.Errorf("expected %s inside the nav chrome", id)
+		}
+	}
+
+	stacker.opts.PrintAll = true
+	if output := stacker.buildStackedSVG(); !strings.Contains(output, "const printAll = true;") {
+		t.Errorf("expected printAll to be injected for --print-all")
+	}
+	if opts, err := parseArgsSlice([]string{"./examples", "--print-all"}); err != nil || !opts.PrintAll {
+		t.Errorf("--print-all: got %v, %v", opts.PrintAll, err)
+	}
+}
+
+// TestMultipleStacks tests that several input directories become tabs in one document
+func TestMultipleStacks(t *testing.T) {
+	root := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g></g></svg>`
+	dirs := []string{filepath.Join(root, "billing"), filepath.Join(root, "a", "c4"), filepath.Join(root, "b", "c4")}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"context.svg", "container.svg"} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(svg), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	opts, err := parseArgsSlice(append(dirs, "--title", "Systems"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opts.InputDir != dirs[0] || len(opts.MoreInputDirs) != 2 {
+		t.Fatalf("expected the extra directories to be collected, got %q %q", opts.InputDir, opts.MoreInputDirs)
+	}
+
+	output, err := NewSVGStackerWithOptions(opts).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if err := ValidateXML(output); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+
+	for _, want := range []string{
+		`id="tab-billing"`, `id="tab-c4"`, `id="tab-c4-2"`,
+		`<g id="stack-nav-billing" style="display:block">`,
+		`<g id="stack-nav-c4-2" style="display:none">`,
+		`id="layer-billing-context"`, `id="layer-c4-2-container"`,
+		`onclick="showLevel('c4-context')"`,
+		`{ id: 'c4', prefix: 'c4-', levels: ['c4-context', 'c4-container'] }`,
+		"let availableLevels = ['billing-context', 'billing-container'];",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output", want)
+		}
+	}
+	if strings.Contains(output, `id="layer-context"`) {
+		t.Errorf("level ids should be prefixed by their stack")
+	}
+
+	// A single input keeps the plain ids and no tabs
+	single, err := NewSVGStacker(dirs[0], "", "").Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(single, `id="layer-context"`) || strings.Contains(single, `id="tab-`) || !strings.Contains(single, "const stacks = [];") {
+		t.Errorf("expected a plain single stack")
+	}
+}
+
+// TestGenerateContextCancelled tests that a cancelled context stops the pipeline
+func TestGenerateContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewSVGStacker("testdata", "", "").GenerateContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	output := filepath.Join(t.TempDir(), "out.svg")
+	if err := NewSVGStacker("testdata", output, "").CreateStackedSVGContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("expected no output to be written")
+	}
+}
+
+// TestCustomScript tests replacing the navigation script with --js
+func TestCustomScript(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "nav.js")
+	if err := os.WriteFile(custom, []byte("function showLevel(level) { console.log(availableLevels); }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	svg, err := NewSVGStackerWithOptions(Options{InputDir: "testdata", JSFile: custom}).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !strings.Contains(svg, "console.log(availableLevels)") || strings.Contains(svg, navigationJS) {
+		t.Errorf("expected the custom script in place of navigation.js")
+	}
+	if !strings.Contains(svg, "const diagramData = {") {
+		t.Errorf("expected the injected declarations to be kept")
+	}
+
+	for name, content := range map[string]string{"empty.js": " \n", "cdata.js": "var s = ']]>';"} {
+		file := filepath.Join(dir, name)
+		os.WriteFile(file, []byte(content), 0644)
+		if _, err := NewSVGStackerWithOptions(Options{InputDir: "testdata", JSFile: file}).Generate(); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: expected an error naming the file, got %v", name, err)
+		}
+	}
+	if _, err := NewSVGStackerWithOptions(Options{InputDir: "testdata", JSFile: filepath.Join(dir, "missing.js")}).Generate(); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+
+	if opts, err := parseArgsSlice([]string{"./examples", "--js", "nav.js"}); err != nil || opts.JSFile != "nav.js" {
+		t.Errorf("--js: got %q, %v", opts.JSFile, err)
+	}
+}
+
+// TestCustomCSS tests appending --css files to the stylesheet
+func TestCustomCSS(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	first := write("hover.css", ".entity:hover > rect { stroke: #e67e22; }\n")
+	second := write("brand.css", `text { font-family: "A & B"; }`)
+
+	svg, err := NewSVGStackerWithOptions(Options{InputDir: "testdata", CSSFiles: []string{first, second}}).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if err := ValidateXML(svg); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+	style := svg[strings.Index(svg, "<style>"):strings.Index(svg, "</style>")]
+	hover, brand := strings.Index(style, ".entity:hover > rect"), strings.Index(style, `"A & B"`)
+	if hover < 0 || brand < hover {
+		t.Errorf("expected both files in order inside <style>, got:\n%s", style)
+	}
+
+	for _, bad := range []string{write("close.css", "a {}</STYLE><script>x()</script>"), write("cdata.css", "a { content: ']]>'; }")} {
+		if _, err := NewSVGStackerWithOptions(Options{InputDir: "testdata", CSSFiles: []string{bad}}).Generate(); err == nil || !strings.Contains(err.Error(), bad) {
+			t.Errorf("%s: expected an error naming the file, got %v", bad, err)
+		}
+	}
+
+	opts, err := parseArgsSlice([]string{"./examples", "--css", "a.css", "--css", "b.css"})
+	if err != nil || strings.Join(opts.CSSFiles, ",") != "a.css,b.css" {
+		t.Errorf("--css: got %v, %v", opts.CSSFiles, err)
+	}
+}
+
+// TestNoCDATA tests --script-type and escaping the script and stylesheets with --no-cdata
+func TestNoCDATA(t *testing.T) {
+	css := filepath.Join(t.TempDir(), "brand.css")
+	if err := os.WriteFile(css, []byte(`.entity > text { font-family: "A & B"; }`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// elementText returns the decoded text of the document's first element named name
+	elementText := func(svg, name string) string {
+		decoder := xml.NewDecoder(strings.NewReader(svg))
+		var text strings.Builder
+		inside := false
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				break
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				inside = t.Name.Local == name
+			case xml.EndElement:
+				if inside {
+					return text.String()
+				}
+			case xml.CharData:
+				if inside {
+					text.Write(t)
+				}
+			}
+		}
+		t.Fatalf("no <%s> in output", name)
+		return ""
+	}
+
+	wrapped, err := NewSVGStackerWithOptions(Options{InputDir: "testdata", CSSFiles: []string{css}}).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	escaped, err := NewSVGStackerWithOptions(Options{InputDir: "testdata", CSSFiles: []string{css}, NoCDATA: true, ScriptType: "application/javascript"}).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if err := ValidateXML(escaped); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+
+	for _, name := range []string{"script", "style"} {
+		start, end := strings.Index(escaped, "<"+name), strings.Index(escaped, "</"+name+">")
+		if strings.Contains(escaped[start:end], "CDATA") {
+			t.Errorf("expected no CDATA section in <%s>", name)
+		}
+		// Apart from the whitespace around the CDATA sections
+		if !slices.Equal(strings.Fields(elementText(escaped, name)), strings.Fields(elementText(wrapped, name))) {
+			t.Errorf("expected <%s> to decode to the same text with and without CDATA", name)
+		}
+	}
+	if !strings.Contains(escaped, `<script type="application/javascript">`) || !strings.Contains(wrapped, `<script type="text/ecmascript"><![CDATA[`) {
+		t.Errorf("expected the script type to follow --script-type")
+	}
+
+	if _, err := parseArgsSlice([]string{"dir", "--script-type", "text/html"}); err == nil {
+		t.Errorf("expected an unknown --script-type to be rejected")
+	}
+	if opts, err := parseArgsSlice([]string{"dir", "--no-cdata", "--script-type", "text/javascript"}); err != nil || !opts.NoCDATA || opts.ScriptType != "text/javascript" {
+		t.Errorf("got %+v, %v", opts, err)
+	}
+}
+
+// TestNoPretty tests that --no-pretty skips re-indenting the diagram content
+func TestNoPretty(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g id="a"><rect width="10" height="10"/><text>API</text></g></svg>`
+
+	pretty, err := NewSVGStacker("", "", "").parseSVG(svg, "context")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	compact, err := NewSVGStackerWithOptions(Options{NoPretty: true}).parseSVG(svg, "context")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `<g id="a"><rect height="10" width="10"></rect><text>API</text></g>`; compact.content != want {
+		t.Errorf("got %q, want %q", compact.content, want)
+	}
+	if !strings.Contains(pretty.content, "\n") || len(compact.content) >= len(pretty.content) {
+		t.Errorf("expected the default to indent the content, got %q", pretty.content)
+	}
+
+	for args, want := range map[string]bool{"--no-pretty": true, "--pretty": false} {
+		if opts, err := parseArgsSlice([]string{"dir", "--no-pretty", args}); err != nil || opts.NoPretty != want {
+			t.Errorf("--no-pretty %s: got %v, %v", args, opts.NoPretty, err)
+		}
+	}
+}
+
+// TestSourceTitle tests that a diagram's own <title> labels its nav button and
+// layer, and that shape tooltips aren't mistaken for it
+func TestSourceTitle(t *testing.T) {
+	dir := t.TempDir()
+	diagrams := map[string]string{
+		"context.svg": `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50">` +
+			"<title>\n  Banking &amp; Payments\n</title><g><title>Customer</title><rect/></g></svg>",
+		"container.svg": `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50">` +
+			`<g><title>Web App</title><rect/></g></svg>`,
+	}
+	for name, svg := range diagrams {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stacker := NewSVGStacker(dir, "", "")
+	output, err := stacker.Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if got := stacker.diagrams["context"].title; got != "Banking & Payments" {
+		t.Errorf("context title: got %q", got)
+	}
+	if got := stacker.diagrams["container"].title; got != "" {
+		t.Errorf("expected no title for container, got %q", got)
+	}
+	for _, want := range []string{
+		"id=\"nav-text-context\">\n    Banking &amp; Payments\n",
+		"<g id=\"layer-context\" style=\"display:none\">\n    <title>Banking &amp; Payments</title>",
+		"id=\"nav-text-container\">\n    Container\n",
+		"<g id=\"layer-container\" style=\"display:none\">\n    <title>Container</title>",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output", want)
+		}
+	}
+
+	// navigation.js strips the titles inside diagram-<level> to stop tooltips, so
+	// the layer's own title must be outside it
+	if !strings.Contains(navigationJS, "document.getElementById('diagram-' + currentLevel)") {
+		t.Errorf("expected navigation.js to strip titles only inside the diagram")
+	}
+	levels, err := stackedLevels(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(levels["context"], "<title>Banking &amp; Payments</title>") || !strings.Contains(levels["context"], "<title>Customer</title>") {
+		t.Errorf("expected only the diagram's own titles inside diagram-context, got %s", levels["context"])
+	}
+}
+
+// TestPlaceholder tests the layer for a level without a diagram
+func TestPlaceholder(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g></g></svg>`
+	if err := os.WriteFile(filepath.Join(dir, "context.svg"), []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    Options
+		want    []string
+		notWant []string
+	}{
+		{"default", Options{}, []string{`id="layer-code"`, "Code diagram not found", `fill="#ecf0f1"`}, nil},
+		{"custom", Options{PlaceholderText: "{level} view & notes coming soon", PlaceholderFill: "#fff"},
+			[]string{"Code view &amp; notes coming soon", `fill="#fff" stroke="#bdc3c7"`}, []string{"diagram not found"}},
+		{"none", Options{NoPlaceholder: true}, []string{`id="layer-context"`}, []string{`id="layer-code"`, "diagram not found"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.InputDir = dir
+			out, err := NewSVGStackerWithOptions(tt.opts).Generate()
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("expected %s in output", want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(out, notWant) {
+					t.Errorf("expected no %s in output", notWant)
+				}
+			}
+		})
+	}
+
+	opts, err := parseArgsSlice([]string{dir, "--no-placeholder", "--placeholder-text", "TBD", "--placeholder-fill", "#eee"})
+	if err != nil || !opts.NoPlaceholder || opts.PlaceholderText != "TBD" || opts.PlaceholderFill != "#eee" {
+		t.Errorf("got %+v, %v", opts, err)
+	}
+}
+
+// TestConformant tests that --conformant replaces the markup validators flag
+func TestConformant(t *testing.T) {
+	svg, err := NewSVGStackerWithOptions(Options{InputDir: "testdata", Conformant: true}).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if err := ValidateXML(svg); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+	if strings.Contains(svg, "99999") {
+		t.Errorf("expected no placeholder sizes")
+	}
+	for _, want := range []string{
+		`version="1.1"`,
+		`<timestamp xmlns="` + generatorNamespace + `">`,
+		`<rect x="5" y="145" width="`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("expected %s in output", want)
+		}
+	}
+
+	// The namespaced timestamp is still left out of the content hash
+	again := regexp.MustCompile(`(<timestamp[^>]*>)[^<]*`).ReplaceAllString(svg, "${1}2000-01-01T00:00:00Z")
+	if contentHash(again) != contentHash(svg) {
+		t.Errorf("expected the content hash to ignore the timestamp")
+	}
+
+	if opts, err := parseArgsSlice([]string{"dir", "--conformant"}); err != nil || !opts.Conformant {
+		t.Errorf("--conformant: got %v, %v", opts.Conformant, err)
+	}
+}
+
+// TestGenerateIsReproducible tests that two runs over the same inputs produce the
+// same document apart from the generation timestamp
+func TestGenerateIsReproducible(t *testing.T) {
+	var outputs []string
+	for range 2 {
+		svg, err := NewSVGStacker("testdata", "", "").Generate()
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		outputs = append(outputs, regexp.MustCompile(`<timestamp>[^<]*</timestamp>`).ReplaceAllString(svg, ""))
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("expected identical output from identical inputs")
+	}
+}
+
+// TestPrintLevels tests the levels subcommand output
+func TestPrintLevels(t *testing.T) {
+	var text bytes.Buffer
+	if err := printLevels(&text, false); err != nil {
+		t.Fatal(err)
+	}
+	if text.String() != "context\ncontainer\ncomponent\ncode\n" {
+		t.Errorf("got %q", text.String())
+	}
+
+	var out bytes.Buffer
+	if err := printLevels(&out, true); err != nil {
+		t.Fatal(err)
+	}
+	var levels []struct{ Name, Description string }
+	if err := json.Unmarshal(out.Bytes(), &levels); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+	if len(levels) != 4 || levels[0].Name != "context" || levels[0].Description == "" {
+		t.Errorf("got %+v", levels)
+	}
+	// Every listed name is recognized in a file name
+	stacker := NewSVGStacker("", "", "")
+	for _, level := range levels {
+		if got := stacker.extractLevel("01-" + level.Name + ".svg"); got != level.Name {
+			t.Errorf("extractLevel(%q) = %q", level.Name, got)
+		}
+	}
+
+	if _, err := parseArgsSlice([]string{"levels", "--json"}); err == nil || err.Error() != "levels" {
+		t.Errorf("expected the levels sentinel, got %v", err)
+	}
+	if asJSON, err := parseLevelsArgs([]string{"--json"}); err != nil || !asJSON {
+		t.Errorf("--json: got %v, %v", asJSON, err)
+	}
+	if _, err := parseLevelsArgs([]string{"--yaml"}); err == nil {
+		t.Errorf("expected an error for an unknown flag")
+	}
+}
+
+// TestSubcommandOnlyFirst tests that a subcommand name is only one in first place
+func TestSubcommandOnlyFirst(t *testing.T) {
+	if _, err := parseArgsSlice([]string{"lint", "docs"}); err == nil || err.Error() != "lint" {
+		t.Errorf("expected the lint sentinel, got %v", err)
+	}
+	for _, args := range [][]string{
+		{"docs", "--title", "lint"},
+		{"docs/index", "--placeholder-text", "diff"},
+		{"docs", "--output", "serve"},
+	} {
+		if _, err := parseArgsSlice(args); err != nil {
+			t.Errorf("%v: expected no subcommand, got %v", args, err)
+		}
+	}
+}
+
+// TestSortBy tests ordering the layers by --sort-by
+func TestSortBy(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g/></svg>`
+	now := time.Now()
+	files := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"10-b-context.svg", time.Hour},
+		{"2-c-container.svg", 3 * time.Hour},
+		{"a-component.svg", 2 * time.Hour},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, now.Add(-f.age), now.Add(-f.age))
+	}
+
+	tests := []struct {
+		sortBy string
+		expect string
+	}{
+		{"", "context,container,component,code"},
+		{"c4", "context,container,component,code"},
+		{"name", "context,container,component,code"},
+		{"mtime", "container,component,context,code"},
+		{"number", "container,context,component,code"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sortBy, func(t *testing.T) {
+			stacker := NewSVGStackerWithOptions(Options{InputDir: dir, SortBy: tt.sortBy})
+			output, err := stacker.Generate()
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if got := strings.Join(stacker.levelOrder(), ","); got != tt.expect {
+				t.Errorf("got %s, want %s", got, tt.expect)
+			}
+			present := strings.TrimSuffix(tt.expect, ",code")
+			if want := "let availableLevels = ['" + strings.ReplaceAll(present, ",", "', '") + "'];"; !strings.Contains(output, want) {
+				t.Errorf("expected %s in the output", want)
+			}
+		})
+	}
+
+	// name compares whole file names, not just levels
+	os.Rename(filepath.Join(dir, "a-component.svg"), filepath.Join(dir, "0-component.svg"))
+	stacker := NewSVGStackerWithOptions(Options{InputDir: dir, SortBy: "name"})
+	if _, err := stacker.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(stacker.levelOrder(), ","); got != "component,context,container,code" {
+		t.Errorf("name: got %s", got)
+	}
+
+	if _, err := parseArgsSlice([]string{"./examples", "--sort-by", "size"}); err == nil {
+		t.Errorf("expected an error for an unknown order")
+	}
+	if opts, err := parseArgsSlice([]string{"./examples", "--sort-by", "mtime"}); err != nil || opts.SortBy != "mtime" {
+		t.Errorf("--sort-by mtime: got %q, %v", opts.SortBy, err)
+	}
+}
+
+// TestLevelID tests normalization of level names for ids
+func TestLevelID(t *testing.T) {
+	tests := []struct {
+		level  string
+		expect string
+	}{
+		{"context", "context"},
+		{"Deployment View", "deployment-view"},
+		{`it's "odd" -- <level>`, "it-s-odd-level"},
+		{"!!!", "level"},
+	}
+
+	for _, tt := range tests {
+		if got := levelID(tt.level); got != tt.expect {
+			t.Errorf("levelID(%q): got %q, want %q", tt.level, got, tt.expect)
+		}
+	}
+}
+
+// TestCreateDiagramLayerOddLevel tests that a malformed level name cannot corrupt the layer markup
+func TestCreateDiagramLayerOddLevel(t *testing.T) {
+	stacker := NewSVGStacker("", "", "")
+	level := `bad "level" -- <x>`
+	stacker.diagrams[level] = DiagramInfo{content: "<g></g>", viewBox: "0 0 10 10", width: 10, height: 10, aspectRatio: 1}
+
+	for _, layer := range []string{stacker.createDiagramLayer(level), stacker.createDiagramLayer("missing " + level)} {
+		if err := ValidateXML(layer); err != nil {
+			t.Errorf("layer is not valid XML: %v\n%s", err, layer)
+		}
+	}
+	if layer := stacker.createDiagramLayer(level); !strings.Contains(layer, `id="layer-bad-level-x"`) {
+		t.Errorf("expected slugified layer id, got:\n%s", layer)
+	}
+}
+
+// TestJSString tests quoting of strings injected into the navigation script
+func TestJSString(t *testing.T) {
+	tests := []struct {
+		input  string
+		expect string
+	}{
+		{"context", `'context'`},
+		{"it's", `'it\'s'`},
+		{`back\slash`, `'back\\slash'`},
+		{`<a & "b">`, `'\u003ca \u0026 \u0022b\u0022\u003e'`},
+		{"line\nbreak", `'line\u000abreak'`},
+		{"]]>", `']]\u003e'`},
+	}
+
+	for _, tt := range tests {
+		if got := jsString(tt.input); got != tt.expect {
+			t.Errorf("jsString(%q): got %s, want %s", tt.input, got, tt.expect)
+		}
+	}
+}
+
+// TestBuildStackedSVGOmitsToggles tests that disabled toggle buttons are not emitted
+func TestBuildStackedSVGOmitsToggles(t *testing.T) {
+	stacker := NewSVGStackerWithOptions(Options{NoNotesToggle: true, NoFitToggle: true})
+	stacker.diagrams["context"] = DiagramInfo{viewBox: "0 0 400 300", width: 400, height: 300, aspectRatio: 400.0 / 300.0}
+
+	output := stacker.buildStackedSVG()
+
+	for _, id := range []string{`id="notes-toggle"`, `id="notes-text"`, `id="fit-toggle"`, `id="fit-text"`} {
+		if strings.Contains(output, id) {
+			t.Errorf("expected %s to be omitted", id)
+		}
+	}
+	if !strings.Contains(output, `id="nav-context"`) {
+		t.Errorf("expected level navigation button to remain")
+	}
+	if err := ValidateXML(output); err != nil {
+		t.Errorf("output is not valid XML: %v", err)
+	}
+}
+
+// svgDoctype is the prolog some SVG exporters write before the root element
+const svgDoctype = `<?xml version="1.0" standalone="no"?>
+<?xml-stylesheet href="style.css" type="text/css"?>
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+`
+
+// TestParseSVGDoctype tests that a DOCTYPE and processing instructions before
+// <svg> are skipped
+func TestParseSVGDoctype(t *testing.T) {
+	svg := svgDoctype + `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g id="body"/></svg>`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "context.svg"), []byte(svg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stacker := NewSVGStackerWithOptions(Options{InputDir: dir})
+	if err := stacker.loadDiagrams(context.Background()); err != nil {
+		t.Fatalf("loadDiagrams failed: %v", err)
+	}
+	if info := stacker.diagrams["context"]; info.content != `<g id="body"></g>` || info.viewBox != "0 0 100 50" {
+		t.Errorf("got content %q, viewBox %q", info.content, info.viewBox)
+	}
+}
+
+// TestValidateXML tests XML validation
+func TestValidateXML(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		expectErr bool
+	}{
+		{
+			name:      "valid simple XML",
+			content:   `<root><item>test</item></root>`,
+			expectErr: false,
+		},
+		{
+			name:      "valid SVG snippet",
+			content:   `<g><rect x="0" y="0"/></g>`,
+			expectErr: false,
+		},
+		{
+			name:      "invalid XML - missing close tag",
+			content:   `<root><item>test</root>`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid XML - malformed tag",
+			content:   `<root><item test>content</item></root>`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid XML - unclosed tag",
+			content:   `<root><item>`,
+			expectErr: true,
+		},
+		{
+			name:      "valid XML with attributes",
+			content:   `<svg xmlns="http://www.w3.org/2000/svg"><rect width="100"/></svg>`,
+			expectErr: false,
+		},
+		{
+			name:      "DOCTYPE with external DTD",
+			content:   svgDoctype + `<svg xmlns="http://www.w3.org/2000/svg"><rect width="100"/></svg>`,
+			expectErr: false,
+		},
+		{
+			name:      "external entity",
+			content:   `<!DOCTYPE svg [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><svg><text>&xxe;</text></svg>`,
+			expectErr: true,
+		},
+		{
+			name: "nested entity expansion",
+			content: `<!DOCTYPE svg [
+  <!ENTITY lol "lol">
+  <!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+  <!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">
+  <!ENTITY lol3 "&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;">
+]><svg><text>&lol3;</text></svg>`,
+			expectErr: true,
+		},
+		{
+			name:      "undeclared entity reference",
+			content:   `<svg><text>&lol;</text></svg>`,
+			expectErr: true,
+		},
+		{
+			name:      "deeply nested elements",
+			content:   strings.Repeat("<g>", maxXMLDepth+1) + strings.Repeat("</g>", maxXMLDepth+1),
+			expectErr: true,
+		},
+		{
+			name:      "external parameter entity",
+			content:   `<!DOCTYPE svg [<!ENTITY % remote PUBLIC "-//X//EN" "http://example.com/x.dtd"> %remote;]><svg/>`,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateXML(tt.content)
+
+			if (err != nil) != tt.expectErr {
+				if tt.expectErr {
+					t.Errorf("expected error, got nil")
+				} else {
+					t.Errorf("expected no error, got %v", err)
+				}
+			}
+		})
+	}
+
+	// The bomb is refused at its declarations, before any reference is reached
+	bomb := `<!DOCTYPE svg [<!ENTITY lol "lol"><!ENTITY lol1 "&lol;&lol;">]><svg><text>&lol1;</text></svg>`
+	if err := ValidateXML(bomb); err == nil || !strings.Contains(err.Error(), "DOCTYPE declares entities") {
+		t.Errorf("expected the entity declarations to be rejected, got %v", err)
+	}
+}
+
+// TestExtractLevel tests the level extraction from filenames
+func TestExtractLevel(t *testing.T) {
+	tests := []struct {
+		filename  string
+		expectLvl string
+	}{
+		{"01-context.svg", "context"},
+		{"02-container.svg", "container"},
+		{"03-component.svg", "component"},
+		{"04-code.svg", "code"},
+		{"Context-Diagram.svg", "context"},
+		{"CONTAINER.svg", "container"},
+		{"component-diagram.svg", "component"},
+		{"CODE_LEVEL.svg", "code"},
+		{"unknown.svg", "unknown"},
+		{"random-file.txt", "unknown"},
+	}
+
+	stacker := &SVGStacker{}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			result := stacker.extractLevel(tt.filename)
+			if result != tt.expectLvl {
+				t.Errorf("got %q, want %q", result, tt.expectLvl)
+			}
+		})
+	}
+}
+
+// TestLevelPattern tests assigning levels with --level-pattern instead of level names
+func TestLevelPattern(t *testing.T) {
+	opts, err := parseArgsSlice([]string{"dir", "--level-pattern", `^[Ll](?P<level>\d+)-`})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stacker := NewSVGStackerWithOptions(opts)
+
+	tests := []struct {
+		filename  string
+		expectLvl string
+	}{
+		{"L1-overview.svg", "context"},
+		{"L2-services.svg", "container"},
+		{"l3-billing.svg", "component"},
+		{"L04-classes.svg", "code"},
+		{"L5-extra.svg", "unknown"},
+		{"context.svg", "unknown"}, // the level names no longer count
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			if got := stacker.extractLevel(tt.filename); got != tt.expectLvl {
+				t.Errorf("got %q, want %q", got, tt.expectLvl)
+			}
+		})
+	}
+
+	named := NewSVGStackerWithOptions(Options{LevelPattern: regexp.MustCompile(`^view-(?P<level>[A-Za-z]+)`)})
+	if got := named.extractLevel("view-Container.svg"); got != "container" {
+		t.Errorf("expected a level name capture to map to itself, got %q", got)
+	}
+
+	for _, pattern := range []string{`^L(\d)-`, `^L(?P<level>\d`} {
+		if _, err := parseArgsSlice([]string{"dir", "--level-pattern", pattern}); err == nil {
+			t.Errorf("%s: expected an error", pattern)
+		}
+	}
 }
 
 // TestTitleCase tests the titleCase function
@@ -0,0 +1,132 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+//go:embed mobile.js
+var mobileJS string
+
+const (
+	// mobileWidth is the width of the --mobile column in user units; the root
+	// viewBox scales it to the viewport width
+	mobileWidth         = 800
+	mobileTitleHeight   = 64
+	mobileHeaderHeight  = 44
+	mobileSectionMargin = 16
+)
+
+// mobileSection is a level's place in the --mobile column
+type mobileSection struct {
+	key   string // level key, as the diagram links use
+	label string // the header text
+	y     int    // top of the header
+}
+
+// buildMobileSVG lays every level out in a single column for small screens: each
+// diagram is scaled to the column width under a header naming its level, and the
+// whole column is scaled to the viewport, so it reads by scrolling instead of
+// through the nav buttons. Levels without a diagram are left out.
+func (s *SVGStacker) buildMobileSVG() string {
+	levels := s.levelOrder()
+	stacks := s.allStacks()
+	font := xmlEscape(s.fontFamily())
+	diagramWidth := mobileWidth - 2*mobileSectionMargin
+
+	var layers strings.Builder
+	var sections []mobileSection
+	y := mobileTitleHeight + mobileSectionMargin
+	for _, stack := range stacks {
+		for _, level := range levels {
+			diagram, exists := stack.diagrams[level]
+			if !exists {
+				continue
+			}
+			label := stack.levelLabel(level)
+			if len(stacks) > 1 {
+				label = stack.label + " / " + label
+			}
+			key := stack.levelKey(level)
+			diagramHeight := float64(diagramWidth) / diagram.aspectRatio
+			sections = append(sections, mobileSection{key, label, y})
+
+			layers.WriteString(fmt.Sprintf(`
+  <!-- %s section -->
+  <g id="layer-%s" data-section="%d">
+    <title>%s</title>
+    <rect x="0" y="%d" width="%d" height="%d" fill="#34495e"/>
+    <text x="%d" y="%d" font-family="%s" font-size="20" font-weight="bold" fill="white">%s</text>
+    <rect x="%d" y="%d" width="%d" height="%s" fill="%s" stroke="#ddd" stroke-width="1" rx="5" id="container-%s"/>
+    <g id="diagram-%s">
+      <svg viewBox="%s" x="%d" y="%d" width="%d" height="%s" preserveAspectRatio="xMidYMin meet"%s>
+        %s
+      </svg>
+    </g>
+  </g>`, key, key, len(sections)-1, xmlEscape(label),
+				y, mobileWidth, mobileHeaderHeight,
+				mobileSectionMargin, y+29, font, xmlEscape(label),
+				mobileSectionMargin/2, y+mobileHeaderHeight+mobileSectionMargin/2, mobileWidth-mobileSectionMargin, jsNumber(diagramHeight+float64(mobileSectionMargin)), xmlEscape(stack.containerFill(level)), key,
+				key, diagram.viewBox, mobileSectionMargin, y+mobileHeaderHeight+mobileSectionMargin, diagramWidth, jsNumber(diagramHeight), diagram.namespaceAttrs(), diagram.content))
+
+			y += mobileHeaderHeight + mobileSectionMargin + int(diagramHeight+0.999) + 2*mobileSectionMargin
+		}
+	}
+	height := y
+
+	var sb strings.Builder
+	// The root is as wide as the viewport and, once the script has run, as tall as
+	// the column at that width
+	sb.WriteString(s.svgPreamble(fmt.Sprintf(`
+     viewBox="0 0 %d %d"
+     width="100%%"
+     preserveAspectRatio="xMidYMin meet"
+     style="background: #f8f9fa; display: block;"`, mobileWidth, height)))
+
+	sb.WriteString(s.background)
+
+	sb.WriteString(fmt.Sprintf(`
+
+  <!-- Title -->
+  <g id="nav-chrome">
+  <rect x="0" y="0" width="%d" height="%d" fill="#2c3e50"/>
+  <text x="%d" y="42" font-family="%s" font-size="26" font-weight="bold" fill="white"
+        id="header-title">
+    %s
+  </text>
+  </g>
+`, mobileWidth, mobileTitleHeight, mobileSectionMargin, font, xmlEscape(s.title)))
+
+	sb.WriteString(layers.String())
+
+	// Drawn last so it covers the diagrams it is pinned above
+	sb.WriteString(fmt.Sprintf(`
+
+  <!-- Header of the section being read, pinned to the top by the script -->
+  <g id="mobile-sticky" style="display:none" pointer-events="none">
+    <rect x="0" y="0" width="%d" height="%d" fill="#34495e" fill-opacity="0.95"/>
+    <text x="%d" y="29" font-family="%s" font-size="20" font-weight="bold" fill="white" id="mobile-sticky-text"></text>
+  </g>
+`, mobileWidth, mobileHeaderHeight, mobileSectionMargin, font))
+
+	var js strings.Builder
+	js.WriteString(fmt.Sprintf("const mobileWidth = %d;\nconst mobileHeight = %d;\n\n", mobileWidth, height))
+	js.WriteString("const mobileSections = [")
+	for i, section := range sections {
+		if i > 0 {
+			js.WriteString(",")
+		}
+		js.WriteString(fmt.Sprintf("\n  { key: %s, label: %s, y: %d }", jsString(section.key), jsString(section.label), section.y))
+	}
+	js.WriteString("\n];\n\n")
+	js.WriteString(mobileJS)
+
+	sb.WriteString(`
+  <!-- Layout Script -->
+` + s.scriptElement(js.String()) + `
+
+</svg>`)
+
+	return sb.String()
+}
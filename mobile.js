@@ -0,0 +1,70 @@
+// Layout script for --mobile: every level is stacked in one column that is
+// scaled to the viewport width. The script only sizes the document, pins the
+// header of the level being read to the top and makes diagram links scroll to
+// the next level; without it the column still shows, just fitted to the window.
+// mobileWidth, mobileHeight and mobileSections are injected before this script.
+
+const svgRoot = document.documentElement;
+const sticky = document.getElementById('mobile-sticky');
+const stickyText = document.getElementById('mobile-sticky-text');
+
+// Index of the section whose diagram was last clicked, for navigateDown
+let clickedSection = -1;
+
+// userUnitsPerPixel converts CSS pixels scrolled into document coordinates
+function userUnitsPerPixel() {
+  const width = svgRoot.getBoundingClientRect().width;
+  return width > 0 ? mobileWidth / width : 1;
+}
+
+// Give the root a height matching the column's proportions, so the page scrolls
+// instead of shrinking the column to fit the window
+function resizeColumn() {
+  const width = svgRoot.getBoundingClientRect().width;
+  svgRoot.setAttribute('height', Math.ceil(width * mobileHeight / mobileWidth));
+  updateSticky();
+}
+
+// Pin a copy of the header of the section scrolled into, once its own header
+// has gone off the top
+function updateSticky() {
+  const top = window.scrollY * userUnitsPerPixel();
+  let current = -1;
+  mobileSections.forEach((section, i) => {
+    if (section.y < top) current = i;
+  });
+  if (current < 0) {
+    sticky.style.display = 'none';
+    return;
+  }
+  stickyText.textContent = mobileSections[current].label;
+  sticky.setAttribute('transform', 'translate(0,' + top + ')');
+  sticky.style.display = 'block';
+}
+
+function scrollToSection(index) {
+  const section = mobileSections[index];
+  if (section) {
+    window.scrollTo({ top: section.y / userUnitsPerPixel(), behavior: 'smooth' });
+  }
+}
+
+// showLevel and navigateDown stand in for those of navigation.js, which
+// converted diagram links call
+function showLevel(key) {
+  scrollToSection(mobileSections.findIndex(section => section.key === key));
+}
+
+function navigateDown() {
+  scrollToSection(clickedSection + 1);
+}
+
+// Capturing runs before the link's own onclick, so navigateDown knows where it was
+document.addEventListener('click', event => {
+  const section = event.target.closest ? event.target.closest('[data-section]') : null;
+  clickedSection = section ? Number(section.getAttribute('data-section')) : -1;
+}, true);
+
+window.addEventListener('scroll', updateSticky, { passive: true });
+window.addEventListener('resize', resizeColumn);
+resizeColumn();
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMobileLayout(t *testing.T) {
+	dir := t.TempDir()
+	createTestSVGFiles(t, dir)
+
+	output, err := NewSVGStackerWithOptions(Options{InputDir: dir, Title: "Phone", Mobile: true}).Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if err := ValidateXML(output); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+
+	// 400x300 and 500x400 diagrams scaled to the 768-wide column
+	for _, want := range []string{
+		`viewBox="0 0 800 1455"`,
+		`width="100%"`,
+		`<g id="layer-context" data-section="0">`,
+		`<svg viewBox="0 0 400 300" x="16" y="140" width="768" height="576"`,
+		`<g id="layer-container" data-section="1">`,
+		`<svg viewBox="0 0 500 400" x="16" y="808" width="768" height="614.4"`,
+		`{ key: 'context', label: 'Test Context', y: 80 }`,
+		`{ key: 'container', label: 'Test Container', y: 748 }`,
+		`id="mobile-sticky"`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %s in output", want)
+		}
+	}
+	for _, unwanted := range []string{`id="nav-context"`, `style="display:none">`, "layer-component", "toggleFitMode"} {
+		if strings.Contains(output, unwanted) {
+			t.Errorf("expected no %s in the mobile layout", unwanted)
+		}
+	}
+	if strings.Index(output, "layer-context") > strings.Index(output, "layer-container") {
+		t.Errorf("expected the levels in C4 order")
+	}
+}
+
+func TestMobileFlag(t *testing.T) {
+	if opts, err := parseArgsSlice([]string{"dir", "--mobile"}); err != nil || !opts.Mobile {
+		t.Errorf("--mobile: got %v, %v", opts.Mobile, err)
+	}
+	for _, other := range []string{"--overview", "--legend", "--print-all"} {
+		if _, err := parseArgsSlice([]string{"dir", "--mobile", other}); err == nil {
+			t.Errorf("expected --mobile with %s to be rejected", other)
+		}
+	}
+}
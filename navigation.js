@@ -1,30 +1,114 @@
 // Embedded navigation JavaScript for stacked C4 diagrams
-let currentLevel = 'context';
+let currentLevel = availableLevels[0];
+let currentStack = stacks.length > 0 ? stacks[0] : null; // null unless several stacks are tabbed
 let fitToWidth = false; // false = native size (free zoom), true = auto-scale (constrained)
 let notesVisible = true; // true = show notes, false = hide notes
+let legendVisible = true; // only relevant with --legend
+let overviewVisible = false; // true while the --overview tiles replace the current level
 let selectedLinks = []; // Track multiple selected links (Ctrl+click to multi-select)
 
 function positionRightAlignedElements() {
   const viewBoxWidth = window.innerWidth;
-  const fitToggleButton = document.getElementById('fit-toggle');
-  const fitToggleText = document.getElementById('fit-text');
-  const notesToggleButton = document.getElementById('notes-toggle');
-  const notesToggleText = document.getElementById('notes-text');
-
-  if (fitToggleButton && fitToggleText && notesToggleButton && notesToggleText) {
-    // Position fit toggle on far right
-    const fitToggleX = viewBoxWidth - 156; // 130px width + 26px margin
-    const fitTextX = fitToggleX + 13;
-
-    // Position notes toggle to the left of fit toggle
-    const notesToggleX = fitToggleX - 143; // 130px button width + 13px gap
-    const notesTextX = notesToggleX + 13;
-
-    fitToggleButton.setAttribute('x', fitToggleX);
-    fitToggleText.setAttribute('x', fitTextX);
-    notesToggleButton.setAttribute('x', notesToggleX);
-    notesToggleText.setAttribute('x', notesTextX);
+
+  // Toggles are optional (--no-fit-toggle / --no-notes-toggle / --legend / --overview),
+  // so lay out whichever are present from the outer edge inwards
+  const toggles = [
+    ['fit-toggle', 'fit-text'],
+    ['notes-toggle', 'notes-text'],
+    ['legend-toggle', 'legend-text'],
+    ['overview-toggle', 'overview-text'],
+  ];
+
+  if (rtlLayout) {
+    positionRTLHeader(viewBoxWidth);
+    positionTabs();
+
+    // Mirror the toggles: lay them out from the left edge inwards
+    let leftEdge = 26; // 26px margin
+    toggles.forEach(([buttonId, textId]) => {
+      const button = document.getElementById(buttonId);
+      const text = document.getElementById(textId);
+      if (button && text) {
+        button.setAttribute('x', leftEdge);
+        text.setAttribute('x', leftEdge + 13);
+        leftEdge += 130 + 13; // 130px width, 13px gap
+      }
+    });
+    positionLegend(26);
+    return;
+  }
+
+  positionTabs();
+
+  let rightEdge = viewBoxWidth - 26; // 26px margin
+  toggles.forEach(([buttonId, textId]) => {
+    const button = document.getElementById(buttonId);
+    const text = document.getElementById(textId);
+    if (button && text) {
+      const x = rightEdge - 130; // 130px width
+      button.setAttribute('x', x);
+      text.setAttribute('x', x + 13);
+      rightEdge = x - 13; // 13px gap
+    }
+  });
+  positionLegend(viewBoxWidth - 26 - legendPanelWidth());
+}
+
+// legendPanelWidth is the width of the --legend panel, or 0 if there is none
+function legendPanelWidth() {
+  const background = document.querySelector('#legend-panel rect');
+  return background ? parseFloat(background.getAttribute('width')) : 0;
+}
+
+// positionLegend moves the legend panel (if present) to x, just below the header
+function positionLegend(x) {
+  const legend = document.getElementById('legend-panel');
+  if (legend) {
+    legend.setAttribute('transform', 'translate(' + x + ', 140)');
+  }
+}
+
+// positionTabs places the stack tabs in the header after the title (before it
+// with --rtl), now that the title can be measured
+function positionTabs() {
+  const title = document.getElementById('header-title');
+  if (!title || stacks.length === 0) return;
+
+  const box = title.getBBox();
+  let edge = rtlLayout ? box.x - 39 : box.x + box.width + 39;
+  stacks.forEach(stack => {
+    const tab = document.getElementById('tab-' + stack.id);
+    const text = document.getElementById('tab-text-' + stack.id);
+    if (!tab || !text) return;
+
+    const width = parseFloat(tab.getAttribute('width'));
+    const x = rtlLayout ? edge - width : edge;
+    tab.setAttribute('x', x);
+    text.setAttribute('x', rtlLayout ? x + width - 13 : x + 13);
+    edge = rtlLayout ? x - buttonGap : x + width + buttonGap;
+  });
+}
+
+// positionRTLHeader right-aligns the title and lays out the nav buttons from the
+// right edge, first level rightmost (--rtl)
+function positionRTLHeader(viewBoxWidth) {
+  const title = document.getElementById('header-title');
+  if (title) {
+    title.setAttribute('x', viewBoxWidth - 26);
   }
+
+  let rightEdge = viewBoxWidth - 26;
+  availableLevels.forEach(level => {
+    const button = document.getElementById('nav-' + level);
+    const text = document.getElementById('nav-text-' + level);
+    if (!button || !text) return;
+
+    const width = parseFloat(button.getAttribute('width'));
+    const x = rightEdge - width;
+    button.setAttribute('x', x);
+    text.setAttribute('x', x + width - 13); // RTL text is anchored at its right end
+    rightEdge = x - buttonGap;
+  });
 }
 
 function resizeContainers() {
@@ -36,6 +120,11 @@ function resizeContainers() {
   const mainSVG = document.documentElement;
   if (!mainSVG) return;
 
+  if (overviewVisible) {
+    layoutOverview(mainSVG, viewportWidth, viewportHeight);
+    return;
+  }
+
   // Get current level diagram data
   const data = diagramData[currentLevel];
   if (!data) return;
@@ -77,11 +166,20 @@ function resizeContainers() {
 }
 
 function showLevel(level) {
-  // Hide all layers
+  const previousLevel = currentLevel;
+  const animate = animationDuration > 0 && previousLevel !== level && !overviewVisible;
+  // Slide in the direction of travel: deeper levels come up from below
+  const direction = availableLevels.indexOf(level) > availableLevels.indexOf(previousLevel) ? 1 : -1;
+
+  // Hide all layers (the one being left fades out when animating)
   availableLevels.forEach(l => {
     const layer = document.getElementById('layer-' + l);
     if (layer) {
-      layer.style.display = 'none';
+      if (animate && l === previousLevel && layer.style.display !== 'none') {
+        fadeOutLayer(layer, l, direction);
+      } else {
+        layer.style.display = 'none';
+      }
     }
 
     // Update button styles
@@ -91,10 +189,17 @@ function showLevel(level) {
     }
   });
 
+  // Choosing a level (e.g. by clicking an overview tile) leaves the overview
+  setOverviewVisible(false);
+
   // Show selected layer
   const targetLayer = document.getElementById('layer-' + level);
   if (targetLayer) {
-    targetLayer.style.display = 'block';
+    if (animate) {
+      fadeInLayer(targetLayer, direction);
+    } else {
+      targetLayer.style.display = 'block';
+    }
   }
 
   currentLevel = level;
@@ -106,8 +211,38 @@ function showLevel(level) {
   setupLinkHoverEnhancements();
 }
 
+// fadeInLayer shows a layer, fading it in while sliding it 12px into place (--animate)
+function fadeInLayer(layer, direction) {
+  layer.style.transition = 'none';
+  layer.style.opacity = '0';
+  layer.style.transform = 'translateY(' + (12 * direction) + 'px)';
+  layer.style.display = 'block';
+  layer.getBoundingClientRect(); // force a style flush so the transition starts from here
+
+  layer.style.transition = 'opacity ' + animationDuration + 'ms ease-out, transform ' + animationDuration + 'ms ease-out';
+  layer.style.opacity = '1';
+  layer.style.transform = 'none';
+}
+
+// fadeOutLayer fades a layer out while sliding it away, then hides it unless its
+// level has been shown again in the meantime
+function fadeOutLayer(layer, level, direction) {
+  layer.style.transition = 'opacity ' + animationDuration + 'ms ease-in, transform ' + animationDuration + 'ms ease-in';
+  layer.style.opacity = '0';
+  layer.style.transform = 'translateY(' + (-12 * direction) + 'px)';
+
+  setTimeout(function() {
+    if (currentLevel !== level || overviewVisible) {
+      layer.style.display = 'none';
+    }
+    layer.style.transition = 'none';
+    layer.style.opacity = '';
+    layer.style.transform = '';
+  }, animationDuration);
+}
+
 // Initialize - show context level and setup resize
-showLevel('context');
+showLevel(currentLevel);
 positionRightAlignedElements();
 resizeContainers();
 
@@ -117,6 +252,69 @@ window.addEventListener('resize', function() {
   resizeContainers();
 });
 
+// Printing: fit the visible diagram (or, with --print-all, every level stacked
+// vertically) to the page by giving the outer SVG a viewBox around it
+window.addEventListener('beforeprint', preparePrint);
+window.addEventListener('afterprint', restoreAfterPrint);
+
+function preparePrint() {
+  const mainSVG = document.documentElement;
+
+  if (!printAll) {
+    const container = document.getElementById('container-' + currentLevel);
+    if (!container) return;
+    const x = parseFloat(container.getAttribute('x'));
+    const y = parseFloat(container.getAttribute('y'));
+    const width = parseFloat(container.getAttribute('width'));
+    const height = parseFloat(container.getAttribute('height'));
+    mainSVG.setAttribute('viewBox', x + ' ' + y + ' ' + width + ' ' + height);
+    mainSVG.setAttribute('width', '100%');
+    mainSVG.setAttribute('height', '100%');
+    return;
+  }
+
+  // Show every layer at native size, each shifted below the previous one
+  let offset = 0;
+  let maxWidth = 0;
+  availableLevels.forEach(level => {
+    const data = diagramData[level];
+    const layer = document.getElementById('layer-' + level);
+    const container = document.getElementById('container-' + level);
+    const diagramGroup = document.getElementById('diagram-' + level);
+    const diagramSVG = diagramGroup ? diagramGroup.querySelector('svg') : null;
+    if (!data || !layer || !container || !diagramSVG) return;
+
+    container.setAttribute('width', data.width + 20);
+    container.setAttribute('height', data.height + 20);
+    diagramSVG.setAttribute('width', data.width);
+    diagramSVG.setAttribute('height', data.height);
+
+    layer.style.display = 'block';
+    layer.setAttribute('transform', 'translate(0, ' + offset + ')');
+    offset += data.height + 20 + 20; // container height plus a 20px gap
+    maxWidth = Math.max(maxWidth, data.width + 20);
+  });
+
+  mainSVG.setAttribute('viewBox', '5 145 ' + maxWidth + ' ' + (offset - 20));
+  mainSVG.setAttribute('width', '100%');
+  mainSVG.removeAttribute('height'); // let the stack run across pages
+}
+
+function restoreAfterPrint() {
+  document.documentElement.removeAttribute('viewBox');
+  availableLevels.forEach(level => {
+    const layer = document.getElementById('layer-' + level);
+    if (layer) {
+      layer.removeAttribute('transform');
+      // Re-hide the layers --print-all revealed
+      layer.style.display = level === currentLevel && !overviewVisible ? 'block' : 'none';
+    }
+  });
+
+  // Restore the on-screen sizes
+  resizeContainers();
+}
+
 // Add click handlers for diagram elements to navigate between levels
 function navigateDown() {
   const currentIndex = availableLevels.indexOf(currentLevel);
@@ -125,6 +323,43 @@ function navigateDown() {
   }
 }
 
+// switchStack selects another stack's tab: its nav buttons replace the current
+// ones and its first level is shown
+function switchStack(id) {
+  const stack = stacks.find(s => s.id === id);
+  if (!stack || stack === currentStack) return;
+
+  availableLevels.forEach(l => {
+    const layer = document.getElementById('layer-' + l);
+    if (layer) {
+      layer.style.display = 'none';
+    }
+  });
+
+  stacks.forEach(s => {
+    const nav = document.getElementById('stack-nav-' + s.id);
+    if (nav) {
+      nav.style.display = s === stack ? 'block' : 'none';
+    }
+    const tab = document.getElementById('tab-' + s.id);
+    if (tab) {
+      tab.setAttribute('fill', s === stack ? '#3498db' : '#34495e');
+    }
+  });
+
+  currentStack = stack;
+  availableLevels = stack.levels;
+  currentLevel = availableLevels[0];
+  showLevel(currentLevel);
+  positionRightAlignedElements();
+  resizeContainers();
+}
+
+// showStackLevel shows a level (e.g. 'container') of the current stack
+function showStackLevel(level) {
+  showLevel((currentStack ? currentStack.prefix : '') + level);
+}
+
 function navigateUp() {
   const currentIndex = availableLevels.indexOf(currentLevel);
   if (currentIndex > 0) {
@@ -135,13 +370,11 @@ function navigateUp() {
 function toggleFitMode() {
   fitToWidth = !fitToWidth;
 
-  // Update button text
+  // Update button text (the toggle button may have been omitted)
   const toggleText = document.getElementById('fit-text');
 
-  if (fitToWidth) {
-    toggleText.textContent = 'Auto Scale';
-  } else {
-    toggleText.textContent = 'Native Size';
+  if (toggleText) {
+    toggleText.textContent = fitToWidth ? 'Auto Scale' : 'Native Size';
   }
 
   // Reapply scaling with new mode
@@ -151,29 +384,27 @@ function toggleFitMode() {
 function toggleNotes() {
   notesVisible = !notesVisible;
 
-  // Update button text
+  // Update button text (the toggle button may have been omitted)
   const notesText = document.getElementById('notes-text');
-  notesText.textContent = notesVisible ? 'Hide Notes' : 'Show Notes';
+  if (notesText) {
+    notesText.textContent = notesVisible ? 'Hide Notes' : 'Show Notes';
+  }
 
-  // Find all note elements (PlantUML generates notes with the distinctive yellow fill)
-  // Notes are <g> elements with class="entity" containing paths with fill="#FEFFDD"
+  // Find all note elements (tagged with class="note" by the generator, which
+  // recognises PlantUML's folded-corner note shapes by their yellow fill)
   availableLevels.forEach(level => {
     const layer = document.getElementById('layer-' + level);
     if (layer) {
       const noteIds = [];
-      const noteElements = layer.querySelectorAll('g.entity');
+      const noteElements = layer.querySelectorAll('g.note');
       noteElements.forEach(g => {
-        // Check if this group contains a note (yellow fill path)
-        const notePath = g.querySelector('path[fill="#FEFFDD"]');
-        if (notePath) {
-          g.style.display = notesVisible ? 'block' : 'none';
-          // Extract the entity ID from the note's id attribute (e.g., "entity_GMN49" -> "GMN49")
-          const noteId = g.id;
-          if (noteId) {
-            const match = noteId.match(/entity_(.+)/);
-            if (match) {
-              noteIds.push(match[1]);
-            }
+        g.style.display = notesVisible ? 'block' : 'none';
+        // Extract the entity ID from the note's id attribute (e.g., "entity_GMN49" -> "GMN49")
+        const noteId = g.id;
+        if (noteId) {
+          const match = noteId.match(/entity_(.+)/);
+          if (match) {
+            noteIds.push(match[1]);
           }
         }
       });
@@ -195,14 +426,89 @@ function toggleNotes() {
   });
 }
 
+function toggleLegend() {
+  legendVisible = !legendVisible;
+
+  const legendText = document.getElementById('legend-text');
+  if (legendText) {
+    legendText.textContent = legendVisible ? 'Hide Legend' : 'Show Legend';
+  }
+
+  const legend = document.getElementById('legend-panel');
+  if (legend) {
+    legend.style.display = legendVisible ? 'block' : 'none';
+  }
+}
+
+function toggleOverview() {
+  setOverviewVisible(!overviewVisible);
+
+  const layer = document.getElementById('layer-' + currentLevel);
+  if (layer) {
+    layer.style.display = overviewVisible ? 'none' : 'block';
+  }
+
+  resizeContainers();
+}
+
+function setOverviewVisible(visible) {
+  overviewVisible = visible;
+
+  const overview = document.getElementById('overview');
+  if (overview) {
+    overview.style.display = visible ? 'block' : 'none';
+  }
+
+  const overviewText = document.getElementById('overview-text');
+  if (overviewText) {
+    overviewText.textContent = visible ? 'Close Overview' : 'Overview';
+  }
+}
+
+// layoutOverview arranges the overview tiles in a grid that fits the viewport width
+// and grows the SVG so every row can be scrolled to
+function layoutOverview(mainSVG, viewportWidth, viewportHeight) {
+  // Only the current stack's tiles are shown when several stacks are tabbed
+  document.querySelectorAll('[id^="overview-tile-"]').forEach(tile => {
+    tile.style.display = availableLevels.includes(tile.id.slice('overview-tile-'.length)) ? 'block' : 'none';
+  });
+
+  const tiles = availableLevels
+    .map(level => document.getElementById('overview-tile-' + level))
+    .filter(tile => tile);
+  if (tiles.length === 0) return;
+
+  const tileWidth = parseFloat(tiles[0].querySelector('rect').getAttribute('width'));
+  const tileHeight = parseFloat(tiles[0].querySelector('rect').getAttribute('height'));
+  const gap = 26;
+  const columns = Math.max(1, Math.floor((viewportWidth - gap) / (tileWidth + gap)));
+
+  tiles.forEach((tile, i) => {
+    let column = i % columns;
+    if (rtlLayout) {
+      column = columns - 1 - column;
+    }
+    const x = gap + column * (tileWidth + gap);
+    const y = 150 + Math.floor(i / columns) * (tileHeight + gap);
+    tile.setAttribute('transform', 'translate(' + x + ', ' + y + ')');
+  });
+
+  const rows = Math.ceil(tiles.length / columns);
+  mainSVG.setAttribute('width', viewportWidth);
+  mainSVG.setAttribute('height', Math.max(viewportHeight, 150 + rows * (tileHeight + gap)));
+}
+
 // Progressive enhancement: JavaScript-enhanced link hovering
 function setupLinkHoverEnhancements() {
   const currentLayer = document.getElementById('layer-' + currentLevel);
   if (!currentLayer) return;
 
-  // Remove all <title> elements from the diagram to prevent tooltips
-  const allTitles = currentLayer.querySelectorAll('title');
-  allTitles.forEach(t => t.remove());
+  // Remove all <title> elements from the diagram to prevent tooltips. Only the
+  // diagram's own: the layer's <title> names the level and stays.
+  const diagram = document.getElementById('diagram-' + currentLevel);
+  if (diagram) {
+    diagram.querySelectorAll('title').forEach(t => t.remove());
+  }
 
   const links = currentLayer.querySelectorAll('g.link');
 
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// openCommand returns the command that opens file with its default application
+// on the operating system goos
+func openCommand(goos, file string) (string, []string) {
+	switch goos {
+	case "darwin":
+		return "open", []string{file}
+	case "windows":
+		// start is built into cmd; the empty argument is the window title, which
+		// would otherwise be taken from a quoted file name
+		return "cmd", []string{"/c", "start", "", file}
+	default:
+		return "xdg-open", []string{file}
+	}
+}
+
+// openInBrowser opens file in the default browser for --open, without waiting for it
+func openInBrowser(file string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	name, args := openCommand(runtime.GOOS, abs)
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return fmt.Errorf("--open: %s not found: %w", name, err)
+	}
+	if err := exec.Command(path, args...).Start(); err != nil {
+		return fmt.Errorf("--open: %w", err)
+	}
+	return nil
+}
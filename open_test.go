@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOpenCommand(t *testing.T) {
+	tests := []struct {
+		goos string
+		name string
+		args []string
+	}{
+		{"linux", "xdg-open", []string{"/tmp/out.svg"}},
+		{"freebsd", "xdg-open", []string{"/tmp/out.svg"}},
+		{"darwin", "open", []string{"/tmp/out.svg"}},
+		{"windows", "cmd", []string{"/c", "start", "", "/tmp/out.svg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goos, func(t *testing.T) {
+			name, args := openCommand(tt.goos, "/tmp/out.svg")
+			if name != tt.name || !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("got %s %v, want %s %v", name, args, tt.name, tt.args)
+			}
+		})
+	}
+
+	if opts, err := parseArgsSlice([]string{"dir", "--open"}); err != nil || !opts.Open {
+		t.Errorf("--open: got %v, %v", opts.Open, err)
+	}
+}
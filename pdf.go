@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// pdfMinWidth keeps the header readable on pages for small diagrams
+	pdfMinWidth     = 600
+	pdfHeaderHeight = 64
+	pdfMargin       = 24
+)
+
+// pdfPageSVG is a standalone SVG of a level of stack (one of s's) for a --pdf
+// page: a header with the title and the level's label over the diagram at its
+// native size, without navigation or script
+func (s *SVGStacker) pdfPageSVG(stack *SVGStacker, level, label string) string {
+	diagram := stack.diagrams[level]
+	width := diagram.width + 2*pdfMargin
+	if width < pdfMinWidth {
+		width = pdfMinWidth
+	}
+	height := pdfHeaderHeight + diagram.height + 2*pdfMargin
+	font := xmlEscape(s.fontFamily())
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="%s" height="%s" viewBox="0 0 %s %s">
+`, jsNumber(width), jsNumber(height), jsNumber(width), jsNumber(height)))
+	if s.imageDefs != "" {
+		sb.WriteString("  <defs>\n" + s.imageDefs + "  </defs>\n")
+	}
+	sb.WriteString(fmt.Sprintf(`  <rect x="0" y="0" width="100%%" height="100%%" fill="%s"/>
+  <rect x="0" y="0" width="100%%" height="%d" fill="#2c3e50"/>
+  <text x="%d" y="24" font-family="%s" font-size="13" fill="#bdc3c7">%s</text>
+  <text x="%d" y="50" font-family="%s" font-size="20" font-weight="bold" fill="white">%s</text>
+  <svg viewBox="%s" x="%s" y="%d" width="%s" height="%s"%s>
+    %s
+  </svg>
+</svg>
+`, xmlEscape(stack.containerFill(level)), pdfHeaderHeight,
+		pdfMargin, font, xmlEscape(s.title),
+		pdfMargin, font, xmlEscape(label),
+		xmlEscape(diagram.viewBox), jsNumber((width-diagram.width)/2), pdfHeaderHeight+pdfMargin, jsNumber(diagram.width), jsNumber(diagram.height), diagram.namespaceAttrs(),
+		diagram.content))
+	return sb.String()
+}
+
+// writePDF renders every level of every stack, in level order, to a page of a
+// PDF at file with rsvg-convert, which makes one page per input SVG
+func (s *SVGStacker) writePDF(ctx context.Context, file string) error {
+	if !strings.EqualFold(filepath.Ext(file), ".pdf") {
+		return fmt.Errorf("--pdf %s: the file must end in .pdf", file)
+	}
+	rsvgPath, err := exec.LookPath("rsvg-convert")
+	if err != nil {
+		return withExitCode(exitToolMissing, fmt.Errorf("--pdf needs rsvg-convert in PATH: %w", err))
+	}
+
+	pageDir, err := makeTempDir(s.opts.TempDir, "svg-stacker-pdf-")
+	if err != nil {
+		return err
+	}
+	defer s.removeTemp(pageDir, "PDF pages")
+
+	stacks := s.allStacks()
+	var pages []string
+	for _, stack := range stacks {
+		for _, level := range stack.levelOrder() {
+			if _, ok := stack.diagrams[level]; !ok {
+				continue
+			}
+			label := stack.levelLabel(level)
+			if len(stacks) > 1 {
+				label = stack.label + " / " + label
+			}
+			page := filepath.Join(pageDir, fmt.Sprintf("%02d-%s.svg", len(pages)+1, stack.levelKey(level)))
+			if err := os.WriteFile(page, []byte(s.pdfPageSVG(stack, level, label)), 0644); err != nil {
+				return err
+			}
+			pages = append(pages, page)
+		}
+	}
+	if len(pages) == 0 {
+		return fmt.Errorf("--pdf: no diagrams to render")
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, rsvgPath, append([]string{"--format", "pdf", "--output", file}, pages...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("rsvg-convert failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	logger.Infof("Wrote %d-page PDF to %s\n", len(pages), file)
+	return nil
+}
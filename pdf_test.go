@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPDFPageSVG(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="800" height="400" viewBox="0 0 800 400"><rect id="ctx"/></svg>`
+	os.WriteFile(filepath.Join(dir, "context.svg"), []byte(svg), 0644)
+
+	stacker := NewSVGStackerWithOptions(Options{InputDir: dir, Title: "Shop & Co"})
+	if _, err := stacker.Generate(); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	page := stacker.pdfPageSVG(stacker, "context", "System Context")
+	if err := ValidateXML(page); err != nil {
+		t.Fatalf("page is not valid XML: %v", err)
+	}
+	for _, want := range []string{
+		`width="848" height="512" viewBox="0 0 848 512"`,
+		`>Shop &amp; Co</text>`,
+		`>System Context</text>`,
+		`<svg viewBox="0 0 800 400" x="24" y="88" width="800" height="400"`,
+		`id="ctx"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %s in page:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "<script") {
+		t.Errorf("expected no script in a PDF page")
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script in place of rsvg-convert")
+	}
+	// A stand-in rsvg-convert that concatenates the SVGs it is given into --output
+	bin := t.TempDir()
+	script := "#!/bin/sh\nwhile [ \"$1\" != --output ]; do shift; done\nout=\"$2\"; shift 2\ncat \"$@\" > \"$out\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "rsvg-convert"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	for name, id := range map[string]string{"context.svg": "ctx", "container.svg": "cnt"} {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><rect id="` + id + `"/></svg>`
+		os.WriteFile(filepath.Join(dir, name), []byte(svg), 0644)
+	}
+	out := t.TempDir()
+	pdf := filepath.Join(out, "docs", "architecture.pdf")
+
+	opts := Options{InputDir: dir, OutputFile: filepath.Join(out, "stack.svg"), PDF: pdf}
+	if err := NewSVGStackerWithOptions(opts).CreateStackedSVG(); err != nil {
+		t.Fatalf("CreateStackedSVG failed: %v", err)
+	}
+	data, err := os.ReadFile(pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages := strings.Count(string(data), "<?xml"); pages != 2 {
+		t.Errorf("expected a page per level, got %d", pages)
+	}
+	if ctx, cnt := strings.Index(string(data), `id="ctx"`), strings.Index(string(data), `id="cnt"`); ctx < 0 || cnt < ctx {
+		t.Errorf("expected the context page before the container page")
+	}
+
+	opts.PDF = filepath.Join(out, "architecture.png")
+	if err := NewSVGStackerWithOptions(opts).CreateStackedSVG(); err == nil {
+		t.Errorf("expected a --pdf file not ending in .pdf to be rejected")
+	}
+	t.Setenv("PATH", t.TempDir())
+	opts.PDF = pdf
+	if err := NewSVGStackerWithOptions(opts).CreateStackedSVG(); exitCodeFor(err) != exitToolMissing {
+		t.Errorf("expected a missing rsvg-convert to exit with %d, got %v", exitToolMissing, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// plantumlAlphabet is PlantUML's base64 variant used in server URLs.
+const plantumlAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-_"
+
+// encodePlantUML encodes diagram source for the /svg/<encoded> server endpoint:
+// raw deflate followed by PlantUML's custom base64 alphabet.
+func encodePlantUML(source string) (string, error) {
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := writer.Write([]byte(source)); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return encodePlantUMLBase64(compressed.Bytes()), nil
+}
+
+// encodePlantUMLBase64 packs every 3 bytes into 4 characters. A trailing partial
+// group is zero-padded and still emitted as 4 characters, matching PlantUML.
+func encodePlantUMLBase64(data []byte) string {
+	var sb strings.Builder
+	for i := 0; i < len(data); i += 3 {
+		var b1, b2, b3 byte
+		b1 = data[i]
+		if i+1 < len(data) {
+			b2 = data[i+1]
+		}
+		if i+2 < len(data) {
+			b3 = data[i+2]
+		}
+		sb.WriteByte(plantumlAlphabet[b1>>2])
+		sb.WriteByte(plantumlAlphabet[((b1&0x3)<<4)|(b2>>4)])
+		sb.WriteByte(plantumlAlphabet[((b2&0xF)<<2)|(b3>>6)])
+		sb.WriteByte(plantumlAlphabet[b3&0x3F])
+	}
+	return sb.String()
+}
+
+// renderWithPlantUMLServer fetches an SVG for each .puml file from a PlantUML server
+// and writes it to outputDir using the same naming as the local plantuml binary.
+func renderWithPlantUMLServer(ctx context.Context, serverURL string, pumlFiles []string, outputDir string) error {
+	baseURL := strings.TrimRight(serverURL, "/")
+
+	for _, file := range pumlFiles {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		encoded, err := encodePlantUML(string(source))
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", filepath.Base(file), err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/svg/"+encoded, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("plantuml server request failed for %s: %w", filepath.Base(file), err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read plantuml server response for %s: %w", filepath.Base(file), err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("plantuml server returned %s for %s", resp.Status, filepath.Base(file))
+		}
+
+		if err := os.WriteFile(filepath.Join(outputDir, renderedName(file)), body, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
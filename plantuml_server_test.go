@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// decodePlantUMLBase64 reverses encodePlantUMLBase64 for round-trip testing.
+func decodePlantUMLBase64(t *testing.T, encoded string) []byte {
+	t.Helper()
+	var out []byte
+	for i := 0; i+3 < len(encoded); i += 4 {
+		var c [4]byte
+		for j := 0; j < 4; j++ {
+			idx := strings.IndexByte(plantumlAlphabet, encoded[i+j])
+			if idx < 0 {
+				t.Fatalf("invalid character %q in encoded output", encoded[i+j])
+			}
+			c[j] = byte(idx)
+		}
+		out = append(out, c[0]<<2|c[1]>>4, c[1]<<4|c[2]>>2, c[2]<<6|c[3])
+	}
+	return out
+}
+
+func TestEncodePlantUMLRoundTrip(t *testing.T) {
+	source := "@startuml\nBob -> Alice : hello\n@enduml\n"
+
+	encoded, err := encodePlantUML(source)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	// Zero padding from the final group is ignored by the inflater
+	reader := flate.NewReader(bytes.NewReader(decodePlantUMLBase64(t, encoded)))
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("inflate failed: %v", err)
+	}
+	if string(decoded) != source {
+		t.Errorf("round trip: got %q, want %q", decoded, source)
+	}
+}
+
+func TestEncodePlantUMLBase64(t *testing.T) {
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{[]byte{}, ""},
+		{[]byte{0, 0, 0}, "0000"},
+		{[]byte{0xFF, 0xFF, 0xFF}, "____"},
+		{[]byte{0xFF}, "_m00"},
+	}
+
+	for _, tt := range tests {
+		if got := encodePlantUMLBase64(tt.input); got != tt.expected {
+			t.Errorf("encodePlantUMLBase64(%v): got %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestRenderWithPlantUMLServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/plantuml/svg/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		io.WriteString(w, `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`)
+	}))
+	defer server.Close()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	pumlFile := filepath.Join(inputDir, "01-context.puml")
+	if err := os.WriteFile(pumlFile, []byte("@startuml\nA -> B\n@enduml\n"), 0644); err != nil {
+		t.Fatalf("Failed to write puml: %v", err)
+	}
+
+	if err := renderWithPlantUMLServer(context.Background(), server.URL+"/plantuml/", []string{pumlFile}, outputDir); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(outputDir, "01-context.svg"))
+	if err != nil {
+		t.Fatalf("expected SVG to be written: %v", err)
+	}
+	if !strings.Contains(string(content), "<svg") {
+		t.Errorf("unexpected SVG content: %s", content)
+	}
+}
+
+func TestRenderWithPlantUMLServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "syntax error", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	inputDir := t.TempDir()
+	pumlFile := filepath.Join(inputDir, "01-context.puml")
+	if err := os.WriteFile(pumlFile, []byte("@startuml\nbroken\n@enduml\n"), 0644); err != nil {
+		t.Fatalf("Failed to write puml: %v", err)
+	}
+
+	err := renderWithPlantUMLServer(context.Background(), server.URL, []string{pumlFile}, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "01-context.puml") {
+		t.Errorf("expected error naming the failed file, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// posterLevel is the level rasterized for --poster, falling back to the first
+// level present when there is no context diagram
+func (s *SVGStacker) posterLevel() string {
+	if _, ok := s.diagrams["context"]; ok {
+		return "context"
+	}
+	for _, level := range s.levelOrder() {
+		if _, ok := s.diagrams[level]; ok {
+			return level
+		}
+	}
+	return ""
+}
+
+// posterSVG is a standalone SVG of a level of stack (one of s's), at its native
+// size and on its container fill, without navigation or script
+func (s *SVGStacker) posterSVG(stack *SVGStacker, level string) string {
+	diagram := stack.diagrams[level]
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"` + diagram.namespaceAttrs())
+	sb.WriteString(fmt.Sprintf(` width="%s" height="%s" viewBox="%s">
+`, jsNumber(diagram.width), jsNumber(diagram.height), xmlEscape(diagram.viewBox)))
+	if s.imageDefs != "" {
+		sb.WriteString("  <defs>\n" + s.imageDefs + "  </defs>\n")
+	}
+	sb.WriteString(`  <rect x="-100%" y="-100%" width="300%" height="300%" fill="` + xmlEscape(stack.containerFill(level)) + `"/>
+  ` + diagram.content + `
+</svg>
+`)
+	return sb.String()
+}
+
+// writePoster rasterizes the poster level to a PNG at file with rsvg-convert,
+// for use as a static preview (an OpenGraph image, say) of the stacked SVG
+func (s *SVGStacker) writePoster(ctx context.Context, file string) error {
+	if !strings.EqualFold(filepath.Ext(file), ".png") {
+		return fmt.Errorf("--poster %s: only .png is supported", file)
+	}
+	stack := s.allStacks()[0]
+	level := stack.posterLevel()
+	if level == "" {
+		return fmt.Errorf("--poster: no diagram to rasterize")
+	}
+	rsvgPath, err := exec.LookPath("rsvg-convert")
+	if err != nil {
+		return withExitCode(exitToolMissing, fmt.Errorf("--poster needs rsvg-convert in PATH: %w", err))
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, rsvgPath, "--format", "png", "--output", file)
+	cmd.Stdin = strings.NewReader(s.posterSVG(stack, level))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("rsvg-convert failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	logger.Infof("Wrote %s poster to %s\n", level, file)
+	return nil
+}
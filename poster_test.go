@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPosterSVG(t *testing.T) {
+	dir := t.TempDir()
+	context := `<svg xmlns="http://www.w3.org/2000/svg" width="120" height="60" viewBox="0 0 120 60"><rect id="ctx" width="10" height="10"/></svg>`
+	container := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><rect id="cnt"/></svg>`
+	os.WriteFile(filepath.Join(dir, "context.svg"), []byte(context), 0644)
+	os.WriteFile(filepath.Join(dir, "container.svg"), []byte(container), 0644)
+
+	stacker := NewSVGStackerWithOptions(Options{InputDir: dir})
+	if _, err := stacker.Generate(); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if level := stacker.posterLevel(); level != "context" {
+		t.Errorf("expected the context level, got %q", level)
+	}
+	poster := stacker.posterSVG(stacker, "context")
+	if err := ValidateXML(poster); err != nil {
+		t.Fatalf("poster is not valid XML: %v", err)
+	}
+	for _, want := range []string{`width="120" height="60" viewBox="0 0 120 60"`, `id="ctx"`, `fill="white"`} {
+		if !strings.Contains(poster, want) {
+			t.Errorf("expected %s in poster:\n%s", want, poster)
+		}
+	}
+	if strings.Contains(poster, `id="cnt"`) || strings.Contains(poster, "<script") {
+		t.Errorf("expected only the context diagram, without script")
+	}
+
+	delete(stacker.diagrams, "context")
+	if level := stacker.posterLevel(); level != "container" {
+		t.Errorf("expected the first level present without a context diagram, got %q", level)
+	}
+}
+
+func TestWritePoster(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script in place of rsvg-convert")
+	}
+	// A stand-in rsvg-convert that writes the SVG it is given to --output
+	bin := t.TempDir()
+	script := "#!/bin/sh\nwhile [ \"$1\" != --output ]; do shift; done\ncat > \"$2\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "rsvg-convert"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><rect id="ctx"/></svg>`
+	os.WriteFile(filepath.Join(dir, "context.svg"), []byte(svg), 0644)
+	out := t.TempDir()
+	poster := filepath.Join(out, "social", "preview.png")
+
+	opts := Options{InputDir: dir, OutputFile: filepath.Join(out, "stack.svg"), Poster: poster}
+	if err := NewSVGStackerWithOptions(opts).CreateStackedSVG(); err != nil {
+		t.Fatalf("CreateStackedSVG failed: %v", err)
+	}
+	data, err := os.ReadFile(poster)
+	if err != nil || !strings.Contains(string(data), `id="ctx"`) {
+		t.Errorf("expected the context diagram to be rasterized into %s, got %q, %v", poster, data, err)
+	}
+
+	opts.Poster = filepath.Join(out, "preview.jpg")
+	if err := NewSVGStackerWithOptions(opts).CreateStackedSVG(); err == nil {
+		t.Errorf("expected a non-PNG poster to be rejected")
+	}
+	t.Setenv("PATH", t.TempDir())
+	opts.Poster = poster
+	if err := NewSVGStackerWithOptions(opts).CreateStackedSVG(); exitCodeFor(err) != exitToolMissing {
+		t.Errorf("expected a missing rsvg-convert to exit with %d, got %v", exitToolMissing, err)
+	}
+}
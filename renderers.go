@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Renderer turns the input files of one format into .svg files in outDir, named
+// so extractLevel can tell their C4 level. opts carries the command-line settings;
+// a Renderer should give up when ctx is cancelled.
+type Renderer func(ctx context.Context, files []string, outDir string, opts Options) error
+
+// renderers maps a file extension, such as ".puml", to the Renderer for it
+var renderers = map[string]Renderer{
+	".puml": renderPlantUML,
+}
+
+// RegisterRenderer makes input files with extension ext (including the dot) be
+// rendered by r, replacing any renderer already registered for it
+func RegisterRenderer(ext string, r Renderer) {
+	renderers[strings.ToLower(ext)] = r
+}
+
+// isRenderedSource reports whether name has an extension with a registered Renderer
+func isRenderedSource(name string) bool {
+	_, ok := renderers[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// sourceExts returns the extensions of the registered source formats, sorted
+func sourceExts() []string {
+	exts := make([]string, 0, len(renderers))
+	for ext := range renderers {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// renderSources runs the registered renderers over the matching files in the
+// input directory. If any ran, the rendered SVGs replace the directory as input.
+func (s *SVGStacker) renderSources(ctx context.Context) error {
+	for _, ext := range sourceExts() {
+		matches, err := filepath.Glob(filepath.Join(s.inputDir, "*"+ext))
+		if err != nil {
+			return err
+		}
+		var files []string
+		for _, file := range matches {
+			if s.selected(file) {
+				files = append(files, file)
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		if s.tempDir == "" {
+			if s.tempDir, err = makeTempDir(s.opts.TempDir, "svg-stacker-*"); err != nil {
+				return err
+			}
+		}
+		logger.Debugf("rendering %d %s files\n", len(files), ext)
+		if err := renderers[ext](ctx, files, s.tempDir, s.opts); err != nil {
+			return err
+		}
+	}
+
+	if s.tempDir != "" {
+		s.inputDir = s.tempDir
+	}
+	return nil
+}
+
+// renderPlantUML renders the numbered .puml files (01-*.puml to 04-*.puml) with
+// the local plantuml binary, or a PlantUML server if one is configured. Unless
+// opts.Strict is set, files that fail to render are left out with a warning so
+// the other levels are still stacked.
+func renderPlantUML(ctx context.Context, files []string, outDir string, opts Options) error {
+	pumlFiles := numberedPumlFiles(files)
+	if len(pumlFiles) < 3 {
+		return withExitCode(exitNoInputs, fmt.Errorf("expected at least 3 numbered .puml files (01-*.puml through 03-*.puml), found %d", len(pumlFiles)))
+	}
+
+	timeout := opts.PlantUMLTimeout
+	if timeout <= 0 {
+		timeout = defaultPlantUMLTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var render func(files []string) ([]byte, error)
+	if opts.PlantUMLServer != "" {
+		// Fetch from a PlantUML server instead of the local binary when configured
+		render = func(files []string) ([]byte, error) {
+			err := renderWithPlantUMLServer(ctx, opts.PlantUMLServer, files, outDir)
+			switch ctx.Err() {
+			case context.DeadlineExceeded:
+				return nil, fmt.Errorf("plantuml server timed out after %s", timeout)
+			case context.Canceled:
+				return nil, fmt.Errorf("plantuml server: %w", ctx.Err())
+			}
+			return nil, err
+		}
+	} else {
+		plantumlPath, err := exec.LookPath("plantuml")
+		if err != nil {
+			return withExitCode(exitToolMissing, fmt.Errorf("plantuml not found in PATH: %w", err))
+		}
+		render = func(files []string) ([]byte, error) {
+			args := []string{"-tsvg", "-o", outDir, "-nbthread", "auto"}
+			args = append(args, files...)
+
+			logger.Debugf("running %s %s\n", plantumlPath, strings.Join(args, " "))
+			output, err := exec.CommandContext(ctx, plantumlPath, args...).CombinedOutput()
+			switch ctx.Err() {
+			case context.DeadlineExceeded:
+				return output, fmt.Errorf("plantuml timed out after %s (use --plantuml-timeout to allow longer)", timeout)
+			case context.Canceled:
+				return output, fmt.Errorf("plantuml: %w", ctx.Err())
+			}
+			if err != nil {
+				return output, fmt.Errorf("plantuml failed: %w", err)
+			}
+			return output, nil
+		}
+	}
+
+	// plantuml renders unresolved !includes and syntax errors as an error image
+	// rather than failing, so check the output before it gets stacked
+	renderFiles := render
+	render = func(files []string) ([]byte, error) {
+		output, err := renderFiles(files)
+		if err != nil {
+			return output, err
+		}
+		return output, checkPlantUMLOutput(files, outDir)
+	}
+
+	// Reuse the SVGs cached for unchanged sources and render only the rest
+	toRender := pumlFiles
+	stored := func() error { return nil }
+	if opts.CacheDir != "" {
+		cache := &renderCache{dir: opts.CacheDir, salt: "plantuml " + opts.PlantUMLServer}
+		var err error
+		if toRender, err = cache.restore(pumlFiles, outDir); err != nil {
+			return err
+		}
+		if len(toRender) == 0 {
+			logger.Debugf("all %d files unchanged\n", len(pumlFiles))
+			return nil
+		}
+		stored = func() error { return cache.store(toRender, outDir) }
+	}
+
+	output, err := render(toRender)
+	if err == nil {
+		logger.Debugf("rendered %d files\n", len(toRender))
+		return stored()
+	}
+	if opts.Strict || ctx.Err() != nil {
+		if len(output) > 0 {
+			logger.Errorf("PlantUML output: %s\n", string(output))
+		}
+		return err
+	}
+
+	// Render the files one at a time to find the broken ones, and stack the rest
+	logger.Debugf("%v; rendering files one at a time\n", err)
+	rendered := len(pumlFiles) - len(toRender)
+	for _, file := range toRender {
+		output, fileErr := render([]string{file})
+		if fileErr == nil {
+			rendered++
+			continue
+		}
+		if ctx.Err() != nil {
+			return fileErr
+		}
+		logger.Warnf("Warning: %s failed to render and is left out (use --strict to stop instead): %v\n", filepath.Base(file), fileErr)
+		if len(output) > 0 {
+			logger.Warnf("PlantUML output: %s\n", strings.TrimSpace(string(output)))
+		}
+		// plantuml writes an error image in place of the diagram
+		os.Remove(filepath.Join(outDir, renderedName(file)))
+	}
+	if rendered == 0 {
+		return err
+	}
+	return stored()
+}
+
+// plantumlErrorRegex matches the text PlantUML puts in the error image it
+// renders in place of a diagram it can't parse
+var plantumlErrorRegex = regexp.MustCompile(`(?i)>\s*syntax error\?`)
+
+// checkPlantUMLOutput returns an error naming the first of files whose rendered
+// SVG in outDir is PlantUML's error image
+func checkPlantUMLOutput(files []string, outDir string) error {
+	for _, file := range files {
+		svg := filepath.Join(outDir, renderedName(file))
+		data, err := os.ReadFile(svg)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if plantumlErrorRegex.Match(data) {
+			return fmt.Errorf("%s: plantuml rendered a syntax error (check its !include paths)", filepath.Base(file))
+		}
+	}
+	return nil
+}
+
+// numberedPumlFiles returns the files named 01-*.puml to 04-*.puml (04 being
+// optional), sorted by their number
+func numberedPumlFiles(files []string) []string {
+	var numbered []string
+	numberRegex := regexp.MustCompile(`^0[1-4]-.*\.puml$`)
+
+	for _, file := range files {
+		base := filepath.Base(file)
+		if numberRegex.MatchString(base) {
+			logger.Debugf("found %s\n", file)
+			numbered = append(numbered, file)
+		} else {
+			logger.Debugf("skipping %s: not numbered 01-04\n", file)
+		}
+	}
+
+	sort.Strings(numbered)
+	return numbered
+}
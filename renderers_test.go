@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegisterRenderer(t *testing.T) {
+	var rendered []string
+	RegisterRenderer(".FAKE", func(ctx context.Context, files []string, outDir string, opts Options) error {
+		for _, file := range files {
+			rendered = append(rendered, filepath.Base(file))
+			name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) + ".svg"
+			svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g id="` + opts.Title + `"/></svg>`
+			if err := os.WriteFile(filepath.Join(outDir, name), []byte(svg), 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	defer delete(renderers, ".fake")
+
+	dir := t.TempDir()
+	for _, name := range []string{"context.fake", "container.fake", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("source"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stacker := NewSVGStackerWithOptions(Options{InputDir: dir, Title: "from-fake"})
+	svg, err := stacker.Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !reflect.DeepEqual(rendered, []string{"container.fake", "context.fake"}) {
+		t.Errorf("expected both .fake files to be rendered, got %v", rendered)
+	}
+	if strings.Count(svg, `id="from-fake"`) != 2 {
+		t.Errorf("expected both rendered diagrams in the output")
+	}
+	if stacker.tempDir != "" {
+		t.Errorf("expected the render directory to be cleaned up")
+	}
+	if !isRenderedSource("x.fake") || isRenderedSource("notes.txt") {
+		t.Errorf("isRenderedSource should follow the registry")
+	}
+}
+
+func TestNumberedPumlFiles(t *testing.T) {
+	files := []string{"d/03-component.puml", "d/01-context.puml", "d/05-extra.puml", "d/notes.puml", "d/02-container.puml"}
+	want := []string{"d/01-context.puml", "d/02-container.puml", "d/03-component.puml"}
+	if got := numberedPumlFiles(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := renderPlantUML(context.Background(), files[3:], t.TempDir(), Options{}); exitCodeFor(err) != exitNoInputs {
+		t.Errorf("expected too few numbered files to exit with %d, got %v", exitNoInputs, err)
+	}
+}
+
+func TestRenderTempDir(t *testing.T) {
+	var renderDir string
+	RegisterRenderer(".fake", func(ctx context.Context, files []string, outDir string, opts Options) error {
+		renderDir = outDir
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g/></svg>`
+		return os.WriteFile(filepath.Join(outDir, "context.svg"), []byte(svg), 0644)
+	})
+	defer delete(renderers, ".fake")
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "context.fake"), []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tempParent := filepath.Join(t.TempDir(), "ci", "tmp")
+
+	if _, err := NewSVGStackerWithOptions(Options{InputDir: dir, TempDir: tempParent}).Generate(); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if filepath.Dir(renderDir) != tempParent {
+		t.Errorf("expected rendering under %s, got %s", tempParent, renderDir)
+	}
+	if _, err := os.Stat(renderDir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed after generating", renderDir)
+	}
+
+	if opts, err := parseArgsSlice([]string{dir, "--temp-dir", tempParent}); err != nil || opts.TempDir != tempParent {
+		t.Errorf("--temp-dir: got %q, %v", opts.TempDir, err)
+	}
+}
+
+func TestRelativeTempDir(t *testing.T) {
+	var renderDir string
+	RegisterRenderer(".fake", func(ctx context.Context, files []string, outDir string, opts Options) error {
+		renderDir = outDir
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g/></svg>`
+		return os.WriteFile(filepath.Join(outDir, "context.svg"), []byte(svg), 0644)
+	})
+	defer delete(renderers, ".fake")
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "context.fake"), []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	work, _ := os.Getwd()
+
+	if _, err := NewSVGStackerWithOptions(Options{InputDir: dir, TempDir: "tmp"}).Generate(); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	// A renderer such as plantuml resolves a relative output directory against the
+	// sources, so it must be given an absolute one
+	if !filepath.IsAbs(renderDir) || !strings.HasPrefix(renderDir, filepath.Join(work, "tmp")) {
+		t.Errorf("expected an absolute directory under %s, got %s", filepath.Join(work, "tmp"), renderDir)
+	}
+}
+
+func TestKeepTemp(t *testing.T) {
+	var renderDir string
+	RegisterRenderer(".fake", func(ctx context.Context, files []string, outDir string, opts Options) error {
+		renderDir = outDir
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g/></svg>`
+		return os.WriteFile(filepath.Join(outDir, "context.svg"), []byte(svg), 0644)
+	})
+	defer delete(renderers, ".fake")
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "context.fake"), []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	defer func(l *Logger) { logger = l }(logger)
+	logger = &Logger{level: LogNormal, out: &buf}
+
+	if _, err := NewSVGStackerWithOptions(Options{InputDir: dir, TempDir: t.TempDir(), KeepTemp: true}).Generate(); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(renderDir, "context.svg")); err != nil {
+		t.Errorf("expected the rendered SVG to be kept: %v", err)
+	}
+	if !strings.Contains(buf.String(), renderDir) {
+		t.Errorf("expected the kept directory to be printed, got %q", buf.String())
+	}
+
+	if opts, err := parseArgsSlice([]string{dir, "--keep-temp"}); err != nil || !opts.KeepTemp {
+		t.Errorf("--keep-temp: got %v, %v", opts.KeepTemp, err)
+	}
+}
+
+func TestRenderPlantUMLLeavesOutFailures(t *testing.T) {
+	dir := t.TempDir()
+	sources := map[string]string{
+		"01-context.puml":   "@startuml\nA -> B\n@enduml",
+		"02-container.puml": "@startuml\nbroken\n@enduml",
+		"03-component.puml": "@startuml\nC -> D\n@enduml",
+	}
+	var files []string
+	for name, source := range sources {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	broken, err := encodePlantUML(sources["02-container.puml"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/"+broken) {
+			http.Error(w, "Syntax Error?", http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><g/></svg>`)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	defer func(l *Logger) { logger = l }(logger)
+	logger = &Logger{level: LogNormal, out: &buf}
+
+	outDir := t.TempDir()
+	if err := renderPlantUML(context.Background(), files, outDir, Options{PlantUMLServer: server.URL}); err != nil {
+		t.Fatalf("expected the working files to render, got %v", err)
+	}
+	for name, want := range map[string]bool{"01-context.svg": true, "02-container.svg": false, "03-component.svg": true} {
+		if _, err := os.Stat(filepath.Join(outDir, name)); (err == nil) != want {
+			t.Errorf("%s: expected rendered %v, got %v", name, want, err)
+		}
+	}
+	if !strings.Contains(buf.String(), "02-container.puml failed to render") {
+		t.Errorf("expected a warning naming the broken file, got %q", buf.String())
+	}
+
+	if err := renderPlantUML(context.Background(), files, t.TempDir(), Options{PlantUMLServer: server.URL, Strict: true}); err == nil {
+		t.Errorf("expected --strict to fail on the broken file")
+	}
+	if opts, err := parseArgsSlice([]string{dir, "--strict"}); err != nil || !opts.Strict {
+		t.Errorf("--strict: got %v, %v", opts.Strict, err)
+	}
+}
+
+func TestCheckPlantUMLOutput(t *testing.T) {
+	errorSVG, err := os.ReadFile(filepath.Join("testdata", "plantuml", "syntax-error.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outDir := t.TempDir()
+	files := []string{"d/01-context.puml", "d/02-container.puml", "d/03-component.puml"}
+	os.WriteFile(filepath.Join(outDir, "01-context.svg"), []byte(`<svg><text>Syntax errors are logged</text></svg>`), 0644)
+	os.WriteFile(filepath.Join(outDir, "02-container.svg"), errorSVG, 0644)
+
+	err = checkPlantUMLOutput(files, outDir)
+	if err == nil || !strings.Contains(err.Error(), "02-container.puml") {
+		t.Errorf("expected an error naming 02-container.puml, got %v", err)
+	}
+	if err := checkPlantUMLOutput(files[:1], outDir); err != nil {
+		t.Errorf("expected a diagram that mentions syntax errors to pass, got %v", err)
+	}
+
+	// The error image is treated as a failed render
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(errorSVG)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	for i, name := range []string{"01-context.puml", "02-container.puml", "03-component.puml"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(fmt.Sprintf("@startuml\n!include missing%d.puml\n@enduml", i)), 0644)
+		files[i] = filepath.Join(dir, name)
+	}
+	if err := renderPlantUML(context.Background(), files, t.TempDir(), Options{PlantUMLServer: server.URL, Strict: true}); err == nil || !strings.Contains(err.Error(), "01-context.puml") {
+		t.Errorf("expected the error image to fail rendering, got %v", err)
+	}
+}
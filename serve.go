@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const defaultServeAddr = ":8080"
+
+// ServeOptions holds the configuration for the 'serve' subcommand
+type ServeOptions struct {
+	Addr    string
+	Stacker Options // how to stack; InputDir is the directory being served
+}
+
+// parseServeArgs parses the arguments following 'serve'. --dir and --addr are
+// specific to serving; everything else is passed on as stacking options.
+func parseServeArgs(args []string) (ServeOptions, error) {
+	opts := ServeOptions{Addr: defaultServeAddr}
+	var dir string
+	var rest []string
+	var err error
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--dir":
+			if dir, err = flagValue(args, &i); err != nil {
+				return ServeOptions{}, err
+			}
+		case "--addr":
+			if opts.Addr, err = flagValue(args, &i); err != nil {
+				return ServeOptions{}, err
+			}
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	if dir == "" {
+		return ServeOptions{}, fmt.Errorf("serve requires --dir")
+	}
+	if opts.Stacker, err = parseArgsSlice(append([]string{dir}, rest...)); err != nil {
+		return ServeOptions{}, err
+	}
+	if outputFlagged(opts.Stacker) {
+		return ServeOptions{}, fmt.Errorf("--output and --output-dir cannot be used with serve")
+	}
+	opts.Stacker.OutputFile = ""
+	return opts, nil
+}
+
+// runServeCommand serves the stacked SVG for a directory until the server fails
+func runServeCommand(opts ServeOptions) error {
+	logger.Infof("Serving %s on %s\n", opts.Stacker.InputDir, opts.Addr)
+	return http.ListenAndServe(opts.Addr, newStackHandler(opts.Stacker))
+}
+
+// stackHandler serves the stacked SVG at "/", regenerating it whenever the
+// diagram sources in the input directory, or the --css and --js files, change
+type stackHandler struct {
+	opts Options
+
+	mu          sync.Mutex
+	fingerprint string // of the sources svg was generated from
+	svg         string
+}
+
+func newStackHandler(opts Options) *stackHandler {
+	return &stackHandler{opts: opts}
+}
+
+func (h *stackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	svg, err := h.current(r.Context())
+	if err != nil {
+		logger.Errorf("Error: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	io.WriteString(w, svg)
+}
+
+// current returns the stacked SVG, regenerating it if the sources have changed.
+// Regeneration is abandoned if ctx, the request's, is cancelled.
+func (h *stackHandler) current(ctx context.Context) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var fingerprint string
+	for _, dir := range append([]string{h.opts.InputDir}, h.opts.MoreInputDirs...) {
+		dirFingerprint, err := sourceFingerprint(dir)
+		if err != nil {
+			return "", err
+		}
+		fingerprint += dir + "\n" + dirFingerprint
+	}
+	for _, file := range append([]string{h.opts.JSFile}, h.opts.CSSFiles...) {
+		if file == "" {
+			continue
+		}
+		fileFingerprint, err := statFingerprint(file)
+		if err != nil {
+			return "", err
+		}
+		fingerprint += fileFingerprint
+	}
+	if h.svg != "" && fingerprint == h.fingerprint {
+		return h.svg, nil
+	}
+
+	svg, err := NewSVGStackerWithOptions(h.opts).GenerateContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	logger.Infof("Regenerated stacked SVG from %s\n", h.opts.InputDir)
+	h.fingerprint, h.svg = fingerprint, svg
+	return svg, nil
+}
+
+// sourceFingerprint summarises the name, size and modification time of every
+// .svg and renderable source file in dir, and of its .svg-stackerignore, so edits
+// can be detected without reading them. An archive is summarised as a whole.
+func sourceFingerprint(dir string) (string, error) {
+	if isArchive(dir) {
+		return statFingerprint(dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.EqualFold(filepath.Ext(name), ".svg") || isRenderedSource(name) || name == ignoreFileName) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// statFingerprint summarises the size and modification time of a single file
+func statFingerprint(file string) (string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d %d\n", file, info.Size(), info.ModTime().UnixNano()), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseServeArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		expectDir string
+		addr      string
+		expectErr bool
+	}{
+		{"defaults", []string{"--dir", "docs/c4"}, "docs/c4", defaultServeAddr, false},
+		{"addr and stack options", []string{"--addr", "127.0.0.1:9000", "--dir", "docs/c4", "--title", "Live"}, "docs/c4", "127.0.0.1:9000", false},
+		{"missing dir", []string{"--addr", ":9000"}, "", "", true},
+		{"output not allowed", []string{"--dir", "docs/c4", "--output", "out.svg"}, "", "", true},
+		{"unknown flag", []string{"--dir", "docs/c4", "--bogus"}, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseServeArgs(tt.args)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("error: got %v, expectErr %v", err, tt.expectErr)
+			}
+			if err != nil {
+				return
+			}
+			if opts.Stacker.InputDir != tt.expectDir || opts.Addr != tt.addr {
+				t.Errorf("got dir %q addr %q, want %q %q", opts.Stacker.InputDir, opts.Addr, tt.expectDir, tt.addr)
+			}
+		})
+	}
+
+	if opts, _ := parseServeArgs([]string{"--dir", "d", "--title", "Live"}); opts.Stacker.Title != "Live" {
+		t.Errorf("expected stacking options to be passed through, got %+v", opts.Stacker)
+	}
+}
+
+func TestStackHandler(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "context.svg")
+	writeDiagram := func(id string, modTime time.Time) {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><rect id="` + id + `"/></svg>`
+		if err := os.WriteFile(file, []byte(svg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := newStackHandler(Options{InputDir: dir})
+	get := func(path string) (*http.Response, string) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		body, _ := io.ReadAll(rec.Result().Body)
+		return rec.Result(), string(body)
+	}
+
+	writeDiagram("first", time.Now().Add(-time.Hour))
+
+	resp, body := get("/")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("got %d %q: %s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	if !strings.Contains(body, `id="first"`) {
+		t.Errorf("expected the diagram in the response")
+	}
+
+	// A change to the sources is picked up on the next request
+	writeDiagram("second", time.Now())
+	if _, body := get("/"); !strings.Contains(body, `id="second"`) || strings.Contains(body, `id="first"`) {
+		t.Errorf("expected the regenerated diagram after a change")
+	}
+
+	if resp, _ := get("/other"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for other paths, got %d", resp.StatusCode)
+	}
+
+	// Generation failures are reported as a 500 with the error text
+	os.Remove(file)
+	resp, body = get("/")
+	if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(body, "no C4 SVG files found") {
+		t.Errorf("expected 500 with the error, got %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestStackHandlerWatchesOtherInputs(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50"><rect id="ctx"/></svg>`
+	os.WriteFile(filepath.Join(dir, "context.svg"), []byte(svg), 0644)
+	os.WriteFile(filepath.Join(dir, "container.svg"), []byte(svg), 0644)
+	css := filepath.Join(t.TempDir(), "extra.css")
+	write := func(file, content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(css, ".first { }", time.Now().Add(-time.Hour))
+
+	handler := newStackHandler(Options{InputDir: dir, CSSFiles: []string{css}})
+	get := func() string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		body, _ := io.ReadAll(rec.Result().Body)
+		return string(body)
+	}
+	if body := get(); !strings.Contains(body, ".first") || !strings.Contains(body, `id="diagram-container"`) {
+		t.Fatalf("expected the stylesheet and both levels in the first response")
+	}
+
+	write(css, ".second { }", time.Now())
+	if body := get(); !strings.Contains(body, ".second") {
+		t.Errorf("expected a change to the --css file to be picked up")
+	}
+	write(filepath.Join(dir, ignoreFileName), "container.svg\n", time.Now())
+	if body := get(); strings.Contains(body, `id="diagram-container"`) {
+		t.Errorf("expected a new %s to be picked up", ignoreFileName)
+	}
+
+	// An archive is watched as a single file
+	archive := filepath.Join(t.TempDir(), "diagrams.zip")
+	writeTestZip(t, archive, map[string]string{"context.svg": svg})
+	handler = newStackHandler(Options{InputDir: archive})
+	if body := get(); !strings.Contains(body, `id="ctx"`) {
+		t.Errorf("expected an archive input to be served, got %s", body)
+	}
+}
@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+)
+
+// svgNamespaces returns the prefix to URL mapping of every xmlns:* declaration
+// in an <svg> start tag
+func svgNamespaces(svgTag string) map[string]string {
+	declRegex := regexp.MustCompile(`(?:^|\s)xmlns:([\w.-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	namespaces := make(map[string]string)
+	for _, m := range declRegex.FindAllStringSubmatch(svgTag, -1) {
+		namespaces[m[1]] = m[2] + m[3]
+	}
+	return namespaces
+}
+
+// namespaceAttrs renders namespaces as sorted xmlns:* attributes, each with a leading space
+func namespaceAttrs(namespaces map[string]string) string {
+	prefixes := make([]string, 0, len(namespaces))
+	for prefix := range namespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	var b strings.Builder
+	for _, prefix := range prefixes {
+		b.WriteString(" xmlns:" + prefix + `="`)
+		xml.EscapeText(&b, []byte(namespaces[prefix]))
+		b.WriteString(`"`)
+	}
+	return b.String()
+}
+
+// rawXML is markup that transformXML copies verbatim from the source rather than
+// re-encoding: CDATA sections, which xml.Decoder reports as plain CharData and
+// xml.Encoder would escape, and <foreignObject> subtrees, whose embedded HTML
+// namespaces the encoder would rewrite.
+type rawXML string
+
+// transformXML walks content (the body of an <svg> element) as an XML token stream,
+// passes each token through fn and re-encodes whatever fn returns. A nil fn copies
+// tokens unchanged. namespaces are the xmlns:* declarations of the enclosing <svg>
+// (xlink is always included); prefixed names are written back with their original
+// prefix and no declaration, so the output must be placed where those are declared.
+// Declarations on nested elements that repeat one already in scope (such as a
+// stray xmlns="http://www.w3.org/2000/svg") are dropped; different ones are kept.
+func transformXML(content string, namespaces map[string]string, indent bool, fn func(xml.Token) []xml.Token) (string, error) {
+	// Wrap in a root element with namespace declarations for parsing.
+	// IMPORTANT: the content is extracted from inside an <svg> tag, which declares
+	// the prefixes it uses (xlink:, inkscape:, ...). Without those declarations Go's
+	// xml.Encoder mangles prefixed attributes, e.g. changing xmlns:xlink to "xlink",
+	// which breaks <image> elements that use xlink:href for embedded data URIs.
+	declared := map[string]string{"xlink": xlinkNamespace}
+	for prefix, url := range namespaces {
+		declared[prefix] = url
+	}
+	prefixFor := make(map[string]string, len(declared)+1)
+	for prefix, url := range declared {
+		prefixFor[url] = prefix
+	}
+	// The output is embedded in an SVG document, where SVG is the default namespace
+	prefixFor[svgNamespace] = ""
+	scopes := []map[string]string{prefixFor}
+	wrapped := "<root" + namespaceAttrs(declared) + ">" + content + "</root>"
+
+	var buf bytes.Buffer
+	decoder := xml.NewDecoder(strings.NewReader(wrapped))
+	encoder := xml.NewEncoder(&buf)
+	if indent {
+		encoder.Indent("      ", "  ")
+	}
+
+	depth := 0
+	var offset int64
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		raw := wrapped[offset:decoder.InputOffset()]
+		offset = decoder.InputOffset()
+
+		// Skip the root wrapper element
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				continue
+			}
+			if t.Name.Local == "foreignObject" {
+				start := offset - int64(len(raw))
+				if err := decoder.Skip(); err != nil {
+					return "", err
+				}
+				offset = decoder.InputOffset()
+				depth--
+				token = rawXML(wrapped[start:offset])
+				break
+			}
+			// The encoder would emit mangled "_xmlns" declarations for these
+			var scope map[string]string
+			t, scope = withNamespaceScope(t, scopes[len(scopes)-1])
+			scopes = append(scopes, scope)
+			t.Name = prefixedName(t.Name, scope)
+			for i := range t.Attr {
+				t.Attr[i].Name = prefixedName(t.Attr[i].Name, scope)
+			}
+			token = t
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				continue
+			}
+			t.Name = prefixedName(t.Name, scopes[len(scopes)-1])
+			scopes = scopes[:len(scopes)-1]
+			token = t
+		case xml.CharData:
+			if strings.HasPrefix(raw, "<![CDATA[") {
+				token = rawXML(raw)
+			}
+		}
+
+		out := []xml.Token{token}
+		if fn != nil {
+			out = fn(token)
+		}
+		for _, t := range out {
+			if verbatim, ok := t.(rawXML); ok {
+				if err := encoder.Flush(); err != nil {
+					return "", err
+				}
+				buf.WriteString(string(verbatim))
+				continue
+			}
+			if start, ok := t.(xml.StartElement); ok {
+				t = withCanonicalAttrOrder(start)
+			}
+			if err := encoder.EncodeToken(t); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// attrPriority lists the attributes written first, in this order; the rest follow
+// alphabetically
+var attrPriority = []string{"id", "class"}
+
+// withCanonicalAttrOrder returns start with its attributes in a fixed order:
+// namespace declarations, then attrPriority, then the rest by name. Attribute
+// order is otherwise whatever the source and the rewrites left, so sorting keeps
+// regenerated output byte-stable.
+func withCanonicalAttrOrder(start xml.StartElement) xml.StartElement {
+	rank := func(attr xml.Attr) int {
+		if attr.Name.Local == "xmlns" || strings.HasPrefix(attr.Name.Local, "xmlns:") {
+			return 0
+		}
+		for i, name := range attrPriority {
+			if attr.Name.Local == name {
+				return i + 1
+			}
+		}
+		return len(attrPriority) + 1
+	}
+
+	attrs := slices.Clone(start.Attr)
+	slices.SortStableFunc(attrs, func(a, b xml.Attr) int {
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra - rb
+		}
+		return strings.Compare(a.Name.Local, b.Name.Local)
+	})
+	start.Attr = attrs
+	return start
+}
+
+// prefixedName turns a namespaced name back into its literal "prefix:local" form so
+// the encoder writes it unchanged. Undeclared prefixes, which the decoder leaves
+// as the Space, are kept as they are too.
+func prefixedName(name xml.Name, prefixFor map[string]string) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	if prefix, ok := prefixFor[name.Space]; ok {
+		if prefix == "" {
+			return xml.Name{Local: name.Local}
+		}
+		return xml.Name{Local: prefix + ":" + name.Local}
+	}
+	if !strings.ContainsAny(name.Space, ":/") {
+		return xml.Name{Local: name.Space + ":" + name.Local}
+	}
+	return name
+}
+
+// withNamespaceScope applies the namespace declarations on start to scope (URL to
+// prefix, "" for the default namespace). Declarations of a namespace already in
+// scope are dropped; others are kept as literal xmlns attributes and returned in a
+// new scope.
+func withNamespaceScope(start xml.StartElement, scope map[string]string) (xml.StartElement, map[string]string) {
+	attrs := start.Attr[:0:0]
+	for _, attr := range start.Attr {
+		var prefix string
+		switch {
+		case attr.Name.Space == "xmlns":
+			prefix = attr.Name.Local
+		case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+			prefix = ""
+		default:
+			attrs = append(attrs, attr)
+			continue
+		}
+		if _, ok := scope[attr.Value]; ok {
+			continue // already declared; names are written with the prefix in scope
+		}
+
+		// Copy on write so the parent scope is restored when this element ends
+		next := make(map[string]string, len(scope)+1)
+		for url, p := range scope {
+			if p != prefix {
+				next[url] = p
+			}
+		}
+		next[attr.Value] = prefix
+		scope = next
+
+		name := "xmlns"
+		if prefix != "" {
+			name += ":" + prefix
+		}
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: name}, Value: attr.Value})
+	}
+	start.Attr = attrs
+	return start, scope
+}
+
+// diagramCleaner is a transformXML filter that drops <script> subtrees, event
+// handler attributes (including those in verbatim <foreignObject> markup) and
+// (unless keepMetadata is set) editor metadata, adds a "note" class to PlantUML
+// note groups so navigation.js can toggle them, and turns
+// links into onclick navigation. A link that directly
+// follows a <g> start tag puts the handler on that group; any other link gets a
+// synthesized <g> wrapper. Links nested inside another link are unwrapped so a
+// single click only navigates once, as are links backLink reports as pointing
+// back up the hierarchy. With keepLinks set, <a> elements are left as real
+// hyperlinks instead.
+type diagramCleaner struct {
+	keepLinks    bool
+	keepMetadata bool
+	skipDepth    int         // >0 while inside a dropped <script> or metadata element
+	closers      []xml.Token // what each open <a> turns into when it closes (nil to drop)
+	pending      []xml.Token // a <g> start and following whitespace, held in case a link follows
+
+	// backLink, if set, reports whether a link points to the current level or one
+	// above it, which navigating down would misrepresent
+	backLink func(href string) bool
+
+	entity      []xml.Token // output of an entity group, held until its end shows whether it is a note
+	entityDepth int         // >0 while inside that group
+	isNote      bool        // whether it has a note-coloured <path>
+}
+
+func (c *diagramCleaner) token(tok xml.Token) []xml.Token {
+	return c.markNotes(c.clean(tok))
+}
+
+func (c *diagramCleaner) clean(tok xml.Token) []xml.Token {
+	if c.skipDepth > 0 {
+		switch tok.(type) {
+		case xml.StartElement:
+			c.skipDepth++
+		case xml.EndElement:
+			c.skipDepth--
+		}
+		return nil
+	}
+
+	switch t := tok.(type) {
+	case xml.StartElement:
+		t.Attr = withoutEventAttrs(t.Attr)
+		if !c.keepMetadata {
+			if isEditorMetadata(t.Name.Local) {
+				c.skipDepth = 1
+				return c.flush()
+			}
+			t.Attr = withoutEditorAttrs(t.Attr)
+		}
+		switch t.Name.Local {
+		case "script":
+			c.skipDepth = 1
+			return c.flush()
+		case "a":
+			if !c.keepLinks {
+				return c.openLink(t)
+			}
+		case "g":
+			out := c.flush()
+			c.pending = []xml.Token{t.Copy()}
+			return out
+		}
+		tok = t
+	case xml.EndElement:
+		if t.Name.Local == "a" && !c.keepLinks && len(c.closers) > 0 {
+			closer := c.closers[len(c.closers)-1]
+			c.closers = c.closers[:len(c.closers)-1]
+			if closer == nil {
+				return c.flush()
+			}
+			return append(c.flush(), closer)
+		}
+	case xml.CharData:
+		if len(c.pending) > 0 && len(bytes.TrimSpace(t)) == 0 {
+			c.pending = append(c.pending, t.Copy())
+			return nil
+		}
+	case rawXML:
+		if !strings.HasPrefix(string(t), "<![CDATA[") {
+			tok = rawXML(withoutRawScripts(string(t)))
+		}
+	}
+	return append(c.flush(), xml.CopyToken(tok))
+}
+
+// noteFillColors are the fills PlantUML uses for the folded-corner note shape.
+var noteFillColors = []string{"#FEFFDD"}
+
+// markNotes passes on cleaned output, holding back each <g class="entity"> until
+// it closes: one whose body contains a <path> filled with a note colour is a note.
+func (c *diagramCleaner) markNotes(toks []xml.Token) []xml.Token {
+	var out []xml.Token
+	for _, tok := range toks {
+		if c.entityDepth == 0 {
+			if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "g" && hasClass(start, "entity") {
+				c.entity, c.entityDepth, c.isNote = []xml.Token{start}, 1, false
+			} else {
+				out = append(out, tok)
+			}
+			continue
+		}
+
+		c.entity = append(c.entity, tok)
+		switch t := tok.(type) {
+		case xml.StartElement:
+			c.entityDepth++
+			if t.Name.Local == "path" && isNoteFill(t) {
+				c.isNote = true
+			}
+		case xml.EndElement:
+			c.entityDepth--
+		}
+		if c.entityDepth == 0 {
+			if c.isNote {
+				c.entity[0] = withClass(c.entity[0].(xml.StartElement), "note")
+			}
+			out = append(out, c.entity...)
+			c.entity = nil
+		}
+	}
+	return out
+}
+
+func isNoteFill(path xml.StartElement) bool {
+	for _, attr := range path.Attr {
+		if attr.Name.Space == "" && attr.Name.Local == "fill" {
+			return slices.ContainsFunc(noteFillColors, func(fill string) bool { return strings.EqualFold(attr.Value, fill) })
+		}
+	}
+	return false
+}
+
+// withClass returns start with class added to its class attribute
+func withClass(start xml.StartElement, class string) xml.StartElement {
+	attrs := slices.Clone(start.Attr)
+	for i, attr := range attrs {
+		if attr.Name.Space == "" && attr.Name.Local == "class" {
+			attrs[i].Value = strings.TrimSpace(attr.Value + " " + class)
+			start.Attr = attrs
+			return start
+		}
+	}
+	start.Attr = append(attrs, xml.Attr{Name: xml.Name{Local: "class"}, Value: class})
+	return start
+}
+
+func (c *diagramCleaner) openLink(a xml.StartElement) []xml.Token {
+	var closer xml.Token
+	var out []xml.Token
+	if len(c.closers) == 0 && hasHref(a) && (c.backLink == nil || !c.backLink(hrefOf(a))) {
+		if g, ok := c.pendingGroup(); ok && !hasAttr(g, "onclick") {
+			c.pending[0] = withNavigateDown(g)
+			out = c.flush()
+		} else {
+			g := xml.StartElement{Name: xml.Name{Local: "g"}}
+			out = append(c.flush(), withNavigateDown(g))
+			closer = xml.EndElement{Name: g.Name}
+		}
+	} else {
+		out = c.flush()
+	}
+	c.closers = append(c.closers, closer)
+	return out
+}
+
+func (c *diagramCleaner) pendingGroup() (xml.StartElement, bool) {
+	if len(c.pending) == 0 {
+		return xml.StartElement{}, false
+	}
+	g, ok := c.pending[0].(xml.StartElement)
+	return g, ok
+}
+
+func (c *diagramCleaner) flush() []xml.Token {
+	out := c.pending
+	c.pending = nil
+	return out
+}
+
+// withNavigateDown makes an element clickable, merging into an existing style
+func withNavigateDown(start xml.StartElement) xml.StartElement {
+	start.Attr = append([]xml.Attr{}, start.Attr...)
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "onclick"}, Value: "navigateDown()"})
+	for i, attr := range start.Attr {
+		if attr.Name.Space == "" && attr.Name.Local == "style" {
+			style := strings.TrimSpace(attr.Value)
+			if style != "" && !strings.HasSuffix(style, ";") {
+				style += ";"
+			}
+			start.Attr[i].Value = style + "cursor:pointer;"
+			return start
+		}
+	}
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "style"}, Value: "cursor:pointer;"})
+	return start
+}
+
+// linkLabelLineHeight is the spacing of the lines linkLabelWrapper splits a
+// label into, in ems
+const linkLabelLineHeight = 1.2
+
+// linkLabelWrapper is a transformXML filter that splits the text of <text>
+// elements inside class="link" groups into <tspan> lines of at most maxChars
+// characters, breaking between words. The lines grow upwards from the original
+// baseline, so they stay clear of a technology line PlantUML puts below the label.
+// Labels with markup of their own are left as they are.
+type linkLabelWrapper struct {
+	maxChars int
+	inLink   []bool      // for each open element, whether it is inside a link group
+	text     []xml.Token // a link's <text> start and its character data, held until it closes
+}
+
+func (w *linkLabelWrapper) token(tok xml.Token) []xml.Token {
+	inLink := len(w.inLink) > 0 && w.inLink[len(w.inLink)-1]
+	switch t := tok.(type) {
+	case xml.StartElement:
+		w.inLink = append(w.inLink, inLink || (t.Name.Local == "g" && hasClass(t, "link")))
+		if w.text != nil {
+			return append(w.flush(), t.Copy())
+		}
+		if inLink && t.Name.Local == "text" {
+			w.text = []xml.Token{t.Copy()}
+			return nil
+		}
+	case xml.EndElement:
+		w.inLink = w.inLink[:len(w.inLink)-1]
+		if w.text != nil {
+			return append(w.wrapped(), t)
+		}
+	case xml.CharData:
+		if w.text != nil {
+			w.text = append(w.text, t.Copy())
+			return nil
+		}
+	default:
+		if w.text != nil {
+			return append(w.flush(), xml.CopyToken(tok))
+		}
+	}
+	return []xml.Token{xml.CopyToken(tok)}
+}
+
+func (w *linkLabelWrapper) flush() []xml.Token {
+	out := w.text
+	w.text = nil
+	return out
+}
+
+// wrapped returns the held <text> start and its content as <tspan> lines, or as
+// they were if the label fits on one line
+func (w *linkLabelWrapper) wrapped() []xml.Token {
+	held := w.flush()
+	var label strings.Builder
+	for _, tok := range held[1:] {
+		label.Write(tok.(xml.CharData))
+	}
+	lines := wrapWords(label.String(), w.maxChars)
+	if len(lines) < 2 {
+		return held
+	}
+
+	// PlantUML fits each label to its measured width with textLength, which would
+	// squash every line to that width
+	start := held[0].(xml.StartElement)
+	x, hasX := "", false
+	var attrs []xml.Attr
+	for _, attr := range start.Attr {
+		switch attr.Name.Local {
+		case "textLength", "lengthAdjust":
+			continue
+		case "x":
+			x, hasX = attr.Value, true
+		}
+		attrs = append(attrs, attr)
+	}
+	start.Attr = attrs
+
+	out := []xml.Token{start}
+	tspan := xml.Name{Local: "tspan"}
+	for i, line := range lines {
+		dy := fmt.Sprintf("%gem", linkLabelLineHeight)
+		if i == 0 {
+			dy = fmt.Sprintf("-%gem", linkLabelLineHeight*float64(len(lines)-1))
+		}
+		lineStart := xml.StartElement{Name: tspan, Attr: []xml.Attr{{Name: xml.Name{Local: "dy"}, Value: dy}}}
+		if hasX {
+			lineStart.Attr = append(lineStart.Attr, xml.Attr{Name: xml.Name{Local: "x"}, Value: x})
+		}
+		out = append(out, lineStart, xml.CharData(line), xml.EndElement{Name: tspan})
+	}
+	return out
+}
+
+// wrapWords breaks text into lines of at most maxChars characters between words;
+// a word longer than that gets a line of its own
+func wrapWords(text string, maxChars int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= maxChars:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// hasClass reports whether start's class attribute lists class
+func hasClass(start xml.StartElement, class string) bool {
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "" && attr.Name.Local == "class" {
+			return slices.Contains(strings.Fields(attr.Value), class)
+		}
+	}
+	return false
+}
+
+// withoutEventAttrs drops on* event handler attributes such as onclick or onmouseover
+func withoutEventAttrs(attrs []xml.Attr) []xml.Attr {
+	kept := attrs[:0:0]
+	for _, attr := range attrs {
+		if attr.Name.Space == "" && isEventAttr(attr.Name.Local) {
+			continue
+		}
+		kept = append(kept, attr)
+	}
+	return kept
+}
+
+// isEventAttr reports whether an unprefixed attribute name is an event handler
+// such as onclick
+func isEventAttr(name string) bool {
+	return len(name) > 2 && strings.HasPrefix(strings.ToLower(name), "on")
+}
+
+// rawAttrRegex matches the next attribute of a start tag, with the whitespace before it
+var rawAttrRegex = regexp.MustCompile(`^\s+([^\s=/>]+)\s*=\s*(?:"[^"]*"|'[^']*')`)
+
+// withoutRawScripts drops <script> subtrees and event handler attributes from
+// markup transformXML copies verbatim (a <foreignObject> subtree), leaving the
+// rest of it byte for byte as it was. Markup that doesn't parse is dropped.
+func withoutRawScripts(raw string) string {
+	var b strings.Builder
+	decoder := xml.NewDecoder(strings.NewReader(raw))
+	var offset int64
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return b.String()
+		}
+		if err != nil {
+			return ""
+		}
+		text := raw[offset:decoder.InputOffset()]
+		offset = decoder.InputOffset()
+
+		start, ok := token.(xml.StartElement)
+		switch {
+		case ok && start.Name.Local == "script":
+			if err := decoder.Skip(); err != nil {
+				return ""
+			}
+			offset = decoder.InputOffset()
+		case ok:
+			b.WriteString(withoutRawEventAttrs(text))
+		default:
+			b.WriteString(text)
+		}
+	}
+}
+
+// withoutRawEventAttrs removes the event handler attributes from the literal text
+// of a start tag. Attributes are matched one after another from the element
+// name, so text inside an attribute value is never taken for an attribute.
+func withoutRawEventAttrs(tag string) string {
+	nameEnd := strings.IndexAny(tag, " \t\r\n/>")
+	if nameEnd < 0 {
+		return tag
+	}
+	var b strings.Builder
+	b.WriteString(tag[:nameEnd])
+	rest := tag[nameEnd:]
+	for m := rawAttrRegex.FindStringSubmatchIndex(rest); m != nil; m = rawAttrRegex.FindStringSubmatchIndex(rest) {
+		if name := rest[m[2]:m[3]]; strings.Contains(name, ":") || !isEventAttr(name) {
+			b.WriteString(rest[:m[1]])
+		}
+		rest = rest[m[1]:]
+	}
+	return b.String() + rest
+}
+
+// editorPrefixes are the usual prefixes of the namespaces editors such as Inkscape
+// save their own state in, which has no effect on how a diagram is drawn
+var editorPrefixes = []string{"inkscape:", "sodipodi:", "rdf:", "sketch:"}
+
+func hasEditorPrefix(name string) bool {
+	for _, prefix := range editorPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEditorMetadata reports whether an element (by its prefixed name) is <metadata>
+// or editor markup such as <sodipodi:namedview>, which is dropped with its contents
+func isEditorMetadata(name string) bool {
+	return name == "metadata" || hasEditorPrefix(name)
+}
+
+// withoutEditorAttrs drops editor attributes such as inkscape:label or sodipodi:nodetypes
+func withoutEditorAttrs(attrs []xml.Attr) []xml.Attr {
+	kept := attrs[:0:0]
+	for _, attr := range attrs {
+		if !hasEditorPrefix(attr.Name.Local) {
+			kept = append(kept, attr)
+		}
+	}
+	return kept
+}
+
+// hasHref reports whether an element has an href or xlink:href attribute
+func hasHref(start xml.StartElement) bool {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "href" || attr.Name.Local == "xlink:href" {
+			return true
+		}
+	}
+	return false
+}
+
+// hrefOf returns the target of a link, from href or xlink:href
+func hrefOf(start xml.StartElement) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "href" || attr.Name.Local == "xlink:href" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func hasAttr(start xml.StartElement, name string) bool {
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "" && attr.Name.Local == name {
+			return true
+		}
+	}
+	return false
+}
+
+// urlRefRegex matches the start of a url(#id) reference, as in fill="url(#grad)"
+var urlRefRegex = regexp.MustCompile(`url\(\s*(['"]?)#`)
+
+// idPrefixer is a transformXML filter that prefixes every id, and every local
+// reference to one (href="#id" and url(#id)), so a second copy of a diagram can
+// sit in the same document without its ids clashing with the first
+type idPrefixer struct {
+	prefix string
+}
+
+func (p *idPrefixer) token(tok xml.Token) []xml.Token {
+	start, ok := tok.(xml.StartElement)
+	if !ok {
+		return []xml.Token{tok}
+	}
+	attrs := slices.Clone(start.Attr)
+	for i, attr := range attrs {
+		switch {
+		case attr.Name.Local == "id":
+			attrs[i].Value = p.prefix + attr.Value
+		case (attr.Name.Local == "href" || strings.HasSuffix(attr.Name.Local, ":href")) && strings.HasPrefix(attr.Value, "#"):
+			attrs[i].Value = "#" + p.prefix + attr.Value[1:]
+		default:
+			attrs[i].Value = urlRefRegex.ReplaceAllString(attr.Value, "url(${1}#"+p.prefix)
+		}
+	}
+	start.Attr = attrs
+	return []xml.Token{start}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCleanDiagramContentLinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		expect string
+	}{
+		{
+			name:   "link inside group",
+			input:  `<g id="elem_api"><a href="02-container.svg"><rect/></a></g>`,
+			expect: `<g id="elem_api" onclick="navigateDown()" style="cursor:pointer;"><rect></rect></g>`,
+		},
+		{
+			name:   "link directly wrapping rect",
+			input:  `<rect id="bg"/><a href="02-container.svg" xlink:href="02-container.svg"><rect fill="#438DD5"/></a>`,
+			expect: `<rect id="bg"></rect><g onclick="navigateDown()" style="cursor:pointer;"><rect fill="#438DD5"></rect></g>`,
+		},
+		{
+			name:   "second link in an already handled group",
+			input:  `<g><a href="a.svg"><rect/></a><a href="b.svg"><text>b</text></a></g>`,
+			expect: `<g onclick="navigateDown()" style="cursor:pointer;"><rect></rect><g onclick="navigateDown()" style="cursor:pointer;"><text>b</text></g></g>`,
+		},
+		{
+			name:   "nested links collapse to the outermost",
+			input:  `<a href="a.svg"><rect/><a href="b.svg"><text>b</text></a></a>`,
+			expect: `<g onclick="navigateDown()" style="cursor:pointer;"><rect></rect><text>b</text></g>`,
+		},
+		{
+			name:   "anchor without href is dropped",
+			input:  `<a id="x"><rect/></a>`,
+			expect: `<rect></rect>`,
+		},
+		{
+			name:   "existing style is extended",
+			input:  `<g style="opacity:0.5"><a href="a.svg"><rect/></a></g>`,
+			expect: `<g onclick="navigateDown()" style="opacity:0.5;cursor:pointer;"><rect></rect></g>`,
+		},
+		{
+			name:   "greater-than inside attribute value",
+			input:  `<g id="elem_x" data-label="a > b"><a href="a.svg"><rect/></a></g>`,
+			expect: `<g id="elem_x" data-label="a &gt; b" onclick="navigateDown()" style="cursor:pointer;"><rect></rect></g>`,
+		},
+	}
+
+	stacker := &SVGStacker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stacker.cleanDiagramContent(tt.input, "context", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expect {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestCleanDiagramContentDropsScriptsAndEvents(t *testing.T) {
+	input := `<g onmouseover="steal()"><script type="text/javascript"><![CDATA[if (a < b) { alert(1) }]]></script>` +
+		`<rect onclick="evil()" fill="#fff"/><script><g/></script></g>`
+
+	stacker := &SVGStacker{}
+	got, err := stacker.cleanDiagramContent(input, "context", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `<g><rect fill="#fff"></rect></g>`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCleanDiagramContentInvalidXML(t *testing.T) {
+	stacker := &SVGStacker{}
+	if _, err := stacker.cleanDiagramContent(`<g><rect></g>`, "context", nil); err == nil {
+		t.Errorf("expected error for mismatched tags")
+	}
+}
+
+func TestTransformXMLKeepsXlink(t *testing.T) {
+	got, err := transformXML(`<image xlink:href="data:image/png;base64,AAAA"/>`, nil, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != `<image xlink:href="data:image/png;base64,AAAA"></image>` {
+		t.Errorf("xlink:href not preserved: %s", got)
+	}
+
+	// Re-encoding the output must not introduce declarations
+	again, err := transformXML(got, nil, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again != got || strings.Contains(again, "_xmlns") {
+		t.Errorf("second pass changed output:\n%s\n%s", got, again)
+	}
+}
+
+func TestPrettyPrintXMLPreservesCDATA(t *testing.T) {
+	input := `<g><foreignObject><style><![CDATA[.a > .b { color: red; }]]></style></foreignObject>` +
+		`<text>a &lt; b</text></g>`
+
+	stacker := &SVGStacker{}
+	got := stacker.prettyPrintXML(input, nil)
+
+	if !strings.Contains(got, `<style><![CDATA[.a > .b { color: red; }]]></style>`) {
+		t.Errorf("CDATA section not preserved:\n%s", got)
+	}
+	if !strings.Contains(got, `<text>a &lt; b</text>`) {
+		t.Errorf("plain text should still be escaped:\n%s", got)
+	}
+	if err := ValidateXML("<svg>" + got + "</svg>"); err != nil {
+		t.Errorf("output is not valid XML: %v\n%s", err, got)
+	}
+}
+
+func TestPrettyPrintXMLPassesForeignObjectThrough(t *testing.T) {
+	foreign := `<foreignObject x="0" y="0" width="100" height="50">` +
+		`<xhtml:div xmlns:xhtml="http://www.w3.org/1999/xhtml" style="color:red"><xhtml:b>Bold</xhtml:b> text</xhtml:div>` +
+		`</foreignObject>`
+	input := `<g id="elem_api"><rect/>` + foreign + `<text>API</text></g>`
+
+	stacker := &SVGStacker{}
+	cleaned, err := stacker.cleanDiagramContent(input, "context", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := stacker.prettyPrintXML(cleaned, nil)
+
+	if !strings.Contains(got, foreign) {
+		t.Errorf("foreignObject was not passed through verbatim:\n%s", got)
+	}
+	if !strings.Contains(got, "\n        <text>API</text>") {
+		t.Errorf("surrounding SVG should still be pretty-printed:\n%s", got)
+	}
+}
+
+func TestCleanDiagramContentDropsScriptsInForeignObject(t *testing.T) {
+	input := `<foreignObject width="100" height="50" onload="alert(0)">` +
+		`<div xmlns="http://www.w3.org/1999/xhtml" onclick="alert(1)" title="onclick='no'" class="note">` +
+		`<script>alert(2)</script><b>Bold</b> text</div></foreignObject>`
+
+	stacker := &SVGStacker{}
+	got, err := stacker.cleanDiagramContent(input, "context", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `<foreignObject width="100" height="50">` +
+		`<div xmlns="http://www.w3.org/1999/xhtml" title="onclick='no'" class="note">` +
+		`<b>Bold</b> text</div></foreignObject>`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseSVGPreservesNamespacePrefixes(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"
+  xmlns:inkscape='http://www.inkscape.org/namespaces/inkscape' xmlns:dc="http://purl.org/dc/elements/1.1/"
+  width="400" height="300" viewBox="0 0 400 300">` +
+		`<g inkscape:label="Layer 1" inkscape:groupmode="layer"><dc:title>Diagram</dc:title>` +
+		`<image xlink:href="data:image/png;base64,AAAA"/></g></svg>`
+
+	// Editor attributes are otherwise stripped
+	stacker := NewSVGStackerWithOptions(Options{KeepMetadata: true})
+	info, err := stacker.parseSVG(svg, "context")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{`inkscape:label="Layer 1"`, `inkscape:groupmode="layer"`, `<dc:title>Diagram</dc:title>`, `xlink:href="data:image/png;base64,AAAA"`} {
+		if !strings.Contains(info.content, want) {
+			t.Errorf("expected %s in content:\n%s", want, info.content)
+		}
+	}
+	if strings.Contains(info.content, "xmlns") {
+		t.Errorf("content should not carry re-generated declarations:\n%s", info.content)
+	}
+
+	stacker.diagrams["context"] = info
+	layer := stacker.createDiagramLayer("context")
+	for _, want := range []string{`xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape"`, `xmlns:dc="http://purl.org/dc/elements/1.1/"`} {
+		if !strings.Contains(layer, want) {
+			t.Errorf("expected layer <svg> to declare %s", want)
+		}
+	}
+	if err := ValidateXML(`<svg xmlns:xlink="http://www.w3.org/1999/xlink">` + layer + `</svg>`); err != nil {
+		t.Errorf("layer is not valid XML: %v", err)
+	}
+}
+
+func TestCleanDiagramContentBackLinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		href   string
+		expect string
+	}{
+		{"down to the next level", "03-component.svg", `<g onclick="navigateDown()" style="cursor:pointer;"><rect></rect></g>`},
+		{"down past the next level", "04-code.svg#top", `<g onclick="navigateDown()" style="cursor:pointer;"><rect></rect></g>`},
+		{"unknown target", "https://example.com/docs", `<g onclick="navigateDown()" style="cursor:pointer;"><rect></rect></g>`},
+		{"itself", "#container", `<g><rect></rect></g>`},
+		{"up", "../01-context.svg?v=2", `<g><rect></rect></g>`},
+	}
+
+	stacker := &SVGStacker{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			defer func(l *Logger) { logger = l }(logger)
+			logger = &Logger{level: LogNormal, out: &buf}
+
+			got, err := stacker.cleanDiagramContent(`<g><a href="`+tt.href+`"><rect/></a></g>`, "container", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expect {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.expect)
+			}
+			if warned := strings.Contains(buf.String(), "leaving the link unclickable"); warned != strings.HasPrefix(tt.expect, "<g>") {
+				t.Errorf("unexpected warnings: %q", buf.String())
+			}
+		})
+	}
+}
+
+func TestCleanDiagramContentKeepLinks(t *testing.T) {
+	input := `<g id="elem_api"><a href="02-container.svg" xlink:href="02-container.svg" onclick="evil()"><rect/></a></g>`
+
+	stacker := NewSVGStackerWithOptions(Options{KeepLinks: true})
+	got, err := stacker.cleanDiagramContent(input, "context", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `<g id="elem_api"><a href="02-container.svg" xlink:href="02-container.svg"><rect></rect></a></g>`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTransformXMLNestedNamespaceDecls(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		expect string
+	}{
+		{
+			"redundant default SVG namespace",
+			`<g xmlns="http://www.w3.org/2000/svg"><rect/><svg:circle xmlns:svg="http://www.w3.org/2000/svg"/></g>`,
+			`<g><rect></rect><circle></circle></g>`,
+		},
+		{
+			"redundant root prefix",
+			`<g xmlns:xlink="http://www.w3.org/1999/xlink"><use xlink:href="#a"/></g>`,
+			`<g><use xlink:href="#a"></use></g>`,
+		},
+		{
+			"different prefix kept",
+			`<g xmlns:foo="http://example.com/foo"><foo:bar foo:a="1"/></g><foo:baz xmlns:foo="http://example.com/foo"/>`,
+			`<g xmlns:foo="http://example.com/foo"><foo:bar foo:a="1"></foo:bar></g><foo:baz xmlns:foo="http://example.com/foo"></foo:baz>`,
+		},
+		{
+			"different default kept",
+			`<g xmlns="http://example.com/other"><x/><y xmlns="http://example.com/other"/></g><rect/>`,
+			`<g xmlns="http://example.com/other"><x></x><y></y></g><rect></rect>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := transformXML(tt.input, nil, false, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expect {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.expect)
+			}
+		})
+	}
+
+	// End to end: a PlantUML-style nested declaration doesn't reach the layer
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="100" height="50" viewBox="0 0 100 50">` +
+		`<g xmlns="http://www.w3.org/2000/svg"><rect width="10"/></g></svg>`
+	stacker := NewSVGStacker("", "", "")
+	info, err := stacker.parseSVG(svg, "context")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(info.content, "xmlns") {
+		t.Errorf("expected the nested declaration to be stripped:\n%s", info.content)
+	}
+}
+
+func TestCanonicalAttrOrder(t *testing.T) {
+	stacker := &SVGStacker{}
+	a := `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10" viewBox="0 0 10 10">` +
+		`<g style="opacity:0.5" class="entity" id="elem_api"><a href="a.svg"><rect y="1" x="2" fill="#fff"/></a></g></svg>`
+	b := `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10" viewBox="0 0 10 10">` +
+		`<g id="elem_api" class="entity" style="opacity:0.5"><a href="a.svg"><rect fill="#fff" x="2" y="1"/></a></g></svg>`
+
+	infoA, err := stacker.parseSVG(a, "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoB, err := stacker.parseSVG(b, "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infoA.content != infoB.content {
+		t.Errorf("attribute order leaked into the output:\n%s\n%s", infoA.content, infoB.content)
+	}
+	if !strings.Contains(infoA.content, `<g id="elem_api" class="entity" onclick="navigateDown()" style="opacity:0.5;cursor:pointer;">`) ||
+		!strings.Contains(infoA.content, `<rect fill="#fff" x="2" y="1">`) {
+		t.Errorf("expected id and class first, then alphabetical, got:\n%s", infoA.content)
+	}
+}
+
+func TestCleanDiagramContentStripsEditorMetadata(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"
+  xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" xmlns:sodipodi="http://sodipodi.sourceforge.net/DTD/sodipodi-0.dtd"
+  xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:dc="http://purl.org/dc/elements/1.1/"
+  width="400" height="300" viewBox="0 0 400 300">` +
+		`<sodipodi:namedview id="base" pagecolor="#ffffff" inkscape:zoom="1.4"><inkscape:grid type="xygrid"/></sodipodi:namedview>` +
+		`<metadata><rdf:RDF><dc:title>Diagram</dc:title></rdf:RDF></metadata>` +
+		`<title>System Context</title>` +
+		`<g id="layer1" inkscape:label="Layer 1" inkscape:groupmode="layer"><path sodipodi:nodetypes="cc" d="M0,0 L10,10" stroke="#000"/>` +
+		`<text x="5" y="20">API</text></g></svg>`
+
+	info, err := NewSVGStacker("", "", "").parseSVG(svg, "context")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, unwanted := range []string{"namedview", "inkscape:", "sodipodi:", "<metadata", "rdf:", "dc:title"} {
+		if strings.Contains(info.content, unwanted) {
+			t.Errorf("expected %s to be stripped:\n%s", unwanted, info.content)
+		}
+	}
+	for _, want := range []string{`<title>System Context</title>`, `<g id="layer1">`, `<path d="M0,0 L10,10" stroke="#000"></path>`, `<text x="5" y="20">API</text>`} {
+		if !strings.Contains(info.content, want) {
+			t.Errorf("expected %s to survive:\n%s", want, info.content)
+		}
+	}
+
+	kept, err := NewSVGStackerWithOptions(Options{KeepMetadata: true}).parseSVG(svg, "context")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"<sodipodi:namedview", "<metadata>", `inkscape:label="Layer 1"`} {
+		if !strings.Contains(kept.content, want) {
+			t.Errorf("expected %s with KeepMetadata:\n%s", want, kept.content)
+		}
+	}
+	if opts, err := parseArgsSlice([]string{"dir", "--keep-metadata"}); err != nil || !opts.KeepMetadata {
+		t.Errorf("--keep-metadata: got %v, %v", opts.KeepMetadata, err)
+	}
+}
+
+func TestWrapLinkLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		expect string
+	}{
+		{
+			name:  "long label",
+			input: `<g class="link"><path d="M0,0"/><text font-size="12" lengthAdjust="spacing" textLength="180" x="20" y="40">Reads and writes account data</text></g>`,
+			expect: `<g class="link"><path d="M0,0"></path><text font-size="12" x="20" y="40">` +
+				`<tspan dy="-2.4em" x="20">Reads and</tspan><tspan dy="1.2em" x="20">writes</tspan><tspan dy="1.2em" x="20">account data</tspan></text></g>`,
+		},
+		{
+			name:   "short label",
+			input:  `<g class="link"><text textLength="30" x="20" y="40">Uses</text></g>`,
+			expect: `<g class="link"><text textLength="30" x="20" y="40">Uses</text></g>`,
+		},
+		{
+			name:   "outside a link",
+			input:  `<g class="entity"><text x="20" y="40">Internet Banking System</text></g>`,
+			expect: `<g class="entity"><text x="20" y="40">Internet Banking System</text></g>`,
+		},
+		{
+			name:   "label with markup",
+			input:  `<g class="link"><text x="20" y="40">Reads and <tspan>writes</tspan> account data</text></g>`,
+			expect: `<g class="link"><text x="20" y="40">Reads and <tspan>writes</tspan> account data</text></g>`,
+		},
+		{
+			name:   "overlong word",
+			input:  `<g class="link highlighted"><text>Uses getAccountBalances</text></g>`,
+			expect: `<g class="link highlighted"><text><tspan dy="-1.2em">Uses</tspan><tspan dy="1.2em">getAccountBalances</tspan></text></g>`,
+		},
+	}
+
+	stacker := NewSVGStackerWithOptions(Options{WrapLinkLabels: 12})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stacker.cleanDiagramContent(tt.input, "context", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expect {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.expect)
+			}
+		})
+	}
+
+	if opts, err := parseArgsSlice([]string{"dir", "--wrap-link-labels", "20"}); err != nil || opts.WrapLinkLabels != 20 {
+		t.Errorf("--wrap-link-labels: got %d, %v", opts.WrapLinkLabels, err)
+	}
+	if _, err := parseArgsSlice([]string{"dir", "--wrap-link-labels", "0"}); err == nil {
+		t.Errorf("expected an error for a zero width")
+	}
+}